@@ -2,11 +2,19 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"wb-kafka-service/internal/analytics"
+	"wb-kafka-service/internal/auth"
 	"wb-kafka-service/internal/cache"
 	"wb-kafka-service/internal/config"
 	"wb-kafka-service/internal/handlers"
 	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/internal/web"
+	"wb-kafka-service/pkg/breaker"
 	"wb-kafka-service/pkg/logger"
 	"wb-kafka-service/pkg/postgres"
 )
@@ -29,17 +37,136 @@ func main() {
 	}
 	defer pool.Close()
 
-	postgresDB := postgres.NewPostgresDB(pool, log)
+	dbBreaker := newBreaker("postgres", cfg.Breakers.Postgres, postgres.IsFailure, log)
+	cacheBreaker := newBreaker("memcached", cfg.Breakers.Memcached, cache.IsFailure, log)
+	kafkaBreaker := newBreaker("kafka", cfg.Breakers.Kafka, nil, log)
+	breakers := []*breaker.Breaker{dbBreaker, cacheBreaker, kafkaBreaker}
 
-	memCacheClient := cache.NewMemCache("127.0.0.1:11211")
+	postgresDB := postgres.NewBreakerPostgresDB(postgres.NewPostgresDB(pool, log), dbBreaker)
 
+	cacheOpts, err := cache.NewOptions(cfg)
+	if err != nil {
+		log.Fatal("Failed to configure cache", err)
+	}
+
+	memCacheClient := cache.NewBreakerMemCache(cache.NewMemCache("127.0.0.1:11211"), cacheBreaker)
+	orderLoader := cache.NewOrderLoader(cacheOpts, memCacheClient, postgresDB, log)
+
+	outboxProducer, err := kafka.NewOutboxProducer(cfg, log)
+	if err != nil {
+		log.Fatal("Failed to create outbox producer", err)
+	}
+	defer outboxProducer.Close()
+	outboxProducer.UseBreaker(kafkaBreaker)
+
+	// Cancelled before the producers are closed, which stops the relay and the
+	// consumer first.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay := kafka.NewOutboxRelay(cfg, postgresDB, outboxProducer, log)
+	go relay.Run(ctx)
+	go kafka.NewLedgerSweeper(cfg, postgresDB, log).Run(ctx)
+	go kafka.NewOutboxSweeper(cfg, postgresDB, log).Run(ctx)
+
+	consumer := kafka.NewConsumer(cfg, postgresDB, log, memCacheClient, cacheOpts)
+	consumer.PauseWhen("postgres circuit breaker open", dbBreaker.IsOpen)
 	go func() {
 		log.Info("Starting Kafka consumer...")
-		kafka.InitKafka(cfg, postgresDB, log, memCacheClient)
+		consumer.Run(ctx)
 	}()
 
-	http.HandleFunc("/order", func(w http.ResponseWriter, r *http.Request) {
-		handlers.HandlerOrder(log, memCacheClient, postgresDB, w, r)
+	renderer, err := web.NewRenderer(log, cfg.Web.DevDir)
+	if err != nil {
+		log.Fatal("Failed to parse templates", err)
+	}
+
+	authenticator, err := auth.New(cfg)
+	if err != nil {
+		log.Fatal("Failed to configure authentication", err)
+	}
+	if authenticator == nil {
+		log.Warn("No authentication configured: order endpoints are open and the admin API is disabled", nil)
+	}
+
+	// Rate limits apply before authentication, so credential guessing is
+	// limited too; the DB concurrency limit only once a caller is let in.
+	limits := handlers.NewLimits(cfg)
+	public := func(pattern string, handler http.HandlerFunc) {
+		http.HandleFunc(pattern, handlers.RateLimit(log, limits, handler))
+	}
+	reader := func(pattern string, handler http.HandlerFunc) {
+		public(pattern, handlers.RequireReader(log, authenticator, handler))
+	}
+	admin := func(pattern string, handler http.HandlerFunc) {
+		public(pattern, handlers.RequireAdmin(log, authenticator, handler))
+	}
+	usesDB := func(handler http.HandlerFunc) http.HandlerFunc {
+		return handlers.LimitConcurrency(log, limits, handler)
+	}
+
+	http.Handle("/static/", renderer.StaticHandler())
+	public("/", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerIndex(log, renderer, w, r)
+	})
+	reader("/order", usesDB(func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerOrder(log, orderLoader, renderer, w, r)
+	}))
+
+	reader("/api/v1/customers/{customer_id}/orders", usesDB(func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerCustomerOrders(log, postgresDB, w, r)
+	}))
+
+	reports := analytics.NewReports(log, postgresDB, memCacheClient, cfg.Reports.TTL)
+	reader("/api/v1/reports/{report}", usesDB(func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerReport(log, reports, w, r)
+	}))
+
+	// Exports carry personal data of every customer, so only admins get them.
+	admin("/api/v1/orders/export", usesDB(func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerExport(log, postgresDB, w, r)
+	}))
+
+	// EventSource can't set headers, so only the stream takes the token as a
+	// query parameter; it is moved to the header before rate limiting.
+	http.HandleFunc("/orders/stream", handlers.AllowQueryToken(handlers.RateLimit(log, limits,
+		handlers.RequireReader(log, authenticator, func(w http.ResponseWriter, r *http.Request) {
+			handlers.HandlerOrderStream(log, kafka.Feed, cfg, w, r)
+		}))))
+	public("/live", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerLive(log, renderer, w, r)
+	})
+
+	admin("/admin/consumer", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerConsumerStatus(log, consumer, w, r)
+	})
+	admin("/admin/consumer/pause", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerConsumerPause(log, consumer, w, r)
+	})
+	admin("/admin/consumer/resume", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerConsumerResume(log, consumer, w, r)
+	})
+	admin("/admin/consumer/partitions", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerConsumerPartitions(log, consumer, w, r)
+	})
+	admin("/admin/cache/orders/{id}/evict", usesDB(func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerCacheEvict(log, postgresDB, memCacheClient, w, r)
+	}))
+	admin("/admin/cache/orders/{id}/refresh", usesDB(func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerCacheRefresh(log, postgresDB, memCacheClient, cacheOpts, w, r)
+	}))
+	admin("/admin/cache/families/{family}/flush", usesDB(func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerCacheFlush(log, postgresDB, memCacheClient, w, r)
+	}))
+	admin("/admin/cache/warmup", usesDB(func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerCacheWarmUp(log, postgresDB, memCacheClient, cacheOpts, w, r)
+	}))
+	admin("/admin/log-level", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerLogLevel(log, w, r)
+	})
+
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerMetrics(log, breakers, limits, w, r)
 	})
 
 	log.Info("Starting HTTP server on :8080")
@@ -49,11 +176,18 @@ func main() {
 		}
 	}()
 
+	producer, err := kafka.NewOrderProducer(cfg, log)
+	if err != nil {
+		log.Fatal("Failed to create Kafka producer", err)
+	}
+	defer producer.Close()
+	producer.UseBreaker(kafkaBreaker)
+
 	order, err := postgresDB.GetOrderFromDB(context.Background(), 1)
 	if err != nil {
 		log.Error("Failed to get order from DB", err)
 	} else {
-		err = kafka.ProduceOrder(cfg, order, log)
+		err = producer.PublishOrder(context.Background(), kafka.EventOrderCreated, order)
 		if err != nil {
 			log.Error("Failed to produce order to Kafka", err)
 		} else {
@@ -61,5 +195,19 @@ func main() {
 		}
 	}
 
-	select {}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	log.Info("Shutting down")
+}
+
+func newBreaker(name string, cfg config.BreakerConfig, isFailure func(error) bool, log logger.Logger) *breaker.Breaker {
+	return breaker.New(name, breaker.Settings{
+		FailureThreshold: cfg.FailureThreshold,
+		Cooldown:         cfg.Cooldown,
+		IsFailure:        isFailure,
+		OnStateChange: func(name string, from, to breaker.State) {
+			log.Warn(fmt.Sprintf("Circuit breaker %s: %s -> %s", name, from, to), nil)
+		},
+	})
 }
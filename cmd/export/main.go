@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/export"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+)
+
+const usage = `Usage: export [flags]
+
+Writes orders from the DB as flattened rows, one per item, with the order,
+delivery and payment fields repeated on every row. Rows are read through a
+server-side cursor, so memory stays constant whatever the size of the export.
+
+`
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", export.FormatCSV, strings.Join(export.Formats, ", "))
+	output := fs.String("o", "-", `output file, "-" for stdout`)
+	from := fs.String("from", "", "first date_created to export, YYYY-MM-DD")
+	to := fs.String("to", "", "last date_created to export, YYYY-MM-DD")
+	customerID := fs.String("customer", "", "export only orders of this customer_id")
+	deliveryService := fs.String("delivery-service", "", "export only orders of this delivery service")
+	status := fs.String("status", "", `export only orders with this status, e.g. "active"`)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	rng, err := postgres.DateRange(*from, *to)
+	if err == nil && !slices.Contains(export.Formats, *format) {
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	filter := postgres.ExportFilter{Range: rng, CustomerID: *customerID, DeliveryService: *deliveryService, Status: *status}
+
+	log, err := logger.NewLogger("export.log", false)
+	if err != nil {
+		panic("Failed to create logger: " + err.Error())
+	}
+	defer log.Close()
+
+	cfg, err := config.GetConfig(log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to get config:", err)
+		return 1
+	}
+
+	pool, err := postgres.ConnectDB(log, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to connect to DB:", err)
+		return 1
+	}
+	defer pool.Close()
+
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if *output != "-" {
+		file, err = os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+
+	writer, err := export.NewWriter(*format, out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	rows, err := export.Orders(ctx, postgres.NewPostgresDB(pool, log), filter, writer, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed after %d rows: %v\n", rows, err)
+		return 1
+	}
+	// Write errors of a file may only show up when it is closed.
+	if file != nil {
+		if err := file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed after %d rows: %v\n", rows, err)
+			return 1
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d rows in %s\n", rows, time.Since(start).Round(time.Millisecond))
+	return 0
+}
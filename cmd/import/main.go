@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/importer"
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+	"wb-kafka-service/pkg/unmarshal"
+)
+
+const usage = `Usage: import [flags] [file or directory]...
+
+Imports orders from JSON files holding one order, a JSON array of orders or
+NDJSON, or from stdin when no path or "-" is given. Directories are searched
+for .json, .ndjson and .jsonl files. Every order is validated and the valid
+ones are inserted into the DB in batches, or published to Kafka with -publish.
+
+The report lists the accepted, duplicate and rejected orders, with the
+file:line each one starts at. An order_uid already read, or already in the
+DB, is a duplicate.
+
+`
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	publish := fs.Bool("publish", false, "publish order.created events to Kafka instead of inserting into the DB")
+	batch := fs.Int("batch", importer.DefaultBatchSize, "orders stored per DB transaction or Kafka write")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if *batch < 1 {
+		fmt.Fprintln(os.Stderr, "-batch must be positive")
+		return 2
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	log, err := logger.NewLogger("import.log", false)
+	if err != nil {
+		panic("Failed to create logger: " + err.Error())
+	}
+	defer log.Close()
+
+	cfg, err := config.GetConfig(log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to get config:", err)
+		return 1
+	}
+
+	var sink importer.Sink
+	if *publish {
+		// Sync writes report the outcome of every order; one write per batch.
+		cfg.Kafka.Producer.Async = false
+		cfg.Kafka.Producer.BatchSize = *batch
+		producer, err := kafka.NewOrderProducer(cfg, log)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to create Kafka producer:", err)
+			return 1
+		}
+		defer producer.Close()
+		sink = importer.KafkaSink(producer)
+	} else {
+		pool, err := postgres.ConnectDB(log, cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to connect to DB:", err)
+			return 1
+		}
+		defer pool.Close()
+		sink = importer.DBSink(postgres.NewPostgresDB(pool, log))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	im := importer.NewImporter(sink, *batch)
+	status := 0
+	err = importPaths(ctx, im, paths, &status)
+	if err == nil {
+		err = im.Flush(ctx)
+	} else {
+		// Every order read is in the report, including those of the batch
+		// that wasn't stored.
+		im.Abort(err)
+	}
+
+	report := im.Report()
+	report.Print(os.Stdout)
+	fmt.Fprintf(os.Stderr, "done in %s\n", time.Since(start).Round(time.Millisecond))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Import stopped:", err)
+		return 1
+	}
+	if len(report.Rejected) > 0 || len(report.Failed) > 0 {
+		return 1
+	}
+	return status
+}
+
+// importPaths reads every path, "-" being stdin. Paths that can't be read are
+// reported and set status to 1.
+func importPaths(ctx context.Context, im *importer.Importer, paths []string, status *int) error {
+	for _, path := range paths {
+		if path == "-" {
+			if err := im.Read(ctx, "stdin", os.Stdin); err != nil {
+				return err
+			}
+			continue
+		}
+
+		files, err := unmarshal.OrderFiles(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			*status = 1
+			continue
+		}
+		for _, name := range files {
+			file, err := os.Open(name)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				*status = 1
+				continue
+			}
+			err = im.Read(ctx, name, file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/generator"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+	"wb-kafka-service/pkg/unmarshal"
+)
+
+func runFiles(cfg config.AppConfig, log logger.Logger, args []string) (*summary, error) {
+	fs := flag.NewFlagSet("files", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "validate orders without publishing them")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: notifier files [-dry-run] <file or directory>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return nil, errors.New("no files or directories given")
+	}
+
+	p, err := newPublisher(cfg, log, *dryRun)
+	if err != nil {
+		return nil, err
+	}
+	defer p.close()
+	for _, path := range fs.Args() {
+		files, err := unmarshal.OrderFiles(path)
+		if err != nil {
+			p.skip(path, err.Error())
+			continue
+		}
+		if len(files) == 0 {
+			p.skip(path, "no order files")
+			continue
+		}
+		for _, name := range files {
+			publishFile(p, name)
+		}
+	}
+
+	return &p.summary, nil
+}
+
+// publishFile publishes the orders in file name. Values that don't decode are
+// counted as invalid; a file that can't be read, or holds no orders, is skipped.
+func publishFile(p *publisher, name string) {
+	file, err := os.Open(name)
+	if err != nil {
+		p.skip(name, err.Error())
+		return
+	}
+	defer file.Close()
+
+	values := 0
+	err = unmarshal.ReadOrders(name, file, func(pos unmarshal.Position, order *models.Order, err error) error {
+		values++
+		if err != nil {
+			p.reject(pos.String(), err)
+			return nil
+		}
+		p.publish(pos.String(), order)
+		return nil
+	})
+	if err != nil {
+		p.skip(name, err.Error())
+		return
+	}
+	if values == 0 {
+		p.skip(name, "no orders")
+	}
+}
+
+func runDB(cfg config.AppConfig, log logger.Logger, args []string) (*summary, error) {
+	fs := flag.NewFlagSet("db", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "validate orders without publishing them")
+	from := fs.Int("from", 1, "first order id to publish")
+	to := fs.Int("to", 0, "last order id to publish (defaults to -from)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *to == 0 {
+		*to = *from
+	}
+	if *from < 1 || *to < *from {
+		return nil, fmt.Errorf("invalid order id range %d..%d", *from, *to)
+	}
+
+	pool, err := postgres.ConnectDB(log, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Close()
+
+	postgresDB := postgres.NewPostgresDB(pool, log)
+
+	p, err := newPublisher(cfg, log, *dryRun)
+	if err != nil {
+		return nil, err
+	}
+	defer p.close()
+	for id := *from; id <= *to; id++ {
+		source := "order " + strconv.Itoa(id)
+		order, err := postgresDB.GetOrderFromDB(context.Background(), id)
+		if errors.Is(err, postgres.ErrOrderNotFound) {
+			p.skip(source, "not found")
+			continue
+		}
+		if err != nil {
+			p.fail(source, err)
+			continue
+		}
+
+		p.publish(source, order)
+	}
+
+	return &p.summary, nil
+}
+
+func runGenerate(cfg config.AppConfig, log logger.Logger, args []string) (*summary, error) {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "validate orders without publishing them")
+	count := fs.Int("n", 10, "number of orders to publish")
+	rate := fs.Float64("rate", 10, "orders per second, 0 for as fast as possible")
+	seed := fs.Int64("seed", 0, "random seed, 0 for a time-based one")
+	invalidShare := fs.Float64("invalid", 0, "share of orders (0..1) made invalid on purpose; they are sent without validation")
+	defectList := fs.String("defects", "", "comma-separated defects for invalid orders, random by default: "+defectNames())
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	defects, err := generator.ParseDefects(*defectList)
+	if err != nil {
+		return nil, err
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	log.Info(fmt.Sprintf("Generating %d orders with seed %d", *count, *seed))
+	gen := generator.New(*seed)
+	pick := rand.New(rand.NewSource(*seed))
+
+	var tick <-chan time.Time
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	p, err := newPublisher(cfg, log, *dryRun)
+	if err != nil {
+		return nil, err
+	}
+	defer p.close()
+	for i := 0; i < *count; i++ {
+		if tick != nil {
+			<-tick
+		}
+
+		source := "generated #" + strconv.Itoa(i+1)
+		if pick.Float64() < *invalidShare {
+			order := gen.InvalidOrder(defects...)
+			if *dryRun {
+				p.publish(source, &order)
+			} else {
+				p.send(source, &order)
+			}
+			continue
+		}
+
+		order := gen.Order()
+		p.publish(source, &order)
+	}
+
+	return &p.summary, nil
+}
+
+func defectNames() string {
+	var names []string
+	for _, d := range generator.Defects() {
+		names = append(names, string(d))
+	}
+	return strings.Join(names, ", ")
+}
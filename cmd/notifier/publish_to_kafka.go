@@ -1,42 +1,73 @@
 package main
 
 import (
-	"context"
+	"fmt"
+	"os"
 	"wb-kafka-service/internal/config"
-	"wb-kafka-service/internal/kafka"
 	"wb-kafka-service/pkg/logger"
-	"wb-kafka-service/pkg/postgres"
 )
 
+const usage = `Usage: notifier <command> [flags]
+
+Commands:
+  files     publish orders from JSON files or directories
+  db        publish a range of orders stored in Postgres
+  generate  publish synthetic orders at a target rate
+
+Run "notifier <command> -h" for the flags of a command.
+Without a command, order 1 is published from Postgres.
+The exit status is 1 if any order was invalid, failed or was skipped, such
+as an unreadable file or an order id not in the DB.
+`
+
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	log, err := logger.NewLogger("notify.log", true)
 	if err != nil {
 		panic("Failed to create logger: " + err.Error())
 	}
 	defer log.Close()
 
-	cfg, err := config.GetConfig(log)
-	if err != nil {
-		log.Fatal("Failed to get config", err)
+	command, args := "db", []string{}
+	if len(os.Args) > 1 {
+		command, args = os.Args[1], os.Args[2:]
 	}
 
-	pool, err := postgres.ConnectDB(log, cfg)
-	if err != nil {
-		log.Fatal("Failed to connect to DB", err)
+	var runCommand func(cfg config.AppConfig, log logger.Logger, args []string) (*summary, error)
+	switch command {
+	case "files":
+		runCommand = runFiles
+	case "db":
+		runCommand = runDB
+	case "generate":
+		runCommand = runGenerate
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		return 2
 	}
-	defer pool.Close()
 
-	postgresDB := postgres.NewPostgresDB(pool, log)
+	cfg, err := config.GetConfig(log)
+	if err != nil {
+		log.Error("Failed to get config", err)
+		return 1
+	}
 
-	order, err := postgresDB.GetOrderFromDB(context.Background(), 1)
+	s, err := runCommand(cfg, log, args)
+	if s != nil {
+		s.print(os.Stdout)
+	}
 	if err != nil {
-		log.Error("Failed to get order from DB", err)
-	} else {
-		err = kafka.ProduceOrder(cfg, order, log)
-		if err != nil {
-			log.Error("Failed to produce order to Kafka", err)
-		} else {
-			log.Info("Order produced successfully")
-		}
+		log.Error(fmt.Sprintf("Command %s failed", command), err)
+		return 1
+	}
+	if s.failed > 0 || s.invalid > 0 || s.skipped > 0 {
+		return 1
 	}
+	return 0
 }
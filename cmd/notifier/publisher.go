@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/pkg/logger"
+)
+
+// summary counts what happened to every order a command handled. Delivery
+// reports update it from the producer's goroutines, hence the mutex.
+type summary struct {
+	mu        sync.Mutex
+	dryRun    bool
+	published int
+	invalid   int
+	failed    int
+	skipped   int
+	started   time.Time
+	errors    []string
+}
+
+func (s *summary) add(counter *int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*counter++
+	if message != "" {
+		s.errors = append(s.errors, message)
+	}
+}
+
+func (s *summary) print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	verb := "published"
+	if s.dryRun {
+		verb = "valid (dry run)"
+	}
+	fmt.Fprintf(w, "%s: %d, invalid: %d, failed: %d, skipped: %d in %s\n",
+		verb, s.published, s.invalid, s.failed, s.skipped, time.Since(s.started).Round(time.Millisecond))
+	if len(s.errors) > 0 {
+		fmt.Fprintf(w, "errors:\n  %s\n", strings.Join(s.errors, "\n  "))
+	}
+}
+
+// publisher validates orders and publishes them to Kafka, or only validates
+// them in dry-run mode.
+type publisher struct {
+	producer *kafka.OrderProducer
+	summary  summary
+}
+
+func newPublisher(cfg config.AppConfig, log logger.Logger, dryRun bool) (*publisher, error) {
+	p := &publisher{summary: summary{dryRun: dryRun, started: time.Now()}}
+	if dryRun {
+		return p, nil
+	}
+
+	producer, err := kafka.NewOrderProducer(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	producer.OnDelivery(func(report kafka.DeliveryReport) {
+		if report.Err != nil {
+			p.summary.add(&p.summary.failed, fmt.Sprintf("order %s: %v", report.OrderUid, report.Err))
+			return
+		}
+		p.summary.add(&p.summary.published, "")
+	})
+	p.producer = producer
+
+	return p, nil
+}
+
+// publish validates order and, unless this is a dry run, hands it to the
+// producer. In async mode the outcome is counted when its delivery report arrives.
+func (p *publisher) publish(source string, order *models.Order) {
+	err := validation.Default.Validate(order)
+	if err != nil {
+		p.summary.add(&p.summary.invalid, fmt.Sprintf("%s: invalid order %s: %v", source, order.OrderUid, err))
+		return
+	}
+
+	if p.producer == nil {
+		p.summary.add(&p.summary.published, "")
+		return
+	}
+
+	p.send(source, order)
+}
+
+// send publishes order without validating it.
+func (p *publisher) send(source string, order *models.Order) {
+	err := p.producer.PublishOrder(context.Background(), kafka.EventOrderCreated, order)
+	if err != nil {
+		p.summary.add(&p.summary.failed, fmt.Sprintf("%s: order %s: %v", source, order.OrderUid, err))
+		return
+	}
+	if !p.producer.Async() {
+		p.summary.add(&p.summary.published, "")
+	}
+}
+
+// reject counts a value that couldn't be decoded as an order.
+func (p *publisher) reject(source string, err error) {
+	p.summary.add(&p.summary.invalid, fmt.Sprintf("%s: %v", source, err))
+}
+
+func (p *publisher) skip(source string, reason string) {
+	p.summary.add(&p.summary.skipped, fmt.Sprintf("%s: skipped: %s", source, reason))
+}
+
+func (p *publisher) fail(source string, err error) {
+	p.summary.add(&p.summary.failed, fmt.Sprintf("%s: %v", source, err))
+}
+
+// close flushes pending events, so the summary is complete afterwards.
+func (p *publisher) close() {
+	if p.producer != nil {
+		p.producer.Close()
+	}
+}
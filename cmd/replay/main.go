@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+)
+
+const usage = `Usage: replay [flags]
+
+Re-reads the orders topic from the given offsets or time, outside the consumer
+group, and runs every message through the consumer's pipeline. Each partition
+is replayed up to its end offset at start. Without -write messages are only
+decoded and validated.
+
+`
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	partitionList := fs.String("partitions", "", "comma-separated partitions to replay, all by default, or those named in -offset pairs")
+	offsetList := fs.String("offset", "", `start offset for every partition, or per partition as "0=120,1=80"`)
+	since := fs.String("since", "", "start at the first message at or after this RFC 3339 time")
+	write := fs.Bool("write", false, "apply the messages to the DB and the cache instead of a dry run")
+	ignoreLedger := fs.Bool("ignore-ledger", false, "reapply events already in the processed-messages ledger, without adding them to the order history or republishing them")
+	interval := fs.Duration("progress", 2*time.Second, "progress report interval")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	opts, err := kafka.ParseReplayOptions(*partitionList, *offsetList, *since)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	opts.DryRun = !*write
+	opts.IgnoreLedger = *ignoreLedger
+
+	log, err := logger.NewLogger("replay.log", false)
+	if err != nil {
+		panic("Failed to create logger: " + err.Error())
+	}
+	defer log.Close()
+
+	cfg, err := config.GetConfig(log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to get config:", err)
+		return 1
+	}
+
+	cacheOpts, err := cache.NewOptions(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to configure cache:", err)
+		return 1
+	}
+
+	var db postgres.PostgresDB
+	var cacheClient cache.MemCacheClient
+	if *write {
+		pool, err := postgres.ConnectDB(log, cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to connect to DB:", err)
+			return 1
+		}
+		defer pool.Close()
+
+		db = postgres.NewPostgresDB(pool, log)
+		cacheClient = cache.NewMemCache(cfg.Memcached.Host + ":" + cfg.Memcached.Port)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	replayer := kafka.NewReplayer(cfg, db, log, cacheClient, cacheOpts, opts)
+
+	done := make(chan error, 1)
+	go func() { done <- replayer.Run(ctx) }()
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			printProgress(os.Stderr, replayer.Progress(), opts.DryRun)
+		case err := <-done:
+			progress := replayer.Progress()
+			printProgress(os.Stdout, progress, opts.DryRun)
+			if len(progress.Errors) > 0 {
+				fmt.Fprintf(os.Stdout, "errors:\n  %s\n", strings.Join(progress.Errors, "\n  "))
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Replay failed:", err)
+				return 1
+			}
+			if progress.Failed > 0 || progress.Invalid > 0 {
+				return 1
+			}
+			return 0
+		}
+	}
+}
+
+func printProgress(w io.Writer, p kafka.ReplayProgress, dryRun bool) {
+	verb := "processed"
+	if dryRun {
+		verb = "valid (dry run)"
+	}
+	var parts []string
+	for _, pp := range p.Partitions {
+		parts = append(parts, fmt.Sprintf("%d: %d/%d", pp.Partition, pp.Next-pp.Start, pp.End-pp.Start))
+	}
+	fmt.Fprintf(w, "%s: %d, duplicates: %d, invalid: %d, failed: %d [%s]\n",
+		verb, p.Processed, p.Duplicates, p.Invalid, p.Failed, strings.Join(parts, ", "))
+}
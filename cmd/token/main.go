@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+	"wb-kafka-service/internal/auth"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/pkg/logger"
+)
+
+const usage = `Usage: token -sub NAME -role ROLE [-ttl DURATION]
+
+Prints an HMAC token signed with auth.hmac.secret from the config. Send it as
+"Authorization: Bearer <token>", or to /orders/stream as the access_token
+query parameter.
+
+`
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	subject := fs.String("sub", "", "who the token is for")
+	roleName := fs.String("role", string(auth.RoleSupport), `"admin" or "support"`)
+	ttl := fs.Duration("ttl", 24*time.Hour, "how long the token is valid")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	role, err := auth.ParseRole(*roleName)
+	if err == nil && *subject == "" {
+		err = fmt.Errorf("-sub is required")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	log, err := logger.NewLogger("", false)
+	if err != nil {
+		panic("Failed to create logger: " + err.Error())
+	}
+	defer log.Close()
+
+	cfg, err := config.GetConfig(log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to get config:", err)
+		return 1
+	}
+	if cfg.Auth.HMAC.Secret == "" {
+		fmt.Fprintln(os.Stderr, "auth.hmac.secret is not set")
+		return 1
+	}
+
+	token, err := auth.IssueHMACToken([]byte(cfg.Auth.HMAC.Secret), *subject, role, *ttl)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to issue token:", err)
+		return 1
+	}
+	fmt.Println(token)
+	return 0
+}
@@ -0,0 +1,201 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/breaker"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// Names of the reports, as used in /api/v1/reports/{report}.
+const (
+	ReportRevenue          = "revenue"
+	ReportTopProducts      = "top-products"
+	ReportDeliveryCosts    = "delivery-costs"
+	ReportSaleDistribution = "sale-distribution"
+)
+
+// Names lists every report.
+var Names = []string{ReportRevenue, ReportTopProducts, ReportDeliveryCosts, ReportSaleDistribution}
+
+// Table is a report that can be written as CSV.
+type Table interface {
+	Header() []string
+	Records() [][]string
+}
+
+// Result is a report with the time it was computed; a cached report may be up
+// to the report TTL old.
+type Result[T Table] struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Rows        T         `json:"rows"`
+}
+
+// Reports computes reports in the DB and caches them in memcache for ttl.
+// While memcache is down every report is computed again, but concurrent
+// requests for the same report share one computation.
+type Reports struct {
+	log   logger.Logger
+	db    postgres.PostgresDB
+	cache cache.MemCacheClient
+	ttl   time.Duration
+	group cache.FlightGroup[any]
+}
+
+func NewReports(log logger.Logger, db postgres.PostgresDB, cacheClient cache.MemCacheClient, ttl time.Duration) *Reports {
+	return &Reports{log: log, db: db, cache: cacheClient, ttl: ttl}
+}
+
+// Revenue sums payment amounts by day, currency and provider.
+func (r *Reports) Revenue(ctx context.Context, rng postgres.ReportRange) (Result[RevenueTable], error) {
+	return cached(ctx, r, cache.ReportKey(ReportRevenue, rng.From, rng.To), func(ctx context.Context) (RevenueTable, error) {
+		rows, err := r.db.RevenueByDay(ctx, rng)
+		return append(RevenueTable{}, rows...), err
+	})
+}
+
+// TopProducts ranks brands or nm_ids, see postgres.TopProducts.
+func (r *Reports) TopProducts(ctx context.Context, rng postgres.ReportRange, by, sortBy string, limit int) (Result[ProductTable], error) {
+	key := cache.ReportKey(ReportTopProducts, rng.From, rng.To, by, sortBy, strconv.Itoa(limit))
+	return cached(ctx, r, key, func(ctx context.Context) (ProductTable, error) {
+		rows, err := r.db.TopProducts(ctx, rng, by, sortBy, limit)
+		return append(ProductTable{}, rows...), err
+	})
+}
+
+// DeliveryCosts averages delivery costs by delivery service and region.
+func (r *Reports) DeliveryCosts(ctx context.Context, rng postgres.ReportRange) (Result[DeliveryCostTable], error) {
+	return cached(ctx, r, cache.ReportKey(ReportDeliveryCosts, rng.From, rng.To), func(ctx context.Context) (DeliveryCostTable, error) {
+		rows, err := r.db.DeliveryCosts(ctx, rng)
+		return append(DeliveryCostTable{}, rows...), err
+	})
+}
+
+// SaleDistribution counts sold items by sale percentage in buckets of width points.
+func (r *Reports) SaleDistribution(ctx context.Context, rng postgres.ReportRange, width int) (Result[SaleTable], error) {
+	key := cache.ReportKey(ReportSaleDistribution, rng.From, rng.To, strconv.Itoa(width))
+	return cached(ctx, r, key, func(ctx context.Context) (SaleTable, error) {
+		rows, err := r.db.SaleDistribution(ctx, rng, width)
+		return append(SaleTable{}, rows...), err
+	})
+}
+
+// cached returns the report under key from memcache, or computes it with load
+// and caches it. load returns an empty rather than nil table, so reports
+// without rows are encoded as []. While the memcache breaker is open nothing
+// is logged here; the breaker logs its state changes.
+func cached[T Table](ctx context.Context, r *Reports, key string, load func(ctx context.Context) (T, error)) (Result[T], error) {
+	var result Result[T]
+
+	item, err := r.cache.Get(key)
+	if err == nil {
+		err = json.Unmarshal(item.Value, &result)
+		if err == nil {
+			return result, nil
+		}
+		r.log.Error(fmt.Sprintf("Error decoding cached report %s", key), err)
+	} else if !errors.Is(err, memcache.ErrCacheMiss) && !errors.Is(err, breaker.ErrOpen) {
+		r.log.Warn(fmt.Sprintf("Error reading cached report %s", key), err)
+	}
+
+	// A report query can run for a while. It is finished and cached even if
+	// the client that asked for it disconnects, as other requests for the same
+	// report may be waiting on it.
+	shared, err, _ := r.group.Do(key, func() (any, error) {
+		return compute(r, key, func() (T, error) { return load(context.WithoutCancel(ctx)) })
+	})
+	if err != nil {
+		return result, err
+	}
+	return shared.(Result[T]), nil
+}
+
+// compute runs load and caches its result under key.
+func compute[T Table](r *Reports, key string, load func() (T, error)) (Result[T], error) {
+	rows, err := load()
+	if err != nil {
+		return Result[T]{}, err
+	}
+	result := Result[T]{GeneratedAt: time.Now().UTC(), Rows: rows}
+
+	value, err := json.Marshal(result)
+	if err != nil {
+		r.log.Error(fmt.Sprintf("Error encoding report %s", key), err)
+		return result, nil
+	}
+	err = r.cache.Set(&memcache.Item{Key: key, Value: value, Expiration: cache.Expiration(r.ttl)})
+	if err != nil && !errors.Is(err, breaker.ErrOpen) {
+		r.log.Warn(fmt.Sprintf("Error caching report %s", key), err)
+	}
+	return result, nil
+}
+
+// RevenueTable is the revenue report.
+type RevenueTable []models.RevenueRow
+
+func (t RevenueTable) Header() []string {
+	return []string{"day", "currency", "provider", "orders", "revenue"}
+}
+
+func (t RevenueTable) Records() [][]string {
+	records := make([][]string, 0, len(t))
+	for _, row := range t {
+		records = append(records, []string{row.Day, row.Currency, row.Provider, strconv.Itoa(row.Orders), strconv.FormatInt(row.Revenue, 10)})
+	}
+	return records
+}
+
+// ProductTable is the top products report.
+type ProductTable []models.ProductRow
+
+func (t ProductTable) Header() []string {
+	return []string{"key", "currency", "quantity", "revenue"}
+}
+
+func (t ProductTable) Records() [][]string {
+	records := make([][]string, 0, len(t))
+	for _, row := range t {
+		records = append(records, []string{row.Key, row.Currency, strconv.FormatInt(row.Quantity, 10), strconv.FormatInt(row.Revenue, 10)})
+	}
+	return records
+}
+
+// DeliveryCostTable is the delivery costs report.
+type DeliveryCostTable []models.DeliveryCostRow
+
+func (t DeliveryCostTable) Header() []string {
+	return []string{"delivery_service", "region", "orders", "avg_delivery_cost"}
+}
+
+func (t DeliveryCostTable) Records() [][]string {
+	records := make([][]string, 0, len(t))
+	for _, row := range t {
+		records = append(records, []string{row.DeliveryService, row.Region, strconv.Itoa(row.Orders), strconv.FormatFloat(row.AvgDeliveryCost, 'f', 2, 64)})
+	}
+	return records
+}
+
+// SaleTable is the sale distribution report.
+type SaleTable []models.SaleBucket
+
+func (t SaleTable) Header() []string {
+	return []string{"sale_from", "sale_to", "items", "revenue"}
+}
+
+func (t SaleTable) Records() [][]string {
+	records := make([][]string, 0, len(t))
+	for _, row := range t {
+		records = append(records, []string{strconv.Itoa(row.SaleFrom), strconv.Itoa(row.SaleTo), strconv.FormatInt(row.Items, 10), strconv.FormatInt(row.Revenue, 10)})
+	}
+	return records
+}
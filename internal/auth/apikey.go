@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"wb-kafka-service/internal/config"
+)
+
+// APIKeys accepts static keys from the config, sent in the X-API-Key header or
+// as a bearer token.
+type APIKeys struct {
+	keys []apiKey
+}
+
+type apiKey struct {
+	key       []byte
+	principal Principal
+}
+
+func NewAPIKeys(keys []config.APIKey) (*APIKeys, error) {
+	a := &APIKeys{}
+	for _, k := range keys {
+		if k.Key == "" {
+			return nil, fmt.Errorf("API key %q is empty", k.Name)
+		}
+		role, err := ParseRole(k.Role)
+		if err != nil {
+			return nil, fmt.Errorf("API key %q: %w", k.Name, err)
+		}
+		a.keys = append(a.keys, apiKey{key: []byte(k.Key), principal: Principal{Subject: k.Name, Role: role, Method: "api_key"}})
+	}
+	return a, nil
+}
+
+func (a *APIKeys) Authenticate(r *http.Request) (*Principal, error) {
+	given := r.Header.Get("X-API-Key")
+	if given == "" {
+		given = Token(r)
+	}
+	if given == "" {
+		return nil, ErrNoCredentials
+	}
+
+	// Every key is compared so the time taken doesn't tell which one matched.
+	var found *Principal
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(given), a.keys[i].key) == 1 {
+			found = &a.keys[i].principal
+		}
+	}
+	if found == nil {
+		// Configured keys may contain dots too, so a signed token is only
+		// left to the other authenticators once no key matched.
+		if isSignedToken(given) {
+			return nil, ErrNoCredentials
+		}
+		return nil, ErrInvalidCredentials
+	}
+	p := *found
+	return &p, nil
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"wb-kafka-service/internal/config"
+)
+
+// Role decides what a caller may do and see.
+type Role string
+
+const (
+	// RoleAdmin may use every endpoint and sees orders unmasked.
+	RoleAdmin Role = "admin"
+	// RoleSupport may read orders, with personal data masked.
+	RoleSupport Role = "support"
+)
+
+// ParseRole returns the role named s.
+func ParseRole(s string) (Role, error) {
+	switch role := Role(s); role {
+	case RoleAdmin, RoleSupport:
+		return role, nil
+	default:
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+}
+
+// SeesPII reports whether the role sees customers' personal and payment data.
+func (r Role) SeesPII() bool {
+	return r == RoleAdmin
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Subject string
+	Role    Role
+	// Method is the authenticator that accepted the credentials: "api_key",
+	// "hmac" or "jwt".
+	Method string
+}
+
+var (
+	// ErrNoCredentials means the request carries no credentials an
+	// authenticator understands.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials means the credentials are malformed, expired or
+	// not signed by a trusted key.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator identifies the caller of a request. It returns
+// ErrNoCredentials if the request has no credentials of its kind.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Chain tries each authenticator in turn until one finds its kind of credentials.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if !errors.Is(err, ErrNoCredentials) {
+			return p, err
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+// New builds the authenticators configured in the auth section. It returns
+// nil if none is configured, which leaves the order endpoints open.
+func New(cfg config.AppConfig) (Authenticator, error) {
+	var chain Chain
+
+	if len(cfg.Auth.APIKeys) > 0 {
+		keys, err := NewAPIKeys(cfg.Auth.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, keys)
+	}
+
+	if cfg.Auth.HMAC.Secret != "" {
+		chain = append(chain, NewHMACTokens([]byte(cfg.Auth.HMAC.Secret)))
+	}
+
+	if cfg.Auth.JWT.JWKSFile != "" {
+		keys, err := LoadJWKS(cfg.Auth.JWT.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, NewJWT(keys, cfg.Auth.JWT))
+	}
+
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// Token returns the Authorization bearer token of a request, or "". Routes
+// that also take the access_token query parameter move it into the header
+// first, see handlers.AllowQueryToken.
+func Token(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of an authenticated request, or nil.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
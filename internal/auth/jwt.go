@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"wb-kafka-service/internal/config"
+)
+
+// JWK is a key of a JSON Web Key Set. RSA keys verify RS256 tokens, "oct"
+// keys HS256 tokens.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	// N and E are the modulus and exponent of an RSA key.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// K is the value of a symmetric key.
+	K string `json:"k,omitempty"`
+}
+
+// JWKS holds the decoded keys of a JSON Web Key Set.
+type JWKS struct {
+	rsa    map[string]*rsa.PublicKey
+	secret map[string][]byte
+}
+
+// LoadJWKS reads a JSON Web Key Set from a local file.
+func LoadJWKS(path string) (*JWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading JWKS %s: %w", path, err)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing JWKS %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// ParseJWKS decodes a JSON Web Key Set. Keys of other types are skipped.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	jwks := &JWKS{rsa: make(map[string]*rsa.PublicKey), secret: make(map[string][]byte)}
+	for _, key := range set.Keys {
+		switch key.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(key.N)
+			e, errE := base64.RawURLEncoding.DecodeString(key.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("invalid RSA key %q", key.Kid)
+			}
+			jwks.rsa[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "oct":
+			k, err := base64.RawURLEncoding.DecodeString(key.K)
+			if err != nil || len(k) == 0 {
+				return nil, fmt.Errorf("invalid symmetric key %q", key.Kid)
+			}
+			jwks.secret[key.Kid] = k
+		}
+	}
+
+	if len(jwks.rsa)+len(jwks.secret) == 0 {
+		return nil, fmt.Errorf("no RSA or oct keys")
+	}
+	return jwks, nil
+}
+
+// JWT accepts HS256 and RS256 JSON Web Tokens signed by a key of a JWKS. The
+// role is read from the claim configured as auth.jwt.role_claim, a string
+// or a list; the most privileged known role wins.
+type JWT struct {
+	keys *JWKS
+	cfg  config.JWTConfig
+}
+
+func NewJWT(keys *JWKS, cfg config.JWTConfig) *JWT {
+	if cfg.RoleClaim == "" {
+		cfg.RoleClaim = "role"
+	}
+	return &JWT{keys: keys, cfg: cfg}
+}
+
+func (j *JWT) Authenticate(r *http.Request) (*Principal, error) {
+	token := Token(r)
+	if strings.Count(token, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := j.verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	subject, _ := claims["sub"].(string)
+	role, err := j.role(claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	return &Principal{Subject: subject, Role: role, Method: "jwt"}, nil
+}
+
+func (j *JWT) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("bad header: %v", err)
+	}
+
+	signed := parts[0] + "." + parts[1]
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("bad signature encoding")
+	}
+
+	// The key type follows from alg, so an RSA public key is never used as
+	// an HMAC secret.
+	verified := false
+	switch header.Alg {
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+		for kid, key := range j.keys.rsa {
+			if header.Kid != "" && kid != header.Kid {
+				continue
+			}
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+				verified = true
+				break
+			}
+		}
+	case "HS256":
+		for kid, secret := range j.keys.secret {
+			if header.Kid != "" && kid != header.Kid {
+				continue
+			}
+			if hmac.Equal(signature, sign(secret, signed)) {
+				verified = true
+				break
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", header.Alg)
+	}
+	if !verified {
+		return nil, fmt.Errorf("signature not verified by any key")
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("bad claims: %v", err)
+	}
+	return claims, j.validate(claims)
+}
+
+func (j *JWT) validate(claims map[string]any) error {
+	now := time.Now()
+	leeway := j.cfg.Leeway
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("no exp claim")
+	}
+	if now.Add(-leeway).Unix() >= int64(exp) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Unix() < int64(nbf) {
+		return fmt.Errorf("token not valid yet")
+	}
+
+	if j.cfg.Issuer != "" && claims["iss"] != j.cfg.Issuer {
+		return fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+	if j.cfg.Audience != "" && !hasAudience(claims["aud"], j.cfg.Audience) {
+		return fmt.Errorf("token is not meant for %s", j.cfg.Audience)
+	}
+	return nil
+}
+
+func hasAudience(aud any, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []any:
+		for _, a := range aud {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (j *JWT) role(claims map[string]any) (Role, error) {
+	var names []any
+	switch value := claims[j.cfg.RoleClaim].(type) {
+	case string:
+		names = []any{value}
+	case []any:
+		names = value
+	}
+
+	var best Role
+	for _, name := range names {
+		s, _ := name.(string)
+		role, err := ParseRole(s)
+		if err != nil {
+			continue
+		}
+		if best == "" || role.SeesPII() {
+			best = role
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no known role in the %s claim", j.cfg.RoleClaim)
+	}
+	return best, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"wb-kafka-service/internal/models"
+)
+
+// MaskOrder returns a copy of order with the customer's personal and payment
+// data masked, for roles that don't see PII. order itself is not changed.
+func MaskOrder(order *models.Order) *models.Order {
+	masked := *order
+	masked.Items = append([]models.Items(nil), order.Items...)
+
+	d := &masked.Delivery
+	d.Name = maskWords(d.Name)
+	d.Phone = maskMiddle(d.Phone, 2, 2)
+	d.Zip = maskMiddle(d.Zip, 0, 0)
+	d.Address = maskWords(d.Address)
+	d.Email = maskEmail(d.Email)
+
+	p := &masked.Payment
+	p.Transaction = maskMiddle(p.Transaction, 0, 4)
+	p.RequestID = maskMiddle(p.RequestID, 0, 0)
+	masked.InternalSignature = maskMiddle(masked.InternalSignature, 0, 0)
+
+	return &masked
+}
+
+// maskMiddle keeps the first head and last tail runes of s and stars the rest.
+// Values too short to hide anything are starred completely.
+func maskMiddle(s string, head, tail int) string {
+	n := utf8.RuneCountInString(s)
+	if n == 0 {
+		return ""
+	}
+	if n <= head+tail+2 {
+		return strings.Repeat("*", n)
+	}
+	runes := []rune(s)
+	return string(runes[:head]) + strings.Repeat("*", n-head-tail) + string(runes[n-tail:])
+}
+
+// maskWords keeps the first letter of every word: "Test Testov" becomes "T*** T*****".
+func maskWords(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		runes := []rune(w)
+		words[i] = string(runes[0]) + strings.Repeat("*", len(runes)-1)
+	}
+	return strings.Join(words, " ")
+}
+
+// maskEmail keeps the first letter of the local part and the domain.
+func maskEmail(s string) string {
+	local, domain, ok := strings.Cut(s, "@")
+	if !ok {
+		return maskMiddle(s, 0, 0)
+	}
+	return maskMiddle(local, 1, 0) + "@" + domain
+}
@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// adminPageSize is how many order ids FlushFamily and WarmUp read at a time.
+const adminPageSize = 500
+
+// Families lists the key families FlushFamily accepts.
+var Families = []string{FamilyOrder, FamilyItem, FamilyDelivery, FamilyPayment}
+
+// ErrUnknownFamily is returned by FlushFamily for a family not in Families.
+var ErrUnknownFamily = errors.New("unknown cache key family")
+
+// EvictOrder removes the cached entries of an order. If the order is gone from
+// the DB, only its order key and not-found marker are removed.
+func EvictOrder(ctx context.Context, log logger.Logger, memCache MemCacheClient, db postgres.PostgresDB, orderID int) error {
+	order, err := db.GetOrderFromDB(ctx, orderID)
+	if errors.Is(err, postgres.ErrOrderNotFound) {
+		order = &models.Order{ID: orderID}
+	} else if err != nil {
+		log.Error(fmt.Sprintf("Error loading order %d to evict", orderID), err)
+		return err
+	}
+
+	return InvalidateOrder(log, memCache, order)
+}
+
+// RefreshOrder replaces the cached entries of an order with its current state
+// in the DB.
+func RefreshOrder(ctx context.Context, log logger.Logger, memCache MemCacheClient, opts Options, db postgres.PostgresDB, orderID int) (*models.Order, error) {
+	order, err := db.GetOrderFromDB(ctx, orderID)
+	if err != nil {
+		if !errors.Is(err, postgres.ErrOrderNotFound) {
+			log.Error(fmt.Sprintf("Error loading order %d to refresh", orderID), err)
+		}
+		return nil, err
+	}
+
+	err = InvalidateOrder(log, memCache, order)
+	if err != nil {
+		return nil, err
+	}
+	return order, SaveToCache(log, memCache, opts, order)
+}
+
+// FlushFamily deletes every cached entry of a key family. Memcache can't list
+// keys, so the ids are read from the DB; it returns how many keys it deleted.
+func FlushFamily(ctx context.Context, log logger.Logger, memCache MemCacheClient, db postgres.PostgresDB, family string) (int, error) {
+	known := false
+	for _, f := range Families {
+		known = known || f == family
+	}
+	if !known {
+		return 0, fmt.Errorf("%w %q", ErrUnknownFamily, family)
+	}
+
+	deleted := 0
+	err := eachOrderID(ctx, db, 0, func(orderID int) error {
+		keys := []string{OrderKey(orderID), notFoundKey(orderID)}
+		if family != FamilyOrder {
+			order, err := db.GetOrderFromDB(ctx, orderID)
+			if errors.Is(err, postgres.ErrOrderNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			keys = familyKeys(order, family)
+		}
+
+		for _, key := range keys {
+			err := memCache.Delete(key)
+			if err == nil {
+				deleted++
+			} else if !errors.Is(err, memcache.ErrCacheMiss) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("Error flushing the %s cache family", family), err)
+		return deleted, err
+	}
+
+	log.Info(fmt.Sprintf("Flushed %d keys of the %s cache family", deleted, family))
+	return deleted, nil
+}
+
+func familyKeys(order *models.Order, family string) []string {
+	switch family {
+	case FamilyItem:
+		keys := make([]string, len(order.Items))
+		for i, item := range order.Items {
+			keys[i] = ItemKey(item.ID)
+		}
+		return keys
+	case FamilyDelivery:
+		return []string{DeliveryKey(order.Delivery.ID)}
+	case FamilyPayment:
+		return []string{PaymentKey(order.Payment.ID)}
+	default:
+		return []string{OrderKey(order.ID)}
+	}
+}
+
+// WarmUp caches the newest limit orders, or all orders if limit is 0, and
+// returns how many it cached.
+func WarmUp(ctx context.Context, log logger.Logger, memCache MemCacheClient, opts Options, db postgres.PostgresDB, limit int) (int, error) {
+	warmed := 0
+	err := eachOrderID(ctx, db, limit, func(orderID int) error {
+		order, err := db.GetOrderFromDB(ctx, orderID)
+		if errors.Is(err, postgres.ErrOrderNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = SaveToCache(log, memCache, opts, order)
+		if err != nil {
+			return err
+		}
+		warmed++
+		return nil
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("Cache warm-up stopped after %d orders", warmed), err)
+		return warmed, err
+	}
+
+	log.Info(fmt.Sprintf("Cache warm-up cached %d orders", warmed))
+	return warmed, nil
+}
+
+// eachOrderID calls fn for the newest limit order ids, or all if limit is 0,
+// stopping at the first error or when ctx is done.
+func eachOrderID(ctx context.Context, db postgres.PostgresDB, limit int, fn func(orderID int) error) error {
+	seen := 0
+	before := 0
+	for {
+		pageSize := adminPageSize
+		if limit > 0 {
+			pageSize = min(pageSize, limit-seen)
+		}
+		if pageSize == 0 {
+			return nil
+		}
+
+		ids, err := db.ListOrderIDs(ctx, before, pageSize)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(id); err != nil {
+				return err
+			}
+		}
+
+		seen += len(ids)
+		if len(ids) < pageSize {
+			return nil
+		}
+		before = ids[len(ids)-1]
+	}
+}
@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"errors"
+	"wb-kafka-service/pkg/breaker"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// IsFailure tells the errors that mean memcache is unavailable from ordinary
+// outcomes such as a cache miss.
+func IsFailure(err error) bool {
+	return err != nil &&
+		!errors.Is(err, memcache.ErrCacheMiss) &&
+		!errors.Is(err, memcache.ErrNotStored) &&
+		!errors.Is(err, memcache.ErrCASConflict)
+}
+
+// breakerMemCache fails fast with breaker.ErrOpen while memcache is down, so
+// reads go straight to the DB.
+type breakerMemCache struct {
+	client  MemCacheClient
+	breaker *breaker.Breaker
+}
+
+func NewBreakerMemCache(client MemCacheClient, b *breaker.Breaker) MemCacheClient {
+	return &breakerMemCache{client: client, breaker: b}
+}
+
+func (c *breakerMemCache) Set(item *memcache.Item) error {
+	return c.breaker.Do(func() error { return c.client.Set(item) })
+}
+
+func (c *breakerMemCache) Get(key string) (*memcache.Item, error) {
+	return breaker.Call(c.breaker, func() (*memcache.Item, error) { return c.client.Get(key) })
+}
+
+func (c *breakerMemCache) Delete(key string) error {
+	return c.breaker.Do(func() error { return c.client.Delete(key) })
+}
@@ -1,8 +1,11 @@
 package cache
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"strconv"
+	"time"
+	"wb-kafka-service/internal/config"
 	"wb-kafka-service/internal/models"
 	"wb-kafka-service/pkg/logger"
 
@@ -35,34 +38,56 @@ func (m *MemCache) Delete(key string) error {
 	return m.Client.Delete(key)
 }
 
-func SaveToCache(log logger.Logger, memCache MemCacheClient, order *models.Order) error {
-	orderData, err := json.Marshal(order)
+// Options controls how orders are encoded and how long entries live in memcache.
+type Options struct {
+	Codec            Codec
+	OrderTTL         time.Duration
+	ItemTTL          time.Duration
+	DeliveryTTL      time.Duration
+	PaymentTTL       time.Duration
+	NotFoundTTL      time.Duration
+	EarlyRefreshBeta float64
+}
+
+func NewOptions(cfg config.AppConfig) (Options, error) {
+	codec, err := NewCodec(cfg)
 	if err != nil {
-		log.Error("Error marshalling order", err)
-		return err
+		return Options{}, err
 	}
 
-	err = memCache.Set(&memcache.Item{Key: "order:" + strconv.Itoa(order.ID), Value: orderData})
+	return Options{
+		Codec:            codec,
+		OrderTTL:         cfg.Memcached.TTL.Order,
+		ItemTTL:          cfg.Memcached.TTL.Item,
+		DeliveryTTL:      cfg.Memcached.TTL.Delivery,
+		PaymentTTL:       cfg.Memcached.TTL.Payment,
+		NotFoundTTL:      cfg.Memcached.NotFoundTTL,
+		EarlyRefreshBeta: cfg.Memcached.EarlyRefreshBeta,
+	}, nil
+}
+
+func SaveToCache(log logger.Logger, memCache MemCacheClient, opts Options, order *models.Order) error {
+	err := setOrder(memCache, opts, order)
 	if err != nil {
 		log.Error("Error saving order to memcache", err)
 		return err
 	}
 
 	for _, item := range order.Items {
-		err := memCache.Set(&memcache.Item{Key: "item:" + strconv.Itoa(item.ID), Value: []byte(strconv.Itoa(item.ChrtID))})
+		err := memCache.Set(&memcache.Item{Key: ItemKey(item.ID), Value: []byte(strconv.Itoa(item.ChrtID)), Expiration: Expiration(opts.ItemTTL)})
 		if err != nil {
 			log.Error("Error saving item to memcache", err)
 			return err
 		}
 	}
 
-	err = memCache.Set(&memcache.Item{Key: "delivery:" + strconv.Itoa(order.Delivery.ID), Value: []byte(order.Delivery.Name)})
+	err = memCache.Set(&memcache.Item{Key: DeliveryKey(order.Delivery.ID), Value: []byte(order.Delivery.Name), Expiration: Expiration(opts.DeliveryTTL)})
 	if err != nil {
 		log.Error("Error saving delivery to memcache", err)
 		return err
 	}
 
-	err = memCache.Set(&memcache.Item{Key: "payment:" + strconv.Itoa(order.Payment.ID), Value: []byte(order.Payment.Transaction)})
+	err = memCache.Set(&memcache.Item{Key: PaymentKey(order.Payment.ID), Value: []byte(order.Payment.Transaction), Expiration: Expiration(opts.PaymentTTL)})
 	if err != nil {
 		log.Error("Error saving payment to memcache", err)
 		return err
@@ -70,3 +95,57 @@ func SaveToCache(log logger.Logger, memCache MemCacheClient, order *models.Order
 
 	return nil
 }
+
+// InvalidateOrder removes every cached entry derived from order, including a
+// not-found marker left by an earlier lookup of its id. Call it whenever the
+// order is written to the DB so readers never see a stale copy.
+func InvalidateOrder(log logger.Logger, memCache MemCacheClient, order *models.Order) error {
+	keys := []string{OrderKey(order.ID), notFoundKey(order.ID), DeliveryKey(order.Delivery.ID), PaymentKey(order.Payment.ID)}
+	for _, item := range order.Items {
+		keys = append(keys, ItemKey(item.ID))
+	}
+
+	for _, key := range keys {
+		err := memCache.Delete(key)
+		if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			log.Error(fmt.Sprintf("Error deleting %s from memcache", key), err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setOrder stores order under its order key. Flags carries the unix time the
+// entry expires at, which memcache itself does not report back on Get; the
+// loader uses it to refresh hot entries early.
+func setOrder(memCache MemCacheClient, opts Options, order *models.Order) error {
+	orderData, err := opts.Codec.Marshal(order)
+	if err != nil {
+		return err
+	}
+
+	var expiresAt uint32
+	if opts.OrderTTL > 0 {
+		expiresAt = uint32(time.Now().Add(opts.OrderTTL).Unix())
+	}
+
+	return memCache.Set(&memcache.Item{
+		Key:        OrderKey(order.ID),
+		Value:      orderData,
+		Flags:      expiresAt,
+		Expiration: Expiration(opts.OrderTTL),
+	})
+}
+
+// Expiration converts a TTL to memcache's Expiration field, which counts in whole seconds.
+func Expiration(ttl time.Duration) int32 {
+	if ttl <= 0 {
+		return 0
+	}
+	seconds := int32(ttl / time.Second)
+	if seconds == 0 {
+		seconds = 1
+	}
+	return seconds
+}
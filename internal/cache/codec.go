@@ -0,0 +1,368 @@
+package cache
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/models"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec converts orders to and from the bytes stored in memcache. A codec must
+// reject data written by a different codec with an error, so switching codecs
+// only costs one DB load per order instead of serving garbage.
+type Codec interface {
+	Name() string
+	Marshal(order *models.Order) ([]byte, error)
+	Unmarshal(data []byte, order *models.Order) error
+}
+
+// NewCodec builds the codec selected in the memcached config section.
+func NewCodec(cfg config.AppConfig) (Codec, error) {
+	var codec Codec
+	switch cfg.Memcached.Codec {
+	case "", "json":
+		codec = JSONCodec{}
+	case "binary":
+		codec = BinaryCodec{}
+	default:
+		return nil, fmt.Errorf("unknown cache codec %q", cfg.Memcached.Codec)
+	}
+
+	switch cfg.Memcached.Compression {
+	case "", "none":
+		return codec, nil
+	case "snappy", "zstd":
+		compressed, err := NewCompressedCodec(codec, cfg.Memcached.Compression, cfg.Memcached.CompressionThreshold)
+		if err != nil {
+			return nil, err
+		}
+		return compressed, nil
+	default:
+		return nil, fmt.Errorf("unknown cache compression %q", cfg.Memcached.Compression)
+	}
+}
+
+// JSONCodec stores orders as plain JSON, the same shape the Kafka messages use.
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+func (JSONCodec) Marshal(order *models.Order) ([]byte, error) {
+	return json.Marshal(order)
+}
+
+func (JSONCodec) Unmarshal(data []byte, order *models.Order) error {
+	return json.Unmarshal(data, order)
+}
+
+// binaryMagic starts every BinaryCodec value. It can't be the first byte of a
+// JSON document or of a CompressedCodec value.
+const binaryMagic = 0xB1
+
+var errShortBuffer = errors.New("binary order: unexpected end of data")
+
+// BinaryCodec stores orders as a flat sequence of varints and length-prefixed
+// strings in field order. It has no field names, so any change to the models
+// must come with a SchemaVersion bump.
+type BinaryCodec struct{}
+
+func (BinaryCodec) Name() string {
+	return "binary"
+}
+
+func (BinaryCodec) Marshal(order *models.Order) ([]byte, error) {
+	w := binaryWriter{buf: make([]byte, 0, 512)}
+	w.buf = append(w.buf, binaryMagic)
+
+	w.int(order.ID)
+	w.string(order.OrderUid)
+	w.string(order.TrackNumber)
+	w.string(order.Entry)
+	w.string(order.Locale)
+	w.string(order.InternalSignature)
+	w.string(order.CustomerID)
+	w.string(order.DeliveryService)
+	w.string(order.Shardkey)
+	w.int(order.SmID)
+	w.string(order.DateCreated)
+	w.string(order.OofShard)
+	w.string(order.Status)
+
+	d := &order.Delivery
+	w.int(d.ID)
+	w.string(d.Name)
+	w.string(d.Phone)
+	w.string(d.Zip)
+	w.string(d.City)
+	w.string(d.Address)
+	w.string(d.Region)
+	w.string(d.Email)
+
+	p := &order.Payment
+	w.int(p.ID)
+	w.string(p.Transaction)
+	w.string(p.RequestID)
+	w.string(p.Currency)
+	w.string(p.Provider)
+	w.int(p.Amount)
+	w.int64(p.PaymentDT)
+	w.string(p.Bank)
+	w.int(p.DeliveryCost)
+	w.int(p.GoodsTotal)
+	w.int(p.CustomFee)
+
+	w.int(len(order.Items))
+	for i := range order.Items {
+		item := &order.Items[i]
+		w.int(item.ID)
+		w.int(item.ChrtID)
+		w.string(item.TrackNumber)
+		w.int(item.Price)
+		w.string(item.Rid)
+		w.string(item.Name)
+		w.int(item.Sale)
+		w.string(item.Size)
+		w.int(item.TotalPrice)
+		w.int(item.NmID)
+		w.string(item.Brand)
+		w.int(item.Status)
+	}
+
+	return w.buf, nil
+}
+
+func (BinaryCodec) Unmarshal(data []byte, order *models.Order) error {
+	if len(data) == 0 || data[0] != binaryMagic {
+		return errors.New("binary order: bad magic byte")
+	}
+	r := binaryReader{buf: data[1:]}
+
+	order.ID = r.int()
+	order.OrderUid = r.string()
+	order.TrackNumber = r.string()
+	order.Entry = r.string()
+	order.Locale = r.string()
+	order.InternalSignature = r.string()
+	order.CustomerID = r.string()
+	order.DeliveryService = r.string()
+	order.Shardkey = r.string()
+	order.SmID = r.int()
+	order.DateCreated = r.string()
+	order.OofShard = r.string()
+	order.Status = r.string()
+
+	d := &order.Delivery
+	d.ID = r.int()
+	d.Name = r.string()
+	d.Phone = r.string()
+	d.Zip = r.string()
+	d.City = r.string()
+	d.Address = r.string()
+	d.Region = r.string()
+	d.Email = r.string()
+
+	p := &order.Payment
+	p.ID = r.int()
+	p.Transaction = r.string()
+	p.RequestID = r.string()
+	p.Currency = r.string()
+	p.Provider = r.string()
+	p.Amount = r.int()
+	p.PaymentDT = r.int64()
+	p.Bank = r.string()
+	p.DeliveryCost = r.int()
+	p.GoodsTotal = r.int()
+	p.CustomFee = r.int()
+
+	n := r.int()
+	if n < 0 || n > len(r.buf) {
+		return errors.New("binary order: bad item count")
+	}
+	order.Items = nil
+	if n > 0 {
+		order.Items = make([]models.Items, n)
+	}
+	for i := range order.Items {
+		item := &order.Items[i]
+		item.ID = r.int()
+		item.ChrtID = r.int()
+		item.TrackNumber = r.string()
+		item.Price = r.int()
+		item.Rid = r.string()
+		item.Name = r.string()
+		item.Sale = r.int()
+		item.Size = r.string()
+		item.TotalPrice = r.int()
+		item.NmID = r.int()
+		item.Brand = r.string()
+		item.Status = r.int()
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.buf) != 0 {
+		return errors.New("binary order: trailing data")
+	}
+	return nil
+}
+
+type binaryWriter struct {
+	buf []byte
+}
+
+func (w *binaryWriter) int(v int) {
+	w.buf = binary.AppendVarint(w.buf, int64(v))
+}
+
+func (w *binaryWriter) int64(v int64) {
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *binaryWriter) string(s string) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// binaryReader remembers the first error and returns zero values afterwards,
+// so Unmarshal can read every field and check the error once at the end.
+type binaryReader struct {
+	buf []byte
+	err error
+}
+
+func (r *binaryReader) int64() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = errShortBuffer
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *binaryReader) int() int {
+	return int(r.int64())
+}
+
+func (r *binaryReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	l, n := binary.Uvarint(r.buf)
+	if n <= 0 || l > uint64(len(r.buf)-n) {
+		r.err = errShortBuffer
+		return ""
+	}
+	s := string(r.buf[n : n+int(l)])
+	r.buf = r.buf[n+int(l):]
+	return s
+}
+
+// Header bytes of CompressedCodec values.
+const (
+	compressionNone   = 0x00
+	compressionSnappy = 0x01
+	compressionZstd   = 0x02
+)
+
+// CompressedCodec wraps another codec and compresses values of at least
+// threshold bytes. Every value carries a one-byte header naming the
+// compression used, so small values are stored as-is and still decode.
+type CompressedCodec struct {
+	codec     Codec
+	algorithm byte
+	threshold int
+	encoder   *zstd.Encoder
+	decoder   *zstd.Decoder
+}
+
+func NewCompressedCodec(codec Codec, algorithm string, threshold int) (*CompressedCodec, error) {
+	c := &CompressedCodec{codec: codec, threshold: threshold}
+
+	switch algorithm {
+	case "snappy":
+		c.algorithm = compressionSnappy
+	case "zstd":
+		c.algorithm = compressionZstd
+	default:
+		return nil, fmt.Errorf("unknown cache compression %q", algorithm)
+	}
+
+	var err error
+	if c.algorithm == compressionZstd {
+		c.encoder, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The decoder is needed even for snappy, since entries written before a
+	// switch from zstd must still decode.
+	c.decoder, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *CompressedCodec) Name() string {
+	name := "snappy"
+	if c.algorithm == compressionZstd {
+		name = "zstd"
+	}
+	return c.codec.Name() + "+" + name
+}
+
+func (c *CompressedCodec) Marshal(order *models.Order) ([]byte, error) {
+	data, err := c.codec.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < c.threshold {
+		return append([]byte{compressionNone}, data...), nil
+	}
+
+	switch c.algorithm {
+	case compressionSnappy:
+		out := make([]byte, 1+snappy.MaxEncodedLen(len(data)))
+		out[0] = compressionSnappy
+		return out[:1+len(snappy.Encode(out[1:], data))], nil
+	default:
+		return c.encoder.EncodeAll(data, []byte{compressionZstd}), nil
+	}
+}
+
+func (c *CompressedCodec) Unmarshal(data []byte, order *models.Order) error {
+	if len(data) == 0 {
+		return errors.New("compressed order: empty value")
+	}
+
+	var err error
+	payload := data[1:]
+	switch data[0] {
+	case compressionNone:
+	case compressionSnappy:
+		payload, err = snappy.Decode(nil, payload)
+	case compressionZstd:
+		payload, err = c.decoder.DecodeAll(payload, nil)
+	default:
+		return fmt.Errorf("compressed order: unknown header byte %#x", data[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.codec.Unmarshal(payload, order)
+}
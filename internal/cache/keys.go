@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SchemaVersion prefixes every cache key. Bump it whenever the models change
+// shape so entries written by an older build are ignored instead of being
+// decoded into the new structs.
+const SchemaVersion = "v2"
+
+const (
+	FamilyOrder    = "order"
+	FamilyItem     = "item"
+	FamilyDelivery = "delivery"
+	FamilyPayment  = "payment"
+	familyNotFound = "order_not_found"
+	// familyReport holds analytics reports. They only expire with their TTL.
+	familyReport = "report"
+)
+
+func key(family string, id int) string {
+	return SchemaVersion + ":" + family + ":" + strconv.Itoa(id)
+}
+
+func OrderKey(orderID int) string {
+	return key(FamilyOrder, orderID)
+}
+
+func ItemKey(itemID int) string {
+	return key(FamilyItem, itemID)
+}
+
+func DeliveryKey(deliveryID int) string {
+	return key(FamilyDelivery, deliveryID)
+}
+
+func PaymentKey(paymentID int) string {
+	return key(FamilyPayment, paymentID)
+}
+
+func notFoundKey(orderID int) string {
+	return key(familyNotFound, orderID)
+}
+
+// ReportKey returns the key of report name computed with params. Params must
+// not contain spaces.
+func ReportKey(name string, params ...string) string {
+	return SchemaVersion + ":" + familyReport + ":" + name + ":" + strings.Join(params, ":")
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/breaker"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// ErrOrderNotFound is returned by OrderLoader.Get for ids that are known to be missing.
+var ErrOrderNotFound = postgres.ErrOrderNotFound
+
+const notFoundValue = "1"
+
+// OrderLoader reads orders through memcache and protects the DB from stampedes:
+// concurrent misses for one id share a single DB query, missing ids are cached
+// for a short time, and hot entries are refreshed in the background shortly
+// before they expire.
+type OrderLoader struct {
+	cache MemCacheClient
+	db    postgres.PostgresDB
+	log   logger.Logger
+	opts  Options
+
+	group FlightGroup[*models.Order]
+	// loadTime is the duration of the last DB load in nanoseconds. It is the
+	// "delta" of the early refresh formula: slower loads start refreshing earlier.
+	loadTime atomic.Int64
+}
+
+func NewOrderLoader(opts Options, cacheClient MemCacheClient, db postgres.PostgresDB, log logger.Logger) *OrderLoader {
+	return &OrderLoader{
+		cache: cacheClient,
+		db:    db,
+		log:   log,
+		opts:  opts,
+	}
+}
+
+// Get returns the order with the given id from memcache or, on a miss, from the DB.
+// The returned order is a private copy the caller may modify. While memcache
+// is down every read goes to the DB; while the DB is down only cached orders
+// are served and other reads fail with breaker.ErrOpen.
+func (l *OrderLoader) Get(ctx context.Context, orderID int) (*models.Order, error) {
+	key := OrderKey(orderID)
+
+	item, err := l.cache.Get(key)
+	if err == nil {
+		order := models.Order{}
+		err = l.opts.Codec.Unmarshal(item.Value, &order)
+		if err == nil {
+			if l.shouldRefresh(item) {
+				go l.refresh(orderID)
+			}
+			return &order, nil
+		}
+		l.log.Error("Error unmarshalling cached order", err)
+	}
+
+	if _, err := l.cache.Get(notFoundKey(orderID)); err == nil {
+		return nil, ErrOrderNotFound
+	}
+
+	// The shared load must not be cancelled just because the request that
+	// started it went away: other requests may be waiting on the same result.
+	order, err, _ := l.group.Do(key, func() (*models.Order, error) {
+		return l.load(context.WithoutCancel(ctx), orderID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cloneOrder(order), nil
+}
+
+func (l *OrderLoader) load(ctx context.Context, orderID int) (*models.Order, error) {
+	start := time.Now()
+	order, err := l.db.GetOrderFromDB(ctx, orderID)
+	l.loadTime.Store(int64(time.Since(start)))
+
+	if errors.Is(err, postgres.ErrOrderNotFound) {
+		err := l.cache.Set(&memcache.Item{Key: notFoundKey(orderID), Value: []byte(notFoundValue), Expiration: Expiration(l.opts.NotFoundTTL)})
+		if err != nil && !errors.Is(err, breaker.ErrOpen) {
+			l.log.Error("Error saving not-found marker to cache", err)
+		}
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = setOrder(l.cache, l.opts, order)
+	if err != nil && !errors.Is(err, breaker.ErrOpen) {
+		l.log.Error("Error saving order to cache", err)
+	}
+
+	return order, nil
+}
+
+// shouldRefresh implements probabilistic early expiration (XFetch): the closer
+// an entry is to its expiry, and the slower the DB load, the more likely a
+// reader is to refresh it, so refreshes are spread out instead of all readers
+// missing at the same instant.
+func (l *OrderLoader) shouldRefresh(item *memcache.Item) bool {
+	if item.Flags == 0 || l.opts.EarlyRefreshBeta <= 0 {
+		return false
+	}
+	expiresAt := time.Unix(int64(item.Flags), 0)
+	delta := time.Duration(l.loadTime.Load())
+	if delta <= 0 {
+		delta = time.Millisecond
+	}
+	gap := time.Duration(float64(delta) * l.opts.EarlyRefreshBeta * -math.Log(1-rand.Float64()))
+	return !time.Now().Add(gap).Before(expiresAt)
+}
+
+func (l *OrderLoader) refresh(orderID int) {
+	key := OrderKey(orderID)
+	if l.group.InFlight(key) {
+		return
+	}
+	_, err, _ := l.group.Do(key, func() (*models.Order, error) {
+		return l.load(context.Background(), orderID)
+	})
+	if err != nil && !errors.Is(err, ErrOrderNotFound) && !errors.Is(err, breaker.ErrOpen) {
+		l.log.Error("Error refreshing order in cache", err)
+	}
+}
+
+func cloneOrder(order *models.Order) *models.Order {
+	clone := *order
+	clone.Items = append([]models.Items(nil), order.Items...)
+	return &clone
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"sync"
+)
+
+// FlightGroup coalesces concurrent loads of the same key into a single call.
+// The zero value is ready to use.
+type FlightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+type flightCall[T any] struct {
+	wg    sync.WaitGroup
+	value T
+	err   error
+}
+
+// Do runs fn once per key at a time. Callers that arrive while fn is running
+// wait for it and receive the same result; shared reports whether that happened.
+func (g *FlightGroup[T]) Do(key string, fn func() (T, error)) (value T, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[T])
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.value, c.err, true
+	}
+	c := &flightCall[T]{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+
+	c.value, c.err = fn()
+	return c.value, c.err, false
+}
+
+// InFlight reports whether a call for key is currently running.
+func (g *FlightGroup[T]) InFlight(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.calls[key]
+	return ok
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 	"wb-kafka-service/pkg/logger" 
 	"gopkg.in/yaml.v3"
 )
@@ -12,6 +13,48 @@ type AppConfig struct {
 		Broker  string `yaml:"broker"`
 		GroupID string `yaml:"group_id"`
 		Topic   string `yaml:"topic"`
+		// DeadLetterTopic receives messages the consumer rejected, with the
+		// reason in headers. Defaults to Topic + ".dlq".
+		DeadLetterTopic string `yaml:"dead_letter_topic"`
+		// ProducerID identifies this process in the envelope of published events.
+		// Defaults to the host name.
+		ProducerID string `yaml:"producer_id"`
+		Producer   struct {
+			// Async makes Publish return as soon as an event is queued; outcomes
+			// are reported through delivery callbacks instead.
+			Async        bool          `yaml:"async"`
+			BatchSize    int           `yaml:"batch_size"`
+			BatchBytes   int64         `yaml:"batch_bytes"`
+			BatchTimeout time.Duration `yaml:"batch_timeout"`
+			// Compression is "none", "gzip", "snappy", "lz4" or "zstd".
+			Compression string `yaml:"compression"`
+			// RequiredAcks is "all", "one" or "none".
+			RequiredAcks string `yaml:"required_acks"`
+			MaxAttempts  int    `yaml:"max_attempts"`
+		} `yaml:"producer"`
+		Consumer struct {
+			// RateLimit caps processed messages per second; 0 means no limit.
+			RateLimit float64 `yaml:"rate_limit"`
+			Burst     int     `yaml:"burst"`
+			// LedgerRetention is how long an idempotency key stays in the
+			// processed_messages ledger. A negative value keeps keys forever.
+			LedgerRetention time.Duration `yaml:"ledger_retention"`
+			// LedgerSweepInterval is how often expired keys are deleted.
+			LedgerSweepInterval time.Duration `yaml:"ledger_sweep_interval"`
+		} `yaml:"consumer"`
+		// Outbox configures the relay publishing stored changes from the outbox table.
+		Outbox struct {
+			Topic        string        `yaml:"topic"`
+			PollInterval time.Duration `yaml:"poll_interval"`
+			BatchSize    int           `yaml:"batch_size"`
+			// MaxBackoff caps the wait between polls after a failed publish.
+			MaxBackoff time.Duration `yaml:"max_backoff"`
+			// Retention is how long a sent message stays in the outbox table. A
+			// negative value keeps sent messages forever.
+			Retention time.Duration `yaml:"retention"`
+			// SweepInterval is how often expired sent messages are deleted.
+			SweepInterval time.Duration `yaml:"sweep_interval"`
+		} `yaml:"outbox"`
 	}
 	Postgres struct {
 		Host     string `yaml:"host"`
@@ -23,7 +66,108 @@ type AppConfig struct {
 	Memcached struct {
 		Host string `yaml:"host"`
 		Port string `yaml:"port"`
+		// TTL is how long entries of each key family stay in memcache.
+		TTL struct {
+			Order    time.Duration `yaml:"order"`
+			Item     time.Duration `yaml:"item"`
+			Delivery time.Duration `yaml:"delivery"`
+			Payment  time.Duration `yaml:"payment"`
+		} `yaml:"ttl"`
+		// NotFoundTTL is how long a missing order id is remembered, so repeated
+		// lookups of an unknown id don't reach the DB.
+		NotFoundTTL time.Duration `yaml:"not_found_ttl"`
+		// EarlyRefreshBeta scales probabilistic early refresh; a negative value disables it.
+		EarlyRefreshBeta float64 `yaml:"early_refresh_beta"`
+		// Codec is the encoding of cached orders: "json" or "binary".
+		Codec string `yaml:"codec"`
+		// Compression is "none", "snappy" or "zstd". Only values of at least
+		// CompressionThreshold bytes are compressed.
+		Compression          string `yaml:"compression"`
+		CompressionThreshold int    `yaml:"compression_threshold"`
 	}
+	// Breakers configure the circuit breakers around each dependency.
+	Breakers struct {
+		Postgres  BreakerConfig `yaml:"postgres"`
+		Memcached BreakerConfig `yaml:"memcached"`
+		Kafka     BreakerConfig `yaml:"kafka"`
+	} `yaml:"breakers"`
+	// Auth configures who may call the order and admin endpoints. With no
+	// method configured the order endpoints are open and /admin is disabled.
+	Auth struct {
+		APIKeys []APIKey `yaml:"api_keys"`
+		HMAC    struct {
+			// Secret signs the tokens issued by cmd/token.
+			Secret string `yaml:"secret"`
+		} `yaml:"hmac"`
+		JWT JWTConfig `yaml:"jwt"`
+	} `yaml:"auth"`
+	// RateLimit protects the HTTP endpoints from clients sending too much.
+	RateLimit struct {
+		// PerIP limits each client address, PerKey each API key or token.
+		PerIP  RateConfig `yaml:"per_ip"`
+		PerKey RateConfig `yaml:"per_key"`
+		// MaxConcurrent caps requests using the DB at once, so they queue here
+		// instead of in the pgx pool; 0 means no limit.
+		MaxConcurrent int `yaml:"max_concurrent"`
+		// QueueTimeout is how long a request waits for one of MaxConcurrent
+		// slots before it is rejected with 503.
+		QueueTimeout time.Duration `yaml:"queue_timeout"`
+		// TrustForwardedFor takes the client address from the last entry of
+		// X-Forwarded-For; enable it only behind a proxy that sets the header.
+		TrustForwardedFor bool `yaml:"trust_forwarded_for"`
+	} `yaml:"rate_limit"`
+	Reports struct {
+		// TTL is how long computed analytics reports are cached in memcache.
+		TTL time.Duration `yaml:"ttl"`
+	} `yaml:"reports"`
+	// Stream configures the live order feed at /orders/stream.
+	Stream struct {
+		// ClientBuffer is how many events a client may fall behind before it
+		// is disconnected.
+		ClientBuffer int `yaml:"client_buffer"`
+		// Heartbeat is the interval of keep-alive comments on idle streams.
+		Heartbeat time.Duration `yaml:"heartbeat"`
+		// AllowedOrigins are origins, besides this host, whose pages may open
+		// the stream over a WebSocket, e.g. "https://support.example.com".
+		AllowedOrigins []string `yaml:"allowed_origins"`
+	} `yaml:"stream"`
+	Web struct {
+		// DevDir, when set, serves templates and static files from this directory
+		// and reparses templates on every request, e.g. "../../internal/web".
+		DevDir string `yaml:"dev_dir"`
+	} `yaml:"web"`
+}
+
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker.
+	FailureThreshold int `yaml:"failure_threshold"`
+	// Cooldown is how long the breaker stays open before a call is tried again.
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+type RateConfig struct {
+	// Rate is requests per second; 0 means no limit.
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+type APIKey struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	// Role is "admin" or "support".
+	Role string `yaml:"role"`
+}
+
+type JWTConfig struct {
+	// JWKSFile is a local JSON Web Key Set with the RSA and oct keys tokens
+	// may be signed with.
+	JWKSFile string `yaml:"jwks_file"`
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// RoleClaim names the claim holding the role. Defaults to "role".
+	RoleClaim string        `yaml:"role_claim"`
+	Leeway    time.Duration `yaml:"leeway"`
 }
 
 func GetConfig(log logger.Logger) (AppConfig, error) { 
@@ -48,6 +192,118 @@ func GetConfig(log logger.Logger) (AppConfig, error) {
 		return config, fmt.Errorf("error parsing config file %s: %w", configFileName, err)
 	}
 
+	setDefaults(&config)
+
 	log.Info(fmt.Sprintf("Successfully loaded config from %s", configFileName))
 	return config, nil
 }
+
+func setDefaults(config *AppConfig) {
+	if config.Kafka.ProducerID == "" {
+		config.Kafka.ProducerID, _ = os.Hostname()
+	}
+	if config.Kafka.GroupID == "" {
+		config.Kafka.GroupID = "order-group"
+	}
+	if config.Kafka.DeadLetterTopic == "" {
+		config.Kafka.DeadLetterTopic = config.Kafka.Topic + ".dlq"
+	}
+	producer := &config.Kafka.Producer
+	if producer.BatchSize == 0 {
+		producer.BatchSize = 100
+	}
+	if producer.BatchBytes == 0 {
+		producer.BatchBytes = 1 << 20
+	}
+	if producer.BatchTimeout == 0 {
+		producer.BatchTimeout = 10 * time.Millisecond
+	}
+	if producer.Compression == "" {
+		producer.Compression = "snappy"
+	}
+	if producer.RequiredAcks == "" {
+		producer.RequiredAcks = "all"
+	}
+	if producer.MaxAttempts == 0 {
+		producer.MaxAttempts = 10
+	}
+	if config.Kafka.Consumer.Burst == 0 {
+		config.Kafka.Consumer.Burst = max(1, int(config.Kafka.Consumer.RateLimit))
+	}
+	if config.Kafka.Consumer.LedgerRetention == 0 {
+		// Kafka's default log retention: older messages can't be redelivered.
+		config.Kafka.Consumer.LedgerRetention = 7 * 24 * time.Hour
+	}
+	if config.Kafka.Consumer.LedgerSweepInterval == 0 {
+		config.Kafka.Consumer.LedgerSweepInterval = time.Hour
+	}
+	outbox := &config.Kafka.Outbox
+	if outbox.Topic == "" {
+		outbox.Topic = "order-events"
+	}
+	if outbox.PollInterval == 0 {
+		outbox.PollInterval = time.Second
+	}
+	if outbox.BatchSize == 0 {
+		outbox.BatchSize = 100
+	}
+	if outbox.MaxBackoff == 0 {
+		outbox.MaxBackoff = 30 * time.Second
+	}
+	if outbox.Retention == 0 {
+		outbox.Retention = 7 * 24 * time.Hour
+	}
+	if outbox.SweepInterval == 0 {
+		outbox.SweepInterval = time.Hour
+	}
+	ttl := &config.Memcached.TTL
+	for _, d := range []*time.Duration{&ttl.Order, &ttl.Item, &ttl.Delivery, &ttl.Payment} {
+		if *d == 0 {
+			*d = 5 * time.Minute
+		}
+	}
+	if config.Memcached.NotFoundTTL == 0 {
+		config.Memcached.NotFoundTTL = 5 * time.Second
+	}
+	if config.Memcached.EarlyRefreshBeta == 0 {
+		config.Memcached.EarlyRefreshBeta = 1
+	}
+	if config.Memcached.Codec == "" {
+		config.Memcached.Codec = "json"
+	}
+	if config.Memcached.Compression == "" {
+		config.Memcached.Compression = "none"
+	}
+	if config.Memcached.CompressionThreshold == 0 {
+		config.Memcached.CompressionThreshold = 1024
+	}
+	if config.Auth.JWT.RoleClaim == "" {
+		config.Auth.JWT.RoleClaim = "role"
+	}
+	for _, r := range []*RateConfig{&config.RateLimit.PerIP, &config.RateLimit.PerKey} {
+		if r.Burst == 0 {
+			r.Burst = max(1, int(r.Rate))
+		}
+	}
+	if config.RateLimit.QueueTimeout == 0 {
+		config.RateLimit.QueueTimeout = 100 * time.Millisecond
+	}
+	if config.Reports.TTL == 0 {
+		config.Reports.TTL = 5 * time.Minute
+	}
+	if config.Stream.ClientBuffer == 0 {
+		config.Stream.ClientBuffer = 64
+	}
+	if config.Stream.Heartbeat == 0 {
+		config.Stream.Heartbeat = 15 * time.Second
+	}
+	breakers := &config.Breakers
+	for _, b := range []*BreakerConfig{&breakers.Postgres, &breakers.Memcached, &breakers.Kafka} {
+		if b.FailureThreshold == 0 {
+			b.FailureThreshold = 5
+		}
+		if b.Cooldown == 0 {
+			b.Cooldown = 10 * time.Second
+		}
+	}
+}
@@ -101,28 +101,129 @@ func InsertItem(log logger.Logger, tx pgx.Tx, item *models.Items) error {
 	return nil
 }
 
-func InsertOrder(log logger.Logger, tx pgx.Tx, order *models.Order) error {
+// InsertOrder inserts order unless one with the same order_uid exists, and
+// reports whether a new row was created. order.ID is set in both cases.
+func InsertOrder(log logger.Logger, tx pgx.Tx, order *models.Order) (bool, error) {
 	var id int
+	created := false
 	err := tx.QueryRow(context.Background(),
 		"SELECT id FROM orders WHERE order_uid = $1", order.OrderUid).Scan(&id)
 
 	if err == pgx.ErrNoRows {
-		_, err = tx.Exec(context.Background(),
-			"INSERT INTO orders (order_uid, track_number, entry, delivery_id, payment_id, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)",
-			order.OrderUid, order.TrackNumber, order.Entry, order.Delivery.ID, order.Payment.ID, order.Locale, order.InternalSignature, order.CustomerID, order.DeliveryService, order.Shardkey, order.SmID, order.DateCreated, order.OofShard)
+		err = tx.QueryRow(context.Background(),
+			"INSERT INTO orders (order_uid, track_number, entry, delivery_id, payment_id, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id",
+			order.OrderUid, order.TrackNumber, order.Entry, order.Delivery.ID, order.Payment.ID, order.Locale, order.InternalSignature, order.CustomerID, order.DeliveryService, order.Shardkey, order.SmID, order.DateCreated, order.OofShard).Scan(&id)
 
 		if err != nil {
 			log.Error("Failed to insert order", err)
-			return err
+			return false, err
 		}
 
+		created = true
 		log.Info("Inserted order successfully")
 	} else if err != nil {
 		log.Error("Failed to check order existence", err)
-		return err
+		return false, err
 	} else {
 		log.Info(fmt.Sprintf("Order already exists with ID: %d", id))
 	}
 
+	order.ID = id
+	return created, nil
+}
+
+// LockOrder loads the id, track number and status of the order with the given
+// order_uid and locks its row until the transaction ends.
+func LockOrder(log logger.Logger, tx pgx.Tx, orderUid string) (*models.Order, error) {
+	order := models.Order{OrderUid: orderUid}
+	err := tx.QueryRow(context.Background(),
+		"SELECT id, track_number, status FROM orders WHERE order_uid = $1 FOR UPDATE", orderUid).Scan(&order.ID, &order.TrackNumber, &order.Status)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			log.Error("Failed to lock order", err)
+		}
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// UpdateItemStatus sets the status of the item chrtID within the order with the
+// given track number and returns the number of rows changed.
+func UpdateItemStatus(log logger.Logger, tx pgx.Tx, trackNumber string, chrtID int, status int) (int64, error) {
+	tag, err := tx.Exec(context.Background(),
+		"UPDATE items SET status = $1 WHERE track_number = $2 AND chrt_id = $3",
+		status, trackNumber, chrtID)
+	if err != nil {
+		log.Error("Failed to update item status", err)
+		return 0, err
+	}
+
+	log.Info(fmt.Sprintf("Updated status of item %d in %s to %d", chrtID, trackNumber, status))
+	return tag.RowsAffected(), nil
+}
+
+// SetOrderDelivery points the order at another delivery row. Delivery rows are
+// shared between orders with identical addresses, so they are never updated in place.
+func SetOrderDelivery(log logger.Logger, tx pgx.Tx, orderID int, deliveryID int) error {
+	_, err := tx.Exec(context.Background(),
+		"UPDATE orders SET delivery_id = $1 WHERE id = $2", deliveryID, orderID)
+	if err != nil {
+		log.Error("Failed to update order delivery", err)
+		return err
+	}
+
+	log.Info(fmt.Sprintf("Order %d now uses delivery %d", orderID, deliveryID))
 	return nil
 }
+
+func SetOrderStatus(log logger.Logger, tx pgx.Tx, orderID int, status string) error {
+	_, err := tx.Exec(context.Background(),
+		"UPDATE orders SET status = $1 WHERE id = $2", status, orderID)
+	if err != nil {
+		log.Error("Failed to update order status", err)
+		return err
+	}
+
+	log.Info(fmt.Sprintf("Order %d status set to %s", orderID, status))
+	return nil
+}
+
+func InsertOrderEvent(log logger.Logger, tx pgx.Tx, event *models.OrderEvent) error {
+	err := tx.QueryRow(context.Background(),
+		"INSERT INTO order_events (order_id, event_type, idempotency_key, payload) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+		event.OrderID, event.EventType, event.IdempotencyKey, []byte(event.Payload)).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		log.Error("Failed to insert order event", err)
+		return err
+	}
+
+	log.Info(fmt.Sprintf("Recorded %s event for order %d", event.EventType, event.OrderID))
+	return nil
+}
+
+func InsertOutboxMessage(log logger.Logger, tx pgx.Tx, msg *models.OutboxMessage) error {
+	err := tx.QueryRow(context.Background(),
+		"INSERT INTO outbox (order_id, order_uid, event_type, payload) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+		msg.OrderID, msg.OrderUid, msg.EventType, []byte(msg.Payload)).Scan(&msg.ID, &msg.CreatedAt)
+	if err != nil {
+		log.Error("Failed to insert outbox message", err)
+		return err
+	}
+
+	return nil
+}
+
+// InsertProcessedMessage adds key to the processed-messages ledger. It returns
+// false if the key was already there.
+func InsertProcessedMessage(log logger.Logger, tx pgx.Tx, key string, eventType string, orderUid string) (bool, error) {
+	tag, err := tx.Exec(context.Background(),
+		"INSERT INTO processed_messages (idempotency_key, event_type, order_uid) VALUES ($1, $2, $3) ON CONFLICT (idempotency_key) DO NOTHING",
+		key, eventType, orderUid)
+	if err != nil {
+		log.Error("Failed to insert processed message", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,322 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"wb-kafka-service/internal/models"
+)
+
+// DefaultRowGroupSize is how many rows the columnar writer buffers per row group.
+const DefaultRowGroupSize = 8192
+
+// columnarMagic starts and ends every columnar file.
+const columnarMagic = "WBCOL1\n"
+
+// The columnar format stores rows in row groups, and each row group column by
+// column, like Parquet, so readers can skip columns and values of a column
+// compress well together. A file is:
+//
+//	magic
+//	uvarint length, JSON schema: {"columns": [{"name": ..., "kind": ...}, ...]}
+//	row groups, each:
+//	    uvarint row count (> 0)
+//	    per column: uvarint length, then the values of the column:
+//	        int:    zigzag varints
+//	        string: uvarint length and bytes per value
+//	uvarint 0, ending the row groups
+//	uvarint total row count
+//	magic
+//
+// The writer keeps one encoded row group in memory, whatever the number of rows.
+
+// ColumnInfo describes a column of a columnar file.
+type ColumnInfo struct {
+	Name string `json:"name"`
+	Kind Kind   `json:"kind"`
+}
+
+type columnarSchema struct {
+	Columns []ColumnInfo `json:"columns"`
+}
+
+type columnarWriter struct {
+	out   *bufio.Writer
+	size  int
+	rows  int
+	total int
+	// chunks holds the encoded values of each column in the current row group.
+	chunks  []bytes.Buffer
+	scratch []byte
+	started bool
+}
+
+// NewColumnarWriter returns a columnar writer with row groups of rowGroupSize
+// rows; NewWriter uses DefaultRowGroupSize.
+func NewColumnarWriter(w io.Writer, rowGroupSize int) Writer {
+	return &columnarWriter{
+		out:    bufio.NewWriter(w),
+		size:   max(1, rowGroupSize),
+		chunks: make([]bytes.Buffer, len(Columns)),
+	}
+}
+
+func (w *columnarWriter) Write(row *models.ExportRow) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+
+	for i, c := range Columns {
+		w.scratch = w.scratch[:0]
+		if c.Kind == KindInt {
+			w.scratch = binary.AppendVarint(w.scratch, c.Int(row))
+		} else {
+			s := c.String(row)
+			w.scratch = binary.AppendUvarint(w.scratch, uint64(len(s)))
+			w.scratch = append(w.scratch, s...)
+		}
+		w.chunks[i].Write(w.scratch)
+	}
+
+	w.rows++
+	if w.rows == w.size {
+		return w.writeRowGroup()
+	}
+	return nil
+}
+
+// Flush writes what was written so far. Rows of an unfinished row group stay
+// buffered, since a row group can't be split.
+func (w *columnarWriter) Flush() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	return w.out.Flush()
+}
+
+func (w *columnarWriter) Close() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	if err := w.writeRowGroup(); err != nil {
+		return err
+	}
+
+	w.scratch = binary.AppendUvarint(w.scratch[:0], 0)
+	w.scratch = binary.AppendUvarint(w.scratch, uint64(w.total))
+	w.scratch = append(w.scratch, columnarMagic...)
+	if _, err := w.out.Write(w.scratch); err != nil {
+		return err
+	}
+	return w.out.Flush()
+}
+
+// start writes the magic and the schema before the first row group.
+func (w *columnarWriter) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+
+	var schema columnarSchema
+	for _, c := range Columns {
+		schema.Columns = append(schema.Columns, ColumnInfo{Name: c.Name, Kind: c.Kind})
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+
+	w.scratch = append(w.scratch[:0], columnarMagic...)
+	w.scratch = binary.AppendUvarint(w.scratch, uint64(len(data)))
+	w.scratch = append(w.scratch, data...)
+	_, err = w.out.Write(w.scratch)
+	return err
+}
+
+func (w *columnarWriter) writeRowGroup() error {
+	if w.rows == 0 {
+		return nil
+	}
+
+	w.scratch = binary.AppendUvarint(w.scratch[:0], uint64(w.rows))
+	if _, err := w.out.Write(w.scratch); err != nil {
+		return err
+	}
+	for i := range w.chunks {
+		w.scratch = binary.AppendUvarint(w.scratch[:0], uint64(w.chunks[i].Len()))
+		if _, err := w.out.Write(w.scratch); err != nil {
+			return err
+		}
+		if _, err := w.chunks[i].WriteTo(w.out); err != nil {
+			return err
+		}
+		w.chunks[i].Reset()
+	}
+
+	w.total += w.rows
+	w.rows = 0
+	return nil
+}
+
+// RowGroup is a decoded row group. For column i, Ints[i] holds the values of
+// an int column and Strings[i] those of a string column.
+type RowGroup struct {
+	Rows    int
+	Ints    [][]int64
+	Strings [][]string
+}
+
+// ColumnarReader reads files written in the columnar format one row group at a time.
+type ColumnarReader struct {
+	in      *bufio.Reader
+	columns []ColumnInfo
+	rows    int
+	done    bool
+}
+
+// ErrCorrupt is returned for input that isn't a complete columnar file.
+var ErrCorrupt = errors.New("corrupt columnar file")
+
+// Limits on the lengths in a columnar file, far above what the writer
+// produces, so a corrupt length is rejected instead of allocated.
+const (
+	maxSchemaSize = 1 << 20
+	maxChunkSize  = 1 << 30
+)
+
+// NewColumnarReader reads the schema of a columnar file.
+func NewColumnarReader(r io.Reader) (*ColumnarReader, error) {
+	in := bufio.NewReader(r)
+	if err := readMagic(in); err != nil {
+		return nil, err
+	}
+
+	data, err := readBlock(in, maxSchemaSize)
+	if err != nil {
+		return nil, err
+	}
+	var schema columnarSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("%w: schema: %v", ErrCorrupt, err)
+	}
+
+	return &ColumnarReader{in: in, columns: schema.Columns}, nil
+}
+
+// Columns returns the schema of the file.
+func (r *ColumnarReader) Columns() []ColumnInfo {
+	return r.columns
+}
+
+// Next returns the next row group, or io.EOF after the last one once the
+// footer checked out.
+func (r *ColumnarReader) Next() (*RowGroup, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+
+	rows, err := binary.ReadUvarint(r.in)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	// Every value takes at least a byte of its column's chunk.
+	if rows > maxChunkSize {
+		return nil, fmt.Errorf("%w: row group of %d rows", ErrCorrupt, rows)
+	}
+	if rows == 0 {
+		total, err := binary.ReadUvarint(r.in)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		if int(total) != r.rows {
+			return nil, fmt.Errorf("%w: footer counts %d rows, read %d", ErrCorrupt, total, r.rows)
+		}
+		if err := readMagic(r.in); err != nil {
+			return nil, err
+		}
+		r.done = true
+		return nil, io.EOF
+	}
+
+	group := &RowGroup{
+		Rows:    int(rows),
+		Ints:    make([][]int64, len(r.columns)),
+		Strings: make([][]string, len(r.columns)),
+	}
+	for i, c := range r.columns {
+		chunk, err := readBlock(r.in, maxChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeChunk(group, i, c.Kind, chunk); err != nil {
+			return nil, fmt.Errorf("%w: column %s: %v", ErrCorrupt, c.Name, err)
+		}
+	}
+
+	r.rows += group.Rows
+	return group, nil
+}
+
+// readBlock reads a uvarint length of at most max, then that many bytes. The
+// buffer grows as the bytes arrive, so a length past the end of the input
+// fails without allocating it.
+func readBlock(in *bufio.Reader, max uint64) ([]byte, error) {
+	length, err := binary.ReadUvarint(in)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if length > max {
+		return nil, fmt.Errorf("%w: length %d exceeds %d", ErrCorrupt, length, max)
+	}
+
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(in, int64(length)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if uint64(n) < length {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, io.ErrUnexpectedEOF)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeChunk(group *RowGroup, column int, kind Kind, chunk []byte) error {
+	for n := 0; n < group.Rows; n++ {
+		switch kind {
+		case KindInt:
+			v, size := binary.Varint(chunk)
+			if size <= 0 {
+				return fmt.Errorf("bad value %d", n)
+			}
+			group.Ints[column] = append(group.Ints[column], v)
+			chunk = chunk[size:]
+		case KindString:
+			length, size := binary.Uvarint(chunk)
+			if size <= 0 || uint64(len(chunk)-size) < length {
+				return fmt.Errorf("bad value %d", n)
+			}
+			group.Strings[column] = append(group.Strings[column], string(chunk[size:size+int(length)]))
+			chunk = chunk[size+int(length):]
+		default:
+			return fmt.Errorf("unknown kind %q", kind)
+		}
+	}
+	if len(chunk) != 0 {
+		return fmt.Errorf("%d trailing bytes", len(chunk))
+	}
+	return nil
+}
+
+func readMagic(in io.Reader) error {
+	magic := make([]byte, len(columnarMagic))
+	if _, err := io.ReadFull(in, magic); err != nil || string(magic) != columnarMagic {
+		return fmt.Errorf("%w: bad magic", ErrCorrupt)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/postgres"
+)
+
+// flushRows is how often Orders flushes its writer.
+const flushRows = 1000
+
+// Formats rows can be written in.
+const (
+	FormatCSV      = "csv"
+	FormatNDJSON   = "ndjson"
+	FormatColumnar = "columnar"
+)
+
+// Formats lists every format NewWriter accepts.
+var Formats = []string{FormatCSV, FormatNDJSON, FormatColumnar}
+
+// ContentType returns the MIME type of format.
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Extension returns the file extension of format, without the dot.
+func Extension(format string) string {
+	if format == FormatColumnar {
+		return "wbcol"
+	}
+	return format
+}
+
+// Writer writes export rows. Rows are buffered, at most one row group for the
+// columnar format; Close flushes them and must be called once all rows are written.
+type Writer interface {
+	Write(row *models.ExportRow) error
+	// Flush writes buffered complete rows, e.g. before flushing an HTTP response.
+	Flush() error
+	Close() error
+}
+
+// NewWriter returns a writer of format to w.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w), nil
+	case FormatNDJSON:
+		return newNDJSONWriter(w), nil
+	case FormatColumnar:
+		return NewColumnarWriter(w, DefaultRowGroupSize), nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// Orders writes the orders matching filter to out and closes it. It flushes
+// out every thousand rows and then calls flushed, if not nil, e.g. to flush
+// an HTTP response. It returns the number of rows written.
+func Orders(ctx context.Context, db postgres.PostgresDB, filter postgres.ExportFilter, out Writer, flushed func()) (int, error) {
+	rows := 0
+	err := db.ExportOrders(ctx, filter, postgres.DefaultExportBatch, func(row *models.ExportRow) error {
+		if err := out.Write(row); err != nil {
+			return err
+		}
+		rows++
+		if rows%flushRows == 0 {
+			if err := out.Flush(); err != nil {
+				return err
+			}
+			if flushed != nil {
+				flushed()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return rows, err
+	}
+	return rows, out.Close()
+}
+
+// Kind is the type of a column.
+type Kind string
+
+const (
+	KindString Kind = "string"
+	KindInt    Kind = "int"
+)
+
+// Column is a field of models.ExportRow. Int columns read Int, string
+// columns String.
+type Column struct {
+	Name   string
+	Kind   Kind
+	String func(*models.ExportRow) string
+	Int    func(*models.ExportRow) int64
+}
+
+// text returns the value of the column in row as text.
+func (c Column) text(row *models.ExportRow) string {
+	if c.Kind == KindInt {
+		return strconv.FormatInt(c.Int(row), 10)
+	}
+	return c.String(row)
+}
+
+func stringColumn(name string, get func(*models.ExportRow) string) Column {
+	return Column{Name: name, Kind: KindString, String: get}
+}
+
+func intColumn(name string, get func(*models.ExportRow) int64) Column {
+	return Column{Name: name, Kind: KindInt, Int: get}
+}
+
+// Columns are the exported fields in output order. Names match the JSON
+// names of models.ExportRow.
+var Columns = []Column{
+	intColumn("order_id", func(r *models.ExportRow) int64 { return int64(r.OrderID) }),
+	stringColumn("order_uid", func(r *models.ExportRow) string { return r.OrderUid }),
+	stringColumn("track_number", func(r *models.ExportRow) string { return r.TrackNumber }),
+	stringColumn("entry", func(r *models.ExportRow) string { return r.Entry }),
+	stringColumn("locale", func(r *models.ExportRow) string { return r.Locale }),
+	stringColumn("customer_id", func(r *models.ExportRow) string { return r.CustomerID }),
+	stringColumn("delivery_service", func(r *models.ExportRow) string { return r.DeliveryService }),
+	stringColumn("shardkey", func(r *models.ExportRow) string { return r.Shardkey }),
+	intColumn("sm_id", func(r *models.ExportRow) int64 { return int64(r.SmID) }),
+	stringColumn("date_created", func(r *models.ExportRow) string { return r.DateCreated }),
+	stringColumn("oof_shard", func(r *models.ExportRow) string { return r.OofShard }),
+	stringColumn("status", func(r *models.ExportRow) string { return r.Status }),
+
+	stringColumn("delivery_name", func(r *models.ExportRow) string { return r.DeliveryName }),
+	stringColumn("delivery_phone", func(r *models.ExportRow) string { return r.DeliveryPhone }),
+	stringColumn("delivery_zip", func(r *models.ExportRow) string { return r.DeliveryZip }),
+	stringColumn("delivery_city", func(r *models.ExportRow) string { return r.DeliveryCity }),
+	stringColumn("delivery_address", func(r *models.ExportRow) string { return r.DeliveryAddress }),
+	stringColumn("delivery_region", func(r *models.ExportRow) string { return r.DeliveryRegion }),
+	stringColumn("delivery_email", func(r *models.ExportRow) string { return r.DeliveryEmail }),
+
+	stringColumn("payment_transaction", func(r *models.ExportRow) string { return r.PaymentTransaction }),
+	stringColumn("payment_request_id", func(r *models.ExportRow) string { return r.PaymentRequestID }),
+	stringColumn("payment_currency", func(r *models.ExportRow) string { return r.PaymentCurrency }),
+	stringColumn("payment_provider", func(r *models.ExportRow) string { return r.PaymentProvider }),
+	intColumn("payment_amount", func(r *models.ExportRow) int64 { return int64(r.PaymentAmount) }),
+	intColumn("payment_dt", func(r *models.ExportRow) int64 { return r.PaymentDT }),
+	stringColumn("payment_bank", func(r *models.ExportRow) string { return r.PaymentBank }),
+	intColumn("payment_delivery_cost", func(r *models.ExportRow) int64 { return int64(r.PaymentDeliveryCost) }),
+	intColumn("payment_goods_total", func(r *models.ExportRow) int64 { return int64(r.PaymentGoodsTotal) }),
+	intColumn("payment_custom_fee", func(r *models.ExportRow) int64 { return int64(r.PaymentCustomFee) }),
+
+	intColumn("item_chrt_id", func(r *models.ExportRow) int64 { return int64(r.ItemChrtID) }),
+	intColumn("item_price", func(r *models.ExportRow) int64 { return int64(r.ItemPrice) }),
+	stringColumn("item_rid", func(r *models.ExportRow) string { return r.ItemRid }),
+	stringColumn("item_name", func(r *models.ExportRow) string { return r.ItemName }),
+	intColumn("item_sale", func(r *models.ExportRow) int64 { return int64(r.ItemSale) }),
+	stringColumn("item_size", func(r *models.ExportRow) string { return r.ItemSize }),
+	intColumn("item_total_price", func(r *models.ExportRow) int64 { return int64(r.ItemTotalPrice) }),
+	intColumn("item_nm_id", func(r *models.ExportRow) int64 { return int64(r.ItemNmID) }),
+	stringColumn("item_brand", func(r *models.ExportRow) string { return r.ItemBrand }),
+	intColumn("item_status", func(r *models.ExportRow) int64 { return int64(r.ItemStatus) }),
+}
+
+type csvWriter struct {
+	out    *csv.Writer
+	record []string
+	header bool
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{out: csv.NewWriter(w), record: make([]string, len(Columns))}
+}
+
+func (w *csvWriter) Write(row *models.ExportRow) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	for i, c := range Columns {
+		w.record[i] = c.text(row)
+	}
+	return w.out.Write(w.record)
+}
+
+func (w *csvWriter) Flush() error {
+	w.out.Flush()
+	return w.out.Error()
+}
+
+// Close writes the header if no row was written, so an empty export is still
+// a valid CSV file.
+func (w *csvWriter) Close() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func (w *csvWriter) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+	for i, c := range Columns {
+		w.record[i] = c.Name
+	}
+	return w.out.Write(w.record)
+}
+
+type ndjsonWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	buf := bufio.NewWriter(w)
+	return &ndjsonWriter{buf: buf, enc: json.NewEncoder(buf)}
+}
+
+func (w *ndjsonWriter) Write(row *models.ExportRow) error {
+	return w.enc.Encode(row)
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+func (w *ndjsonWriter) Close() error {
+	return w.buf.Flush()
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+)
+
+// ConsumerState is the body of the consumer admin endpoints.
+type ConsumerState struct {
+	kafka.ConsumerStatus
+	Processed    int64 `json:"processed"`
+	Duplicates   int64 `json:"duplicates"`
+	Failed       int64 `json:"failed"`
+	DeadLettered int64 `json:"dead_lettered"`
+}
+
+// HandlerConsumerStatus shows whether the consumer is paused and what it processed.
+func HandlerConsumerStatus(log logger.Logger, consumer *kafka.Consumer, w http.ResponseWriter, r *http.Request) {
+	writeConsumerState(log, consumer, w)
+}
+
+// HandlerConsumerPause pauses the consumer until HandlerConsumerResume is called.
+func HandlerConsumerPause(log logger.Logger, consumer *kafka.Consumer, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, log, newProblem(r, http.StatusMethodNotAllowed, "Use POST"))
+		return
+	}
+	consumer.Pause()
+	writeConsumerState(log, consumer, w)
+}
+
+// HandlerConsumerResume undoes a manual pause. The consumer stays paused while
+// an automatic pause condition, such as an open DB circuit breaker, holds.
+func HandlerConsumerResume(log logger.Logger, consumer *kafka.Consumer, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, log, newProblem(r, http.StatusMethodNotAllowed, "Use POST"))
+		return
+	}
+	consumer.Resume()
+	writeConsumerState(log, consumer, w)
+}
+
+func writeConsumerState(log logger.Logger, consumer *kafka.Consumer, w http.ResponseWriter) {
+	state := ConsumerState{
+		ConsumerStatus: consumer.Status(),
+		Processed:      kafka.Stats.Processed.Load(),
+		Duplicates:     kafka.Stats.Duplicates.Load(),
+		Failed:         kafka.Stats.Failed.Load(),
+		DeadLettered:   kafka.Stats.DeadLettered.Load(),
+	}
+
+	writeJSON(w, log, state)
+}
+
+// HandlerConsumerPartitions lists the partitions of the orders topic with the
+// group member each is assigned to and the group's lag.
+func HandlerConsumerPartitions(log logger.Logger, consumer *kafka.Consumer, w http.ResponseWriter, r *http.Request) {
+	partitions, err := consumer.Partitions(r.Context())
+	if err != nil {
+		log.Error("Error reading consumer partitions", err)
+		writeProblem(w, log, newProblem(r, http.StatusBadGateway, "Kafka did not report the consumer group"))
+		return
+	}
+
+	var lag int64
+	for _, p := range partitions {
+		lag += p.Lag
+	}
+	writeJSON(w, log, map[string]any{"partitions": partitions, "lag": lag})
+}
+
+// HandlerCacheEvict removes the cached entries of the order in the {id} path segment.
+func HandlerCacheEvict(log logger.Logger, db postgres.PostgresDB, cacheClient cache.MemCacheClient, w http.ResponseWriter, r *http.Request) {
+	orderID, ok := adminOrderID(log, w, r)
+	if !ok {
+		return
+	}
+
+	err := cache.EvictOrder(r.Context(), log, cacheClient, db, orderID)
+	if err != nil {
+		writeProblem(w, log, newProblem(r, http.StatusInternalServerError, "Error evicting the order"))
+		return
+	}
+
+	log.Info(fmt.Sprintf("Evicted order %d from the cache", orderID))
+	writeJSON(w, log, map[string]any{"order_id": orderID, "evicted": true})
+}
+
+// HandlerCacheRefresh recaches the order in the {id} path segment from the DB.
+func HandlerCacheRefresh(log logger.Logger, db postgres.PostgresDB, cacheClient cache.MemCacheClient, cacheOpts cache.Options, w http.ResponseWriter, r *http.Request) {
+	orderID, ok := adminOrderID(log, w, r)
+	if !ok {
+		return
+	}
+
+	_, err := cache.RefreshOrder(r.Context(), log, cacheClient, cacheOpts, db, orderID)
+	if errors.Is(err, postgres.ErrOrderNotFound) {
+		writeProblem(w, log, newProblem(r, http.StatusNotFound, "Order not found"))
+		return
+	}
+	if err != nil {
+		writeProblem(w, log, newProblem(r, http.StatusInternalServerError, "Error refreshing the order"))
+		return
+	}
+
+	log.Info(fmt.Sprintf("Refreshed order %d in the cache", orderID))
+	writeJSON(w, log, map[string]any{"order_id": orderID, "refreshed": true})
+}
+
+// HandlerCacheFlush deletes every cached entry of the key family in the
+// {family} path segment.
+func HandlerCacheFlush(log logger.Logger, db postgres.PostgresDB, cacheClient cache.MemCacheClient, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, log, newProblem(r, http.StatusMethodNotAllowed, "Use POST"))
+		return
+	}
+
+	family := r.PathValue("family")
+	deleted, err := cache.FlushFamily(r.Context(), log, cacheClient, db, family)
+	if errors.Is(err, cache.ErrUnknownFamily) {
+		writeProblem(w, log, newProblem(r, http.StatusNotFound, fmt.Sprintf("Unknown key family, use one of %s", strings.Join(cache.Families, ", "))))
+		return
+	}
+	if err != nil {
+		writeProblem(w, log, newProblem(r, http.StatusInternalServerError, fmt.Sprintf("Flush stopped after %d keys", deleted)))
+		return
+	}
+
+	writeJSON(w, log, map[string]any{"family": family, "deleted": deleted})
+}
+
+const defaultWarmUpLimit = 1000
+
+// HandlerCacheWarmUp caches the newest orders; the limit query parameter
+// sets how many, 0 meaning all of them.
+func HandlerCacheWarmUp(log logger.Logger, db postgres.PostgresDB, cacheClient cache.MemCacheClient, cacheOpts cache.Options, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, log, newProblem(r, http.StatusMethodNotAllowed, "Use POST"))
+		return
+	}
+
+	limit := defaultWarmUpLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			writeProblem(w, log, newProblem(r, http.StatusBadRequest, "Invalid limit"))
+			return
+		}
+	}
+
+	warmed, err := cache.WarmUp(r.Context(), log, cacheClient, cacheOpts, db, limit)
+	if err != nil {
+		writeProblem(w, log, newProblem(r, http.StatusInternalServerError, fmt.Sprintf("Warm-up stopped after %d orders", warmed)))
+		return
+	}
+
+	writeJSON(w, log, map[string]any{"warmed": warmed})
+}
+
+// LogLevel is the body of the log level endpoint.
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// HandlerLogLevel shows the log level, or changes it on POST.
+func HandlerLogLevel(log logger.Logger, w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var body LogLevel
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err == nil {
+			err = log.SetLevel(body.Level)
+		}
+		if err != nil {
+			writeProblem(w, log, newProblem(r, http.StatusBadRequest, fmt.Sprintf("Send {\"level\": ...} with one of %s", strings.Join(logger.Levels, ", "))))
+			return
+		}
+		// Logged as a warning so that it shows at every level but error.
+		log.Warn(fmt.Sprintf("Log level set to %s", body.Level), nil)
+	}
+
+	writeJSON(w, log, LogLevel{Level: log.Level()})
+}
+
+func adminOrderID(log logger.Logger, w http.ResponseWriter, r *http.Request) (int, bool) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, log, newProblem(r, http.StatusMethodNotAllowed, "Use POST"))
+		return 0, false
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || orderID <= 0 {
+		writeProblem(w, log, newProblem(r, http.StatusBadRequest, "Invalid order ID"))
+		return 0, false
+	}
+	return orderID, true
+}
+
+func writeJSON(w http.ResponseWriter, log logger.Logger, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Error("Error writing response", err)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"wb-kafka-service/internal/auth"
+	"wb-kafka-service/pkg/logger"
+)
+
+// RequireRole lets a request through to next only if authn identifies a
+// caller with one of roles. The caller is put in the request context, see
+// auth.FromContext.
+func RequireRole(log logger.Logger, authn auth.Authenticator, roles []auth.Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := authn.Authenticate(r)
+		if err != nil {
+			if !errors.Is(err, auth.ErrNoCredentials) {
+				log.Warn(fmt.Sprintf("Rejected credentials for %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr), err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="wb-kafka-service"`)
+			writeProblem(w, log, newProblem(r, http.StatusUnauthorized, "Valid credentials are required"))
+			return
+		}
+
+		if !slices.Contains(roles, principal.Role) {
+			log.Warn(fmt.Sprintf("Denied %s %s to %s with role %s", r.Method, r.URL.Path, principal.Subject, principal.Role), nil)
+			writeProblem(w, log, newProblem(r, http.StatusForbidden, "Your role may not use this endpoint"))
+			return
+		}
+
+		next(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	}
+}
+
+// AllowQueryToken lets clients that can't set headers, such as EventSource,
+// send their token or API key as the access_token query parameter. It moves
+// the parameter into the Authorization header and drops it from the URL, so
+// the credential isn't logged or echoed in problem responses. Other routes
+// ignore the parameter.
+func AllowQueryToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		token := query.Get("access_token")
+		if !query.Has("access_token") {
+			next(w, r)
+			return
+		}
+
+		r = r.Clone(r.Context())
+		query.Del("access_token")
+		r.URL.RawQuery = query.Encode()
+		r.RequestURI = r.URL.RequestURI()
+		if token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next(w, r)
+	}
+}
+
+// RequireAdmin is RequireRole for the admin role. A nil authn, i.e. no auth
+// method configured, disables the admin API.
+func RequireAdmin(log logger.Logger, authn auth.Authenticator, next http.HandlerFunc) http.HandlerFunc {
+	if authn == nil {
+		return func(w http.ResponseWriter, r *http.Request) {
+			writeProblem(w, log, newProblem(r, http.StatusForbidden, "The admin API is disabled"))
+		}
+	}
+	return RequireRole(log, authn, []auth.Role{auth.RoleAdmin}, next)
+}
+
+// RequireReader lets callers with any role read orders. A nil authn leaves the
+// endpoint open.
+func RequireReader(log logger.Logger, authn auth.Authenticator, next http.HandlerFunc) http.HandlerFunc {
+	if authn == nil {
+		return next
+	}
+	return RequireRole(log, authn, []auth.Role{auth.RoleAdmin, auth.RoleSupport}, next)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/breaker"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+)
+
+const (
+	defaultCustomerOrdersLimit = 20
+	maxCustomerOrdersLimit     = 100
+)
+
+// Sort orders of the customer order history.
+const (
+	SortDateCreatedAsc  = "date_created"
+	SortDateCreatedDesc = "-date_created"
+)
+
+// CustomerOrders is the body of the customer order history endpoint.
+type CustomerOrders struct {
+	CustomerID string                `json:"customer_id"`
+	Sort       string                `json:"sort"`
+	Orders     []models.OrderSummary `json:"orders"`
+	// NextCursor fetches the next page when passed as ?cursor=; it is empty on
+	// the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// pageCursor is the position after the last order of a page. It is sent to
+// clients base64-encoded and treated as opaque by them.
+type pageCursor struct {
+	DateCreated string `json:"d"`
+	ID          int    `json:"i"`
+}
+
+func (c pageCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	if err = json.Unmarshal(data, &c); err != nil {
+		return c, err
+	}
+	if c.ID <= 0 {
+		return c, fmt.Errorf("invalid order id %d in cursor", c.ID)
+	}
+	return c, nil
+}
+
+// HandlerCustomerOrders lists the orders of the customer in the customer_id
+// path value as summaries, newest first unless ?sort=date_created. Pages hold
+// ?limit= orders (20 by default, at most 100) and are walked with the
+// next_cursor of the previous page.
+func HandlerCustomerOrders(log logger.Logger, db postgres.PostgresDB, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, log, newProblem(r, http.StatusMethodNotAllowed, "Use GET"))
+		return
+	}
+
+	query := postgres.CustomerOrdersQuery{
+		CustomerID: r.PathValue("customer_id"),
+		Limit:      defaultCustomerOrdersLimit,
+	}
+	if query.CustomerID == "" {
+		writeProblem(w, log, newProblem(r, http.StatusBadRequest, "Invalid customer ID"))
+		return
+	}
+
+	params := r.URL.Query()
+	sort := params.Get("sort")
+	switch sort {
+	case "", SortDateCreatedDesc:
+		sort = SortDateCreatedDesc
+	case SortDateCreatedAsc:
+		query.Ascending = true
+	default:
+		writeProblem(w, log, newProblem(r, http.StatusBadRequest, fmt.Sprintf("Invalid sort, use %s or %s", SortDateCreatedAsc, SortDateCreatedDesc)))
+		return
+	}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxCustomerOrdersLimit {
+			writeProblem(w, log, newProblem(r, http.StatusBadRequest, fmt.Sprintf("Invalid limit, use 1 to %d", maxCustomerOrdersLimit)))
+			return
+		}
+		query.Limit = limit
+	}
+
+	if value := params.Get("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			log.Warn("Invalid customer orders cursor", err)
+			writeProblem(w, log, newProblem(r, http.StatusBadRequest, "Invalid cursor"))
+			return
+		}
+		query.AfterDate, query.AfterID = cursor.DateCreated, cursor.ID
+	}
+
+	// One order more than the page tells whether there is a next page.
+	pageSize := query.Limit
+	query.Limit++
+	orders, err := db.ListCustomerOrders(r.Context(), query)
+	if errors.Is(err, breaker.ErrOpen) {
+		log.Warn(fmt.Sprintf("Orders of customer %s unavailable while the database is down", query.CustomerID), err)
+		writeProblem(w, log, newProblem(r, http.StatusServiceUnavailable, "Order history is temporarily unavailable"))
+		return
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("Error listing orders of customer %s", query.CustomerID), err)
+		writeProblem(w, log, newProblem(r, http.StatusInternalServerError, ""))
+		return
+	}
+
+	body := CustomerOrders{CustomerID: query.CustomerID, Sort: sort, Orders: orders}
+	if len(orders) > pageSize {
+		body.Orders = orders[:pageSize]
+		last := body.Orders[pageSize-1]
+		body.NextCursor = pageCursor{DateCreated: last.DateCreated, ID: last.ID}.encode()
+	}
+	if body.Orders == nil {
+		body.Orders = []models.OrderSummary{}
+	}
+
+	writeJSON(w, log, body)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"wb-kafka-service/internal/export"
+	"wb-kafka-service/pkg/breaker"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+)
+
+// responseWriter notes whether anything reached the client, after which the
+// status can't be changed any more.
+type responseWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+// HandlerExport streams the orders selected by the query parameters as
+// flattened rows, one per item, in ?format=csv (default), ndjson or columnar.
+// ?from= and ?to= are inclusive dates of date_created; customer_id,
+// delivery_service and status filter on those order fields.
+//
+// Rows are written as they are read from a DB cursor. If the export fails
+// once rows were sent, the connection is aborted so the client sees a
+// truncated response rather than a complete-looking file.
+func HandlerExport(log logger.Logger, db postgres.PostgresDB, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, log, newProblem(r, http.StatusMethodNotAllowed, "Use GET"))
+		return
+	}
+
+	params := r.URL.Query()
+	format := params.Get("format")
+	if format == "" {
+		format = export.FormatCSV
+	}
+	if !slices.Contains(export.Formats, format) {
+		writeProblem(w, log, newProblem(r, http.StatusBadRequest, fmt.Sprintf("Invalid format, use one of %s", strings.Join(export.Formats, ", "))))
+		return
+	}
+
+	rng, problem := reportRange(params.Get("from"), params.Get("to"))
+	if problem != "" {
+		writeProblem(w, log, newProblem(r, http.StatusBadRequest, problem))
+		return
+	}
+	filter := postgres.ExportFilter{
+		Range:           rng,
+		CustomerID:      params.Get("customer_id"),
+		DeliveryService: params.Get("delivery_service"),
+		Status:          params.Get("status"),
+	}
+
+	out := &responseWriter{ResponseWriter: w}
+	writer, err := export.NewWriter(format, out)
+	if err != nil {
+		log.Error("Error creating export writer", err)
+		writeProblem(w, log, newProblem(r, http.StatusInternalServerError, ""))
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "orders."+export.Extension(format)))
+	flusher, _ := w.(http.Flusher)
+
+	rows, err := export.Orders(r.Context(), db, filter, writer, func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+	if err == nil {
+		log.Info(fmt.Sprintf("Exported %d order rows as %s", rows, format))
+		return
+	}
+
+	if r.Context().Err() != nil {
+		log.Warn(fmt.Sprintf("Export cancelled by the client after %d rows", rows), err)
+		return
+	}
+	if out.wrote {
+		log.Error(fmt.Sprintf("Export failed after %d rows", rows), err)
+		panic(http.ErrAbortHandler)
+	}
+
+	w.Header().Del("Content-Disposition")
+	if errors.Is(err, breaker.ErrOpen) {
+		log.Warn("Export unavailable while the database is down", err)
+		writeProblem(w, log, newProblem(r, http.StatusServiceUnavailable, "Export is temporarily unavailable"))
+		return
+	}
+	log.Error("Error exporting orders", err)
+	writeProblem(w, log, newProblem(r, http.StatusInternalServerError, ""))
+}
@@ -1,113 +1,89 @@
 package handlers
 
 import (
-	"context"
-	"encoding/json"
-	"html/template"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
+	"wb-kafka-service/internal/auth"
 	"wb-kafka-service/internal/cache"
 	"wb-kafka-service/internal/models"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/internal/web"
+	"wb-kafka-service/pkg/breaker"
 	"wb-kafka-service/pkg/logger"
-	"wb-kafka-service/pkg/postgres"
-
-	"github.com/bradfitz/gomemcache/memcache"
-	"github.com/go-playground/validator/v10" 
 )
 
-type OrderPageData struct {
-	Order    models.Order
-	Delivery models.Delivery
-	Payment  models.Payment
-	Items    []models.Items
-}
+// HandlerIndex renders the start page with the order search.
+func HandlerIndex(log logger.Logger, renderer *web.Renderer, w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeProblem(w, log, newProblem(r, http.StatusNotFound, ""))
+		return
+	}
 
-var validate = validator.New()
+	err := renderer.Render(w, web.PageIndex, renderer.PageData(r, ""))
+	if err != nil {
+		log.Error("Error rendering index page", err)
+		writeProblem(w, log, newProblem(r, http.StatusInternalServerError, ""))
+	}
+}
 
-func HandlerOrder(log logger.Logger, cacheClient cache.MemCacheClient, db postgres.PostgresDB, w http.ResponseWriter, r *http.Request) {
+func HandlerOrder(log logger.Logger, loader *cache.OrderLoader, renderer *web.Renderer, w http.ResponseWriter, r *http.Request) {
 	orderIDStr := r.URL.Query().Get("id")
 	orderID, err := strconv.Atoi(orderIDStr)
 	if err != nil {
 		log.Error("Invalid order ID", err)
-		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		writeProblem(w, log, newProblem(r, http.StatusBadRequest, "Invalid order ID"))
 		return
 	}
 
-	orderItem, err := cacheClient.Get("order:" + strconv.Itoa(orderID))
-	if err == nil {
-		order := models.Order{}
-		err = json.Unmarshal(orderItem.Value, &order)
-		if err != nil {
-			log.Error("Error unmarshalling order", err)
-			http.Error(w, "Error unmarshalling order", http.StatusInternalServerError)
-			return
-		}
-
-		if err := validateOrder(&order, log, w); err != nil {
-			return
-		}
-
-		renderOrderPage(w, order, log)
+	order, err := loader.Get(r.Context(), orderID)
+	if errors.Is(err, cache.ErrOrderNotFound) {
+		log.Warn("Order not found", nil)
+		writeProblem(w, log, newProblem(r, http.StatusNotFound, "Order not found"))
+		return
+	}
+	if errors.Is(err, breaker.ErrOpen) {
+		// Cache-only mode: the order isn't cached and the DB is unavailable.
+		log.Warn(fmt.Sprintf("Order %d unavailable while the database is down", orderID), err)
+		writeProblem(w, log, newProblem(r, http.StatusServiceUnavailable, "The order is temporarily unavailable"))
 		return
 	}
-
-	order, err := db.GetOrderFromDB(context.Background(), orderID)
 	if err != nil {
-		log.Warn("Order not found", nil)
-		http.Error(w, "Order not found", http.StatusNotFound)
+		log.Error("Error loading order", err)
+		writeProblem(w, log, newProblem(r, http.StatusInternalServerError, ""))
 		return
 	}
 
-	if err := validateOrder(order, log, w); err != nil {
+	if err := validateOrder(order, log, w, r); err != nil {
 		return
 	}
 
-	orderData, err := json.Marshal(order)
-	if err != nil {
-		log.Error("Error marshalling order", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	if principal := auth.FromContext(r.Context()); principal != nil && !principal.Role.SeesPII() {
+		order = auth.MaskOrder(order)
 	}
-	err = cacheClient.Set(&memcache.Item{Key: "order:" + strconv.Itoa(orderID), Value: orderData})
+
+	data := renderer.PageData(r, order.Locale)
+	data.SearchID = orderIDStr
+	data.Order = order
+	err = renderer.Render(w, web.PageOrder, data)
 	if err != nil {
-		log.Error("Error saving order to cache", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		log.Error("Error rendering order page", err)
+		writeProblem(w, log, newProblem(r, http.StatusInternalServerError, ""))
 	}
-
-	renderOrderPage(w, *order, log)
 }
 
-func validateOrder(order *models.Order, log logger.Logger, w http.ResponseWriter) error {
-	if err := validate.Struct(order); err != nil {
-		log.Error("Order validation failed", err)
-		http.Error(w, "Invalid order data", http.StatusBadRequest)
+// validateOrder writes an invalid-order problem listing every broken rule.
+func validateOrder(order *models.Order, log logger.Logger, w http.ResponseWriter, r *http.Request) error {
+	if err := validation.Default.Validate(order); err != nil {
+		log.Error(fmt.Sprintf("Order %s failed validation", order.OrderUid), err)
+		problem := newProblem(r, http.StatusBadRequest, "Invalid order data")
+		problem.Type = ProblemInvalidOrder
+		problem.Title = "Invalid order"
+		problem.Violations = validation.Violations(err)
+		writeProblem(w, log, problem)
 		return err
 	}
 	return nil
 }
-
-func renderOrderPage(w http.ResponseWriter, order models.Order, log logger.Logger) {
-	delivery := order.Delivery
-	payment := order.Payment
-	items := order.Items
-
-	for i, item := range items {
-		item.ID = i + 1
-		items[i] = item
-	}
-
-	tmpl := template.Must(template.ParseFiles("../.././ui.html"))
-	data := OrderPageData{
-		Order:    order,
-		Delivery: delivery,
-		Payment:  payment,
-		Items:    items,
-	}
-
-	err := tmpl.Execute(w, data)
-	if err != nil {
-		log.Error("Error executing template", err)
-	}
-}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/pkg/breaker"
+	"wb-kafka-service/pkg/logger"
+)
+
+// HandlerMetrics serves circuit breaker states, consumer counters and HTTP
+// limit counters in the Prometheus text format. limits may be nil.
+func HandlerMetrics(log logger.Logger, breakers []*breaker.Breaker, limits *Limits, w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+
+	b.WriteString("# HELP circuit_breaker_state Circuit breaker state: 0 closed, 1 open, 2 half-open.\n")
+	b.WriteString("# TYPE circuit_breaker_state gauge\n")
+	for _, br := range breakers {
+		fmt.Fprintf(&b, "circuit_breaker_state{name=%q} %d\n", br.Name(), br.State())
+	}
+
+	b.WriteString("# HELP kafka_consumer_messages_total Messages read by the consumer, by outcome.\n")
+	b.WriteString("# TYPE kafka_consumer_messages_total counter\n")
+	for _, c := range []struct {
+		outcome string
+		value   int64
+	}{
+		{"processed", kafka.Stats.Processed.Load()},
+		{"duplicate", kafka.Stats.Duplicates.Load()},
+		{"failed", kafka.Stats.Failed.Load()},
+		{"dead_lettered", kafka.Stats.DeadLettered.Load()},
+	} {
+		fmt.Fprintf(&b, "kafka_consumer_messages_total{outcome=%q} %d\n", c.outcome, c.value)
+	}
+
+	feed := kafka.Feed.Stats()
+	b.WriteString("# HELP order_stream_subscribers Clients connected to the live order stream.\n")
+	b.WriteString("# TYPE order_stream_subscribers gauge\n")
+	fmt.Fprintf(&b, "order_stream_subscribers %d\n", feed.Subscribers)
+	b.WriteString("# HELP order_stream_evicted_total Order stream clients disconnected for falling behind.\n")
+	b.WriteString("# TYPE order_stream_evicted_total counter\n")
+	fmt.Fprintf(&b, "order_stream_evicted_total %d\n", feed.Evicted)
+
+	if limits != nil {
+		stats := limits.Stats()
+		b.WriteString("# HELP http_requests_rejected_total HTTP requests rejected by rate or concurrency limits, by reason.\n")
+		b.WriteString("# TYPE http_requests_rejected_total counter\n")
+		for _, reason := range []string{RejectedIPRate, RejectedKeyRate, RejectedConcurrency} {
+			fmt.Fprintf(&b, "http_requests_rejected_total{reason=%q} %d\n", reason, stats.Rejected[reason])
+		}
+		b.WriteString("# HELP http_db_requests_in_flight Requests holding a slot of the DB concurrency limit.\n")
+		b.WriteString("# TYPE http_db_requests_in_flight gauge\n")
+		fmt.Fprintf(&b, "http_db_requests_in_flight %d\n", stats.InFlight)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, err := w.Write([]byte(b.String()))
+	if err != nil {
+		log.Error("Error writing metrics", err)
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/pkg/logger"
+)
+
+// ProblemInvalidOrder is the problem type of orders that break validation rules.
+const ProblemInvalidOrder = "urn:wb-kafka-service:problem:invalid-order"
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Violations lists the broken rules of an invalid-order problem.
+	Violations []validation.Violation `json:"violations,omitempty"`
+}
+
+// newProblem returns a problem of the generic "about:blank" type, titled after status.
+func newProblem(r *http.Request, status int, detail string) Problem {
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.RequestURI(),
+	}
+}
+
+func writeProblem(w http.ResponseWriter, log logger.Logger, problem Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+
+	err := json.NewEncoder(w).Encode(problem)
+	if err != nil {
+		log.Error("Error writing problem response", err)
+	}
+}
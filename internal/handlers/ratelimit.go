@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"wb-kafka-service/internal/auth"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/ratelimit"
+)
+
+// Reasons a request is rejected by Limits, as reported in metrics.
+const (
+	RejectedIPRate      = "ip_rate"
+	RejectedKeyRate     = "key_rate"
+	RejectedConcurrency = "concurrency"
+)
+
+// Limits holds the HTTP rate and concurrency limits of the rate_limit config
+// section and counts the requests they reject.
+type Limits struct {
+	// perIP, perKey and concurrency are nil when not limited.
+	perIP             *ratelimit.Keyed
+	perKey            *ratelimit.Keyed
+	concurrency       *ratelimit.Semaphore
+	queueTimeout      time.Duration
+	trustForwardedFor bool
+
+	rejectedIPRate      atomic.Int64
+	rejectedKeyRate     atomic.Int64
+	rejectedConcurrency atomic.Int64
+}
+
+// LimitStats is a snapshot of Limits.
+type LimitStats struct {
+	// Rejected counts rejected requests by reason.
+	Rejected map[string]int64
+	// InFlight and MaxConcurrent describe the concurrency limit; both are 0
+	// without one.
+	InFlight      int
+	MaxConcurrent int
+}
+
+func NewLimits(cfg config.AppConfig) *Limits {
+	rl := cfg.RateLimit
+	l := &Limits{queueTimeout: rl.QueueTimeout, trustForwardedFor: rl.TrustForwardedFor}
+	if rl.PerIP.Rate > 0 {
+		l.perIP = ratelimit.NewKeyed(rl.PerIP.Rate, rl.PerIP.Burst)
+	}
+	if rl.PerKey.Rate > 0 {
+		l.perKey = ratelimit.NewKeyed(rl.PerKey.Rate, rl.PerKey.Burst)
+	}
+	if rl.MaxConcurrent > 0 {
+		l.concurrency = ratelimit.NewSemaphore(rl.MaxConcurrent)
+	}
+	return l
+}
+
+func (l *Limits) Stats() LimitStats {
+	stats := LimitStats{Rejected: map[string]int64{
+		RejectedIPRate:      l.rejectedIPRate.Load(),
+		RejectedKeyRate:     l.rejectedKeyRate.Load(),
+		RejectedConcurrency: l.rejectedConcurrency.Load(),
+	}}
+	if l.concurrency != nil {
+		stats.InFlight = l.concurrency.InUse()
+		stats.MaxConcurrent = l.concurrency.Size()
+	}
+	return stats
+}
+
+// clientIP returns the address requests of r are limited by.
+func (l *Limits) clientIP(r *http.Request) string {
+	if l.trustForwardedFor {
+		if hops := r.Header.Values("X-Forwarded-For"); len(hops) > 0 {
+			// The last entry was added by our proxy; earlier ones may be forged.
+			last := hops[len(hops)-1]
+			if i := strings.LastIndexByte(last, ','); i >= 0 {
+				last = last[i+1:]
+			}
+			if ip := strings.TrimSpace(last); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientKey returns the API key or token of r, hashed so credentials aren't
+// kept in memory, or "" if r has none.
+func clientKey(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = auth.Token(r)
+	}
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
+
+// RateLimit lets a request through to next if neither its client address nor
+// its API key or token has used up its bucket. Otherwise it answers 429 with
+// Retry-After. Invalid credentials are limited like valid ones, so guessing is
+// limited too; a nil limits disables limiting.
+func RateLimit(log logger.Logger, limits *Limits, next http.HandlerFunc) http.HandlerFunc {
+	if limits == nil || (limits.perIP == nil && limits.perKey == nil) {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limits.perIP != nil {
+			ip := limits.clientIP(r)
+			if ok, retry := limits.perIP.Take(ip); !ok {
+				limits.rejectedIPRate.Add(1)
+				log.Warn(fmt.Sprintf("Rate limited %s %s from %s", r.Method, r.URL.Path, ip), nil)
+				writeTooManyRequests(w, log, r, retry, "Too many requests from your address")
+				return
+			}
+		}
+
+		if limits.perKey != nil {
+			if key := clientKey(r); key != "" {
+				if ok, retry := limits.perKey.Take(key); !ok {
+					limits.rejectedKeyRate.Add(1)
+					log.Warn(fmt.Sprintf("Rate limited %s %s for key %s", r.Method, r.URL.Path, key[:8]), nil)
+					writeTooManyRequests(w, log, r, retry, "Too many requests with your credentials")
+					return
+				}
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// LimitConcurrency lets at most rate_limit.max_concurrent requests run next at
+// once; wrap handlers that use the DB with it so that bursts wait here rather
+// than for a pgx connection. A request that gets no slot within the queue
+// timeout is answered 503 with Retry-After.
+func LimitConcurrency(log logger.Logger, limits *Limits, next http.HandlerFunc) http.HandlerFunc {
+	if limits == nil || limits.concurrency == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), limits.queueTimeout)
+		err := limits.concurrency.Acquire(ctx)
+		cancel()
+		if err != nil {
+			if r.Context().Err() != nil {
+				// The client went away while queued.
+				return
+			}
+			limits.rejectedConcurrency.Add(1)
+			log.Warn(fmt.Sprintf("Rejected %s %s: %d requests already use the DB", r.Method, r.URL.Path, limits.concurrency.Size()), nil)
+			w.Header().Set("Retry-After", "1")
+			writeProblem(w, log, newProblem(r, http.StatusServiceUnavailable, "The service is busy, try again later"))
+			return
+		}
+		defer limits.concurrency.Release()
+
+		next(w, r)
+	}
+}
+
+// writeTooManyRequests answers 429 with Retry-After in whole seconds, at least 1.
+// A negative retry means the bucket never refills, and Retry-After is left out.
+func writeTooManyRequests(w http.ResponseWriter, log logger.Logger, r *http.Request, retry time.Duration, detail string) {
+	if retry >= 0 {
+		seconds := max(1, int64(math.Ceil(retry.Seconds())))
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+	writeProblem(w, log, newProblem(r, http.StatusTooManyRequests, detail))
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"wb-kafka-service/internal/analytics"
+	"wb-kafka-service/pkg/breaker"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+)
+
+const (
+	defaultTopProductsLimit = 10
+	maxTopProductsLimit     = 100
+	defaultSaleBucketWidth  = 10
+)
+
+// Formats of the report endpoint.
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// HandlerReport serves the analytics report named by the report path value,
+// see analytics.Names, as JSON or, with ?format=csv or Accept: text/csv, as
+// CSV. ?from= and ?to= limit it to orders created on those dates, inclusive.
+//
+// top-products takes ?by=brand|nm_id, ?sort=revenue|quantity and ?limit=;
+// sale-distribution takes the bucket ?width= in percentage points.
+func HandlerReport(log logger.Logger, reports *analytics.Reports, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, log, newProblem(r, http.StatusMethodNotAllowed, "Use GET"))
+		return
+	}
+
+	params := r.URL.Query()
+	format := params.Get("format")
+	switch {
+	case format == "" && strings.Contains(r.Header.Get("Accept"), "text/csv"):
+		format = FormatCSV
+	case format == "":
+		format = FormatJSON
+	case format != FormatJSON && format != FormatCSV:
+		writeProblem(w, log, newProblem(r, http.StatusBadRequest, "Invalid format, use json or csv"))
+		return
+	}
+
+	rng, problem := reportRange(params.Get("from"), params.Get("to"))
+	if problem != "" {
+		writeProblem(w, log, newProblem(r, http.StatusBadRequest, problem))
+		return
+	}
+
+	name := r.PathValue("report")
+	switch name {
+	case analytics.ReportRevenue:
+		result, err := reports.Revenue(r.Context(), rng)
+		writeReport(log, w, r, name, format, result, err)
+	case analytics.ReportDeliveryCosts:
+		result, err := reports.DeliveryCosts(r.Context(), rng)
+		writeReport(log, w, r, name, format, result, err)
+	case analytics.ReportTopProducts:
+		by := params.Get("by")
+		if by == "" {
+			by = postgres.ProductsByBrand
+		}
+		sortBy := params.Get("sort")
+		if sortBy == "" {
+			sortBy = postgres.SortByRevenue
+		}
+		if by != postgres.ProductsByBrand && by != postgres.ProductsByNmID {
+			writeProblem(w, log, newProblem(r, http.StatusBadRequest, "Invalid by, use brand or nm_id"))
+			return
+		}
+		if sortBy != postgres.SortByRevenue && sortBy != postgres.SortByQuantity {
+			writeProblem(w, log, newProblem(r, http.StatusBadRequest, "Invalid sort, use revenue or quantity"))
+			return
+		}
+		limit, ok := intParam(params.Get("limit"), defaultTopProductsLimit, 1, maxTopProductsLimit)
+		if !ok {
+			writeProblem(w, log, newProblem(r, http.StatusBadRequest, fmt.Sprintf("Invalid limit, use 1 to %d", maxTopProductsLimit)))
+			return
+		}
+		result, err := reports.TopProducts(r.Context(), rng, by, sortBy, limit)
+		writeReport(log, w, r, name, format, result, err)
+	case analytics.ReportSaleDistribution:
+		width, ok := intParam(params.Get("width"), defaultSaleBucketWidth, 1, 100)
+		if !ok {
+			writeProblem(w, log, newProblem(r, http.StatusBadRequest, "Invalid width, use 1 to 100"))
+			return
+		}
+		result, err := reports.SaleDistribution(r.Context(), rng, width)
+		writeReport(log, w, r, name, format, result, err)
+	default:
+		writeProblem(w, log, newProblem(r, http.StatusNotFound, fmt.Sprintf("Unknown report, use one of %s", strings.Join(analytics.Names, ", "))))
+	}
+}
+
+// reportRange parses the from and to query parameters, see postgres.DateRange.
+// If they are invalid it returns the problem detail.
+func reportRange(from, to string) (postgres.ReportRange, string) {
+	rng, err := postgres.DateRange(from, to)
+	if err != nil {
+		return rng, "Invalid date range: " + err.Error()
+	}
+	return rng, ""
+}
+
+// intParam parses value, returning def for "", and reports whether it is an
+// integer from lo to hi.
+func intParam(value string, def, lo, hi int) (int, bool) {
+	if value == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(value)
+	return n, err == nil && n >= lo && n <= hi
+}
+
+func writeReport[T analytics.Table](log logger.Logger, w http.ResponseWriter, r *http.Request, name, format string, result analytics.Result[T], err error) {
+	if errors.Is(err, breaker.ErrOpen) {
+		log.Warn(fmt.Sprintf("Report %s unavailable while the database is down", name), err)
+		writeProblem(w, log, newProblem(r, http.StatusServiceUnavailable, "Reports are temporarily unavailable"))
+		return
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("Error computing report %s", name), err)
+		writeProblem(w, log, newProblem(r, http.StatusInternalServerError, ""))
+		return
+	}
+
+	if format != FormatCSV {
+		writeJSON(w, log, result)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+	w.Header().Set("Last-Modified", result.GeneratedAt.Format(http.TimeFormat))
+	out := csv.NewWriter(w)
+	records := slices.Insert(result.Rows.Records(), 0, result.Rows.Header())
+	if err := out.WriteAll(records); err != nil {
+		log.Error(fmt.Sprintf("Error writing report %s", name), err)
+	}
+}
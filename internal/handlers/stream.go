@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/stream"
+	"wb-kafka-service/internal/web"
+	"wb-kafka-service/pkg/logger"
+
+	"golang.org/x/net/websocket"
+)
+
+// StreamMessage is a WebSocket message of the order stream: an "order" with
+// the event, a "ping" on idle streams, or "evicted" before a slow client is
+// disconnected.
+type StreamMessage struct {
+	Type  string             `json:"type"`
+	Order *stream.OrderEvent `json:"order,omitempty"`
+}
+
+// HandlerOrderStream streams stored orders as Server-Sent Events, or over a
+// WebSocket if the request asks for an upgrade. The customer_id and
+// delivery_service query parameters filter the orders.
+func HandlerOrderStream(log logger.Logger, hub *stream.Hub, cfg config.AppConfig, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := stream.Filter{
+		CustomerID:      query.Get("customer_id"),
+		DeliveryService: query.Get("delivery_service"),
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		server := websocket.Server{
+			Handshake: func(wsConfig *websocket.Config, r *http.Request) error {
+				err := checkOrigin(cfg, wsConfig, r)
+				if err != nil {
+					log.Warn(fmt.Sprintf("Rejected order stream WebSocket from %s", r.RemoteAddr), err)
+				}
+				return err
+			},
+			Handler: func(ws *websocket.Conn) {
+				streamWebSocket(log, hub, cfg, filter, ws)
+			},
+		}
+		server.ServeHTTP(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, log, newProblem(r, http.StatusInternalServerError, "Streaming is not supported"))
+		return
+	}
+
+	sub := hub.Subscribe(filter, cfg.Stream.ClientBuffer)
+	defer hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 3000\n: connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(cfg.Stream.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.Evicted:
+			log.Warn(fmt.Sprintf("Evicted slow order stream client %s", r.RemoteAddr), nil)
+			fmt.Fprint(w, "event: evicted\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		case event := <-sub.C:
+			var data []byte
+			data, err = json.Marshal(event)
+			if err == nil {
+				_, err = fmt.Fprintf(w, "id: %d\nevent: order\ndata: %s\n\n", event.Seq, data)
+			}
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		}
+		if err != nil {
+			log.Error("Error writing order stream", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// checkOrigin accepts WebSocket handshakes from pages served by this host or
+// by one of cfg.Stream.AllowedOrigins, so other sites can't open the stream
+// with a visitor's credentials. Requests without an Origin don't come from a
+// browser page and are accepted.
+func checkOrigin(cfg config.AppConfig, wsConfig *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(wsConfig, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil {
+		return nil
+	}
+	wsConfig.Origin = origin
+
+	if strings.EqualFold(origin.Host, r.Host) {
+		return nil
+	}
+	for _, allowed := range cfg.Stream.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin.Scheme+"://"+origin.Host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s is not allowed", origin)
+}
+
+func streamWebSocket(log logger.Logger, hub *stream.Hub, cfg config.AppConfig, filter stream.Filter, ws *websocket.Conn) {
+	sub := hub.Subscribe(filter, cfg.Stream.ClientBuffer)
+	defer hub.Unsubscribe(sub)
+
+	// Clients only listen; reading notices when they go away.
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		var discard string
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+	}()
+
+	heartbeat := time.NewTicker(cfg.Stream.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var message StreamMessage
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.Evicted:
+			log.Warn(fmt.Sprintf("Evicted slow order stream client %s", ws.Request().RemoteAddr), nil)
+			websocket.JSON.Send(ws, StreamMessage{Type: "evicted"})
+			return
+		case event := <-sub.C:
+			message = StreamMessage{Type: "order", Order: &event}
+		case <-heartbeat.C:
+			message = StreamMessage{Type: "ping"}
+		}
+
+		err := websocket.JSON.Send(ws, message)
+		if err != nil {
+			log.Error("Error writing order stream", err)
+			return
+		}
+	}
+}
+
+// HandlerLive renders the page that shows the order stream.
+func HandlerLive(log logger.Logger, renderer *web.Renderer, w http.ResponseWriter, r *http.Request) {
+	data := renderer.PageData(r, "")
+	query := r.URL.Query()
+	data.Filter = stream.Filter{
+		CustomerID:      query.Get("customer_id"),
+		DeliveryService: query.Get("delivery_service"),
+	}
+
+	err := renderer.Render(w, web.PageLive, data)
+	if err != nil {
+		log.Error("Error rendering live page", err)
+		writeProblem(w, log, newProblem(r, http.StatusInternalServerError, ""))
+	}
+}
@@ -0,0 +1,152 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is used when neither the request nor the order name a
+// language with a catalog. Its catalog also fills keys missing from others.
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// Catalog holds the messages and formatting rules of one language. It is
+// loaded from locales/<lang>.json.
+type Catalog struct {
+	// Messages maps a key to a fmt format. Plural messages have one key per
+	// form: "<key>.one", "<key>.few", "<key>.many" and "<key>.other".
+	Messages map[string]string `json:"messages"`
+	// Group separates thousands in numbers and amounts.
+	Group string `json:"group"`
+	// Money, Percent and DateTime are patterns with {placeholders}.
+	Money    string `json:"money"`
+	Percent  string `json:"percent"`
+	DateTime string `json:"datetime"`
+	// Months are the month names used in dates, January first.
+	Months []string `json:"months"`
+}
+
+// Bundle holds the catalogs of every supported language.
+type Bundle struct {
+	catalogs map[string]*Catalog
+}
+
+// Load reads the embedded catalogs.
+func Load() (*Bundle, error) {
+	files, err := locales.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{catalogs: make(map[string]*Catalog, len(files))}
+	for _, file := range files {
+		data, err := locales.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var catalog Catalog
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return nil, fmt.Errorf("error parsing catalog %s: %w", file.Name(), err)
+		}
+		if len(catalog.Months) != 12 {
+			return nil, fmt.Errorf("catalog %s has %d months", file.Name(), len(catalog.Months))
+		}
+		b.catalogs[strings.TrimSuffix(file.Name(), ".json")] = &catalog
+	}
+
+	if b.catalogs[DefaultLanguage] == nil {
+		return nil, fmt.Errorf("no catalog for the default language %q", DefaultLanguage)
+	}
+	return b, nil
+}
+
+// Languages returns the supported languages, sorted.
+func (b *Bundle) Languages() []string {
+	langs := make([]string, 0, len(b.catalogs))
+	for lang := range b.catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Match returns the supported language of a tag such as "ru-RU", or "".
+func (b *Bundle) Match(tag string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	lang, _, _ = strings.Cut(lang, "_")
+	if _, ok := b.catalogs[lang]; ok {
+		return lang
+	}
+	return ""
+}
+
+// Localizer returns the localizer of lang, or of DefaultLanguage if lang
+// isn't supported.
+func (b *Bundle) Localizer(lang string) *Localizer {
+	if lang = b.Match(lang); lang == "" {
+		lang = DefaultLanguage
+	}
+	return &Localizer{lang: lang, catalog: b.catalogs[lang], fallback: b.catalogs[DefaultLanguage]}
+}
+
+// Negotiate picks the language of a response: the lang query parameter, then
+// the Accept-Language header, then fallback (e.g. the order's locale).
+func (b *Bundle) Negotiate(r *http.Request, fallback string) *Localizer {
+	if lang := b.Match(r.URL.Query().Get("lang")); lang != "" {
+		return b.Localizer(lang)
+	}
+	for _, tag := range AcceptedLanguages(r.Header.Get("Accept-Language")) {
+		if lang := b.Match(tag); lang != "" {
+			return b.Localizer(lang)
+		}
+	}
+	return b.Localizer(fallback)
+}
+
+// AcceptedLanguages returns the tags of an Accept-Language header by
+// decreasing quality, leaving out "*" and tags with q=0.
+func AcceptedLanguages(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag, q})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
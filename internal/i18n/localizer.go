@@ -0,0 +1,135 @@
+package i18n
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Localizer translates messages and formats values for one language.
+type Localizer struct {
+	lang     string
+	catalog  *Catalog
+	fallback *Catalog
+}
+
+// Lang returns the language code, e.g. "ru".
+func (l *Localizer) Lang() string {
+	return l.lang
+}
+
+// T returns the message of key formatted with args. A key missing from the
+// catalog falls back to the default language, then to the key itself.
+func (l *Localizer) T(key string, args ...any) string {
+	format, ok := l.catalog.Messages[key]
+	if !ok {
+		format, ok = l.fallback.Messages[key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// N returns the plural form of key that agrees with n, formatted with n and args.
+func (l *Localizer) N(key string, n int, args ...any) string {
+	args = append([]any{n}, args...)
+	form := key + "." + pluralForm(l.lang, n)
+	if _, ok := l.catalog.Messages[form]; ok {
+		return l.T(form, args...)
+	}
+	return l.T(key+".other", args...)
+}
+
+// pluralForm returns the CLDR plural category of n for integers.
+func pluralForm(lang string, n int) string {
+	if n < 0 {
+		n = -n
+	}
+	switch lang {
+	case "ru":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	default:
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	}
+}
+
+// Number formats n with thousands separators, e.g. "1,817" or "1 817".
+func (l *Localizer) Number(n int) string {
+	digits := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(l.catalog.Group)
+		}
+		b.WriteRune(d)
+	}
+	return b.String()
+}
+
+// Money formats a whole amount in currency, e.g. "1,817 USD". Without a
+// currency only the number is returned.
+func (l *Localizer) Money(amount int, currency string) string {
+	if currency == "" {
+		return l.Number(amount)
+	}
+	return strings.NewReplacer(
+		"{amount}", l.Number(amount),
+		"{currency}", currency,
+	).Replace(l.catalog.Money)
+}
+
+// Percent formats n percent, e.g. "30%".
+func (l *Localizer) Percent(n int) string {
+	return strings.ReplaceAll(l.catalog.Percent, "{n}", l.Number(n))
+}
+
+// Unix formats a unix time such as payment_dt, in UTC.
+func (l *Localizer) Unix(sec int64) string {
+	if sec == 0 {
+		return ""
+	}
+	return l.Time(time.Unix(sec, 0))
+}
+
+// Date formats an RFC 3339 time such as date_created, in UTC. Values that
+// don't parse are returned unchanged.
+func (l *Localizer) Date(value string) string {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return value
+	}
+	return l.Time(t)
+}
+
+// Time formats t in UTC.
+func (l *Localizer) Time(t time.Time) string {
+	t = t.UTC()
+	return strings.NewReplacer(
+		"{day}", strconv.Itoa(t.Day()),
+		"{month}", l.catalog.Months[t.Month()-1],
+		"{year}", strconv.Itoa(t.Year()),
+		"{time}", t.Format("15:04"),
+		"{zone}", "UTC",
+	).Replace(l.catalog.DateTime)
+}
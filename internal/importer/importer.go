@@ -0,0 +1,234 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/pkg/postgres"
+	"wb-kafka-service/pkg/unmarshal"
+)
+
+// DefaultBatchSize is how many orders an Importer stores at once.
+const DefaultBatchSize = 500
+
+// ErrDuplicate is returned by a Sink for an order it already has.
+var ErrDuplicate = errors.New("duplicate order")
+
+// Sink stores batches of valid orders.
+type Sink interface {
+	// Store returns one error per order: nil if it was stored, ErrDuplicate
+	// or the reason it was refused. The second result is set if the whole
+	// batch failed.
+	Store(ctx context.Context, orders []*models.Order) ([]error, error)
+}
+
+// SinkFunc adapts a function to Sink.
+type SinkFunc func(ctx context.Context, orders []*models.Order) ([]error, error)
+
+func (f SinkFunc) Store(ctx context.Context, orders []*models.Order) ([]error, error) {
+	return f(ctx, orders)
+}
+
+// DBSink inserts orders into the DB; orders already there are duplicates.
+func DBSink(db postgres.PostgresDB) Sink {
+	return SinkFunc(func(ctx context.Context, orders []*models.Order) ([]error, error) {
+		results, err := db.ImportOrders(ctx, orders)
+		for i := range results {
+			if errors.Is(results[i], postgres.ErrOrderExists) {
+				results[i] = ErrDuplicate
+			}
+		}
+		return results, err
+	})
+}
+
+// KafkaSink publishes orders as order.created events. Kafka can't tell
+// duplicates, so only those within the input are found.
+func KafkaSink(producer *kafka.OrderProducer) Sink {
+	return SinkFunc(func(ctx context.Context, orders []*models.Order) ([]error, error) {
+		return producer.PublishOrders(ctx, kafka.EventOrderCreated, orders)
+	})
+}
+
+// Entry is an order that wasn't imported.
+type Entry struct {
+	Position unmarshal.Position
+	// OrderUid is empty if the value couldn't be decoded.
+	OrderUid string
+	Reason   string
+}
+
+func (e Entry) String() string {
+	if e.OrderUid == "" {
+		return fmt.Sprintf("%s: %s", e.Position, e.Reason)
+	}
+	return fmt.Sprintf("%s: order %s: %s", e.Position, e.OrderUid, e.Reason)
+}
+
+// Report tells what happened to every value of the input.
+type Report struct {
+	Accepted   int
+	Duplicates []Entry
+	Rejected   []Entry
+	// Failed are valid orders that weren't stored because their whole batch
+	// failed or the import was stopped first, see Importer.Abort.
+	Failed []Entry
+}
+
+// Print writes the counts, then every order that wasn't imported.
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "accepted: %d, duplicates: %d, rejected: %d, failed: %d\n",
+		r.Accepted, len(r.Duplicates), len(r.Rejected), len(r.Failed))
+	for _, section := range []struct {
+		name    string
+		entries []Entry
+	}{{"duplicates", r.Duplicates}, {"rejected", r.Rejected}, {"failed", r.Failed}} {
+		if len(section.entries) == 0 {
+			continue
+		}
+		lines := make([]string, len(section.entries))
+		for i, e := range section.entries {
+			lines[i] = e.String()
+		}
+		fmt.Fprintf(w, "%s:\n  %s\n", section.name, strings.Join(lines, "\n  "))
+	}
+}
+
+// Importer validates orders and stores them in batches, recording the outcome
+// of each one. An order_uid stored earlier in the input, or waiting in the
+// current batch, is a duplicate.
+type Importer struct {
+	sink      Sink
+	batchSize int
+	batch     []*models.Order
+	positions []unmarshal.Position
+	// pending holds the order_uids of the current batch; they move to seen
+	// once the batch is stored.
+	pending map[string]unmarshal.Position
+	seen    map[string]unmarshal.Position
+	report  Report
+}
+
+func NewImporter(sink Sink, batchSize int) *Importer {
+	return &Importer{
+		sink:      sink,
+		batchSize: max(1, batchSize),
+		pending:   make(map[string]unmarshal.Position),
+		seen:      make(map[string]unmarshal.Position),
+	}
+}
+
+// Read imports the orders in r, see unmarshal.ReadOrders. It stops at the
+// first error that isn't about one order.
+func (im *Importer) Read(ctx context.Context, name string, r io.Reader) error {
+	return unmarshal.ReadOrders(name, r, func(pos unmarshal.Position, order *models.Order, err error) error {
+		return im.Add(ctx, pos, order, err)
+	})
+}
+
+// Add imports order, read at pos, or rejects it with err if it couldn't be
+// decoded. The order is stored once its batch is full. Once ctx is done the
+// current batch is aborted, the order is listed as failed too and ctx.Err()
+// is returned.
+func (im *Importer) Add(ctx context.Context, pos unmarshal.Position, order *models.Order, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		im.Abort(ctxErr)
+		entry := Entry{Position: pos, Reason: ctxErr.Error()}
+		if err == nil {
+			entry.OrderUid = order.OrderUid
+		}
+		im.report.Failed = append(im.report.Failed, entry)
+		return ctxErr
+	}
+	if err != nil {
+		im.report.Rejected = append(im.report.Rejected, Entry{Position: pos, Reason: err.Error()})
+		return nil
+	}
+
+	err = validation.Default.Validate(order)
+	if err != nil {
+		im.report.Rejected = append(im.report.Rejected, Entry{Position: pos, OrderUid: order.OrderUid, Reason: err.Error()})
+		return nil
+	}
+	first, ok := im.seen[order.OrderUid]
+	if !ok {
+		first, ok = im.pending[order.OrderUid]
+	}
+	if ok {
+		im.report.Duplicates = append(im.report.Duplicates, Entry{Position: pos, OrderUid: order.OrderUid, Reason: "already read at " + first.String()})
+		return nil
+	}
+	im.pending[order.OrderUid] = pos
+
+	im.batch = append(im.batch, order)
+	im.positions = append(im.positions, pos)
+	if len(im.batch) < im.batchSize {
+		return nil
+	}
+	return im.Flush(ctx)
+}
+
+// Flush stores the orders of the current batch.
+func (im *Importer) Flush(ctx context.Context) error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	defer im.reset()
+
+	results, err := im.sink.Store(ctx, im.batch)
+	if err == nil && len(results) != len(im.batch) {
+		err = fmt.Errorf("sink returned %d results for %d orders", len(results), len(im.batch))
+	}
+	if err != nil {
+		im.fail(err)
+		return err
+	}
+
+	for i, order := range im.batch {
+		entry := Entry{Position: im.positions[i], OrderUid: order.OrderUid}
+		switch {
+		case results[i] == nil:
+			im.report.Accepted++
+			im.seen[order.OrderUid] = entry.Position
+		case errors.Is(results[i], ErrDuplicate):
+			entry.Reason = "already imported"
+			im.report.Duplicates = append(im.report.Duplicates, entry)
+			im.seen[order.OrderUid] = entry.Position
+		default:
+			entry.Reason = results[i].Error()
+			im.report.Rejected = append(im.report.Rejected, entry)
+		}
+	}
+	return nil
+}
+
+// Abort lists the orders of the current batch as failed with err, for an
+// import that stops before storing them, e.g. because it was interrupted.
+func (im *Importer) Abort(err error) {
+	im.fail(err)
+	im.reset()
+}
+
+func (im *Importer) fail(err error) {
+	for i, order := range im.batch {
+		im.report.Failed = append(im.report.Failed, Entry{Position: im.positions[i], OrderUid: order.OrderUid, Reason: err.Error()})
+	}
+}
+
+func (im *Importer) reset() {
+	im.batch = im.batch[:0]
+	im.positions = im.positions[:0]
+	clear(im.pending)
+}
+
+// Report returns the outcomes so far. Orders of a batch that was neither
+// flushed nor aborted are not in it.
+func (im *Importer) Report() Report {
+	return im.report
+}
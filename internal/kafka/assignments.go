@@ -0,0 +1,93 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// PartitionState is a partition of the orders topic as seen by the consumer
+// group. Member is empty for a partition no member is assigned to. Committed
+// is -1 until the group commits an offset.
+type PartitionState struct {
+	Partition int    `json:"partition"`
+	Member    string `json:"member,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Host      string `json:"host,omitempty"`
+	Committed int64  `json:"committed"`
+	End       int64  `json:"end"`
+	Lag       int64  `json:"lag"`
+}
+
+// Partitions returns the partition assignments of the consumer group and
+// how far the group lags behind each partition.
+func (c *Consumer) Partitions(ctx context.Context) ([]PartitionState, error) {
+	client := &kafka.Client{Addr: kafka.TCP(c.broker)}
+
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{c.topic}})
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata of %s: %w", c.topic, err)
+	}
+	if len(metadata.Topics) != 1 || metadata.Topics[0].Error != nil {
+		return nil, fmt.Errorf("error reading metadata of %s: %v", c.topic, metadata.Topics)
+	}
+
+	states := make(map[int]*PartitionState)
+	var ids []int
+	var ends []kafka.OffsetRequest
+	for _, p := range metadata.Topics[0].Partitions {
+		states[p.ID] = &PartitionState{Partition: p.ID, Committed: -1}
+		ids = append(ids, p.ID)
+		ends = append(ends, kafka.LastOffsetOf(p.ID))
+	}
+
+	groups, err := client.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{GroupIDs: []string{c.groupID}})
+	if err != nil {
+		return nil, fmt.Errorf("error describing group %s: %w", c.groupID, err)
+	}
+	for _, group := range groups.Groups {
+		for _, member := range group.Members {
+			for _, topic := range member.MemberAssignments.Topics {
+				if topic.Topic != c.topic {
+					continue
+				}
+				for _, p := range topic.Partitions {
+					if state, ok := states[p]; ok {
+						state.Member, state.ClientID, state.Host = member.MemberID, member.ClientID, member.ClientHost
+					}
+				}
+			}
+		}
+	}
+
+	committed, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: c.groupID, Topics: map[string][]int{c.topic: ids}})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching offsets of group %s: %w", c.groupID, err)
+	}
+	for _, p := range committed.Topics[c.topic] {
+		if state, ok := states[p.Partition]; ok && p.Error == nil {
+			state.Committed = p.CommittedOffset
+		}
+	}
+
+	offsets, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{c.topic: ends}})
+	if err != nil {
+		return nil, fmt.Errorf("error listing offsets of %s: %w", c.topic, err)
+	}
+	for _, p := range offsets.Topics[c.topic] {
+		if state, ok := states[p.Partition]; ok && p.Error == nil {
+			state.End = p.LastOffset
+		}
+	}
+
+	result := make([]PartitionState, 0, len(states))
+	for _, state := range states {
+		// Without a committed offset the whole partition counts as lag.
+		state.Lag = state.End - max(state.Committed, 0)
+		result = append(result, *state)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Partition < result[j].Partition })
+	return result, nil
+}
@@ -0,0 +1,250 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+	"wb-kafka-service/pkg/ratelimit"
+	"wb-kafka-service/pkg/unmarshal"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// pausePollInterval is how often a paused consumer rechecks its pause conditions.
+	pausePollInterval = time.Second
+	minReadBackoff    = 100 * time.Millisecond
+	maxReadBackoff    = 5 * time.Second
+	// minRetryBackoff and maxRetryBackoff bound the wait before a message
+	// that failed because the DB is unavailable is processed again.
+	minRetryBackoff = 500 * time.Millisecond
+	maxRetryBackoff = 30 * time.Second
+)
+
+// PauseManual is the pause reason reported after Pause.
+const PauseManual = "manual"
+
+// ConsumerStatus tells whether the consumer is paused and why.
+type ConsumerStatus struct {
+	Paused bool   `json:"paused"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type pauseCondition struct {
+	reason string
+	active func() bool
+}
+
+// Consumer reads order events from Kafka and applies them. Processing is rate
+// limited by the kafka.consumer config section and can be paused, either by
+// hand or while a registered condition holds.
+type Consumer struct {
+	reader      *kafka.Reader
+	broker      string
+	topic       string
+	groupID     string
+	deadLetters *DeadLetterWriter
+	db          postgres.PostgresDB
+	log         logger.Logger
+	cacheClient cache.MemCacheClient
+	cacheOpts   cache.Options
+	// limiter is nil when processing is not rate limited.
+	limiter *ratelimit.Bucket
+
+	mu         sync.Mutex
+	paused     bool
+	conditions []pauseCondition
+	wake       chan struct{}
+}
+
+func NewConsumer(cfg config.AppConfig, db postgres.PostgresDB, log logger.Logger, cacheClient cache.MemCacheClient, cacheOpts cache.Options) *Consumer {
+	c := &Consumer{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:  []string{cfg.Kafka.Broker},
+			Topic:    cfg.Kafka.Topic,
+			GroupID:  cfg.Kafka.GroupID,
+			MinBytes: 10e3, // 10KB
+			MaxBytes: 10e6, // 10MB
+		}),
+		broker:      cfg.Kafka.Broker,
+		topic:       cfg.Kafka.Topic,
+		groupID:     cfg.Kafka.GroupID,
+		deadLetters: NewDeadLetterWriter(cfg, log),
+		db:          db,
+		log:         log,
+		cacheClient: cacheClient,
+		cacheOpts:   cacheOpts,
+		wake:        make(chan struct{}, 1),
+	}
+
+	cc := cfg.Kafka.Consumer
+	if cc.RateLimit > 0 {
+		c.limiter = ratelimit.NewBucket(cc.RateLimit, cc.Burst)
+	}
+
+	return c
+}
+
+// PauseWhen keeps the consumer paused while active returns true, reporting
+// reason as the pause reason. active is polled, so it must be cheap.
+func (c *Consumer) PauseWhen(reason string, active func() bool) {
+	c.mu.Lock()
+	c.conditions = append(c.conditions, pauseCondition{reason: reason, active: active})
+	c.mu.Unlock()
+}
+
+// Pause stops consumption until Resume. A read already waiting for a message
+// still completes and its message is processed.
+func (c *Consumer) Pause() {
+	c.mu.Lock()
+	c.paused = true
+	c.mu.Unlock()
+	c.log.Info("Kafka consumer paused by hand")
+}
+
+// Resume undoes Pause. Pause conditions still apply.
+func (c *Consumer) Resume() {
+	c.mu.Lock()
+	c.paused = false
+	c.mu.Unlock()
+	c.log.Info("Kafka consumer resumed by hand")
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Consumer) Status() ConsumerStatus {
+	reason := c.pauseReason()
+	return ConsumerStatus{Paused: reason != "", Reason: reason}
+}
+
+func (c *Consumer) pauseReason() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.paused {
+		return PauseManual
+	}
+	for _, cond := range c.conditions {
+		if cond.active() {
+			return cond.reason
+		}
+	}
+	return ""
+}
+
+// Run stores the orders from the materials directory, then consumes until ctx
+// is cancelled.
+func (c *Consumer) Run(ctx context.Context) {
+	defer c.reader.Close()
+	defer c.deadLetters.Close()
+
+	c.log.Info("Kafka consumer initialized")
+	c.loadMaterials(ctx)
+
+	// waitResumed blocks while the consumer is paused, logging when it
+	// pauses and resumes. It reports false once ctx is done.
+	lastReason := ""
+	waitResumed := func() bool {
+		for ctx.Err() == nil {
+			reason := c.pauseReason()
+			if reason != lastReason {
+				if reason != "" {
+					c.log.Warn(fmt.Sprintf("Kafka consumer paused: %s", reason), nil)
+				} else {
+					c.log.Info("Kafka consumer resumed")
+				}
+				lastReason = reason
+			}
+			if reason == "" {
+				return true
+			}
+			c.sleep(ctx, pausePollInterval)
+		}
+		return false
+	}
+
+	backoff := minReadBackoff
+	for waitResumed() {
+
+		if c.limiter != nil {
+			if err := c.limiter.Wait(ctx); err != nil {
+				break
+			}
+		}
+
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			c.log.Error(fmt.Sprintf("Error reading message from Kafka, retrying in %s", backoff), err)
+			c.sleep(ctx, backoff)
+			backoff = min(2*backoff, maxReadBackoff)
+			continue
+		}
+		backoff = minReadBackoff
+
+		// A message that failed because the DB is unavailable is retried
+		// until the DB is back, instead of being dead-lettered; the retries
+		// back off and wait while the consumer is paused. Its offset is
+		// committed only once it was handled or dead-lettered, so a message
+		// in flight when the consumer stops is read again.
+		retry := minRetryBackoff
+		for processMessage(ctx, c.db, c.log, c.cacheClient, c.cacheOpts, c.deadLetters, msg) != nil {
+			c.sleep(ctx, retry)
+			retry = min(2*retry, maxRetryBackoff)
+			if !waitResumed() {
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if err := c.reader.CommitMessages(ctx, msg); err != nil {
+			// The message will be redelivered and then skipped as a duplicate.
+			c.log.Error(fmt.Sprintf("Error committing offset of message %s", messagePosition(msg)), err)
+		}
+	}
+
+	c.log.Info("Kafka consumer stopped")
+}
+
+// sleep waits for d, until ctx is done or until Resume is called.
+func (c *Consumer) sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-c.wake:
+	case <-timer.C:
+	}
+}
+
+func (c *Consumer) loadMaterials(ctx context.Context) {
+	orders := unmarshal.ReadOrdersFromDirectory(c.log, "../.././materials")
+
+	for _, order := range orders {
+		err := validation.Default.Validate(&order)
+		if err != nil {
+			c.log.Error(fmt.Sprintf("Validation failed for order from directory: %v", order.OrderUid), err)
+			continue
+		}
+
+		err = storeOrder(ctx, c.db, c.log, c.cacheClient, c.cacheOpts, models.OrderEvent{EventType: models.EventOrderCreated}, &order)
+		if err != nil {
+			continue
+		}
+
+		c.log.Info(fmt.Sprintf("Processed order from directory: %v", order))
+	}
+}
@@ -0,0 +1,140 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Headers added to dead-lettered messages on top of the original ones.
+const (
+	HeaderDLQReason     = "dlq-reason"
+	HeaderDLQError      = "dlq-error"
+	HeaderDLQViolations = "dlq-violations"
+	HeaderDLQTopic      = "dlq-original-topic"
+	HeaderDLQPartition  = "dlq-original-partition"
+	HeaderDLQOffset     = "dlq-original-offset"
+)
+
+// Reason is why a message was dead-lettered.
+type Reason string
+
+const (
+	// ReasonUndecodable means the message is neither an envelope nor a bare
+	// order, or its payload doesn't match its event type.
+	ReasonUndecodable Reason = "undecodable"
+	// ReasonInvalid means the order or change broke validation rules; the
+	// dlq-violations header lists them.
+	ReasonInvalid Reason = "invalid"
+	// ReasonRejected means the change targets a missing or cancelled order or item.
+	ReasonRejected Reason = "rejected"
+	// ReasonFailed covers any other processing error.
+	ReasonFailed Reason = "failed"
+)
+
+// ReasonOf classifies a processing error.
+func ReasonOf(err error) Reason {
+	switch {
+	case errors.As(err, new(*PayloadError)):
+		return ReasonUndecodable
+	case validation.Violations(err) != nil:
+		return ReasonInvalid
+	case errors.Is(err, postgres.ErrOrderNotFound),
+		errors.Is(err, postgres.ErrItemNotFound),
+		errors.Is(err, postgres.ErrOrderCancelled):
+		return ReasonRejected
+	default:
+		return ReasonFailed
+	}
+}
+
+// PayloadError is returned for an event whose payload can't be decoded.
+type PayloadError struct {
+	// What the payload should hold, such as "order".
+	What string
+	Err  error
+}
+
+func (e *PayloadError) Error() string {
+	return fmt.Sprintf("error unmarshalling %s: %v", e.What, e.Err)
+}
+
+func (e *PayloadError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable tells whether handling a message failed for a reason that may
+// go away, such as the DB being unavailable, rather than because of the
+// message itself: it doesn't decode, or the change is invalid, rejected or a
+// duplicate. Retryable messages are retried instead of dead-lettered.
+func Retryable(err error) bool {
+	return postgres.IsFailure(err) && !errors.As(err, new(*PayloadError))
+}
+
+// DeadLetterWriter copies rejected messages to the dead-letter topic.
+type DeadLetterWriter struct {
+	writer *kafka.Writer
+	log    logger.Logger
+}
+
+func NewDeadLetterWriter(cfg config.AppConfig, log logger.Logger) *DeadLetterWriter {
+	return &DeadLetterWriter{
+		writer: &kafka.Writer{
+			Addr:     kafka.TCP(cfg.Kafka.Broker),
+			Topic:    cfg.Kafka.DeadLetterTopic,
+			Balancer: &kafka.Hash{},
+		},
+		log: log,
+	}
+}
+
+// Send publishes msg unchanged, with headers telling where it came from and why it
+// was rejected.
+func (d *DeadLetterWriter) Send(ctx context.Context, msg kafka.Message, reason Reason, cause error) error {
+	dead := kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: append(DeadLetterHeaders(msg, reason, cause), msg.Headers...),
+	}
+
+	err := d.writer.WriteMessages(ctx, dead)
+	if err != nil {
+		d.log.Error(fmt.Sprintf("Error dead-lettering message %s", messagePosition(msg)), err)
+		return err
+	}
+
+	d.log.Warn(fmt.Sprintf("Dead-lettered message %s: %s", messagePosition(msg), reason), cause)
+	return nil
+}
+
+func (d *DeadLetterWriter) Close() error {
+	return d.writer.Close()
+}
+
+// DeadLetterHeaders returns the dlq-* headers for msg rejected because of cause.
+func DeadLetterHeaders(msg kafka.Message, reason Reason, cause error) []kafka.Header {
+	headers := []kafka.Header{
+		{Key: HeaderDLQReason, Value: []byte(reason)},
+		{Key: HeaderDLQError, Value: []byte(cause.Error())},
+		{Key: HeaderDLQTopic, Value: []byte(msg.Topic)},
+		{Key: HeaderDLQPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+		{Key: HeaderDLQOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+	}
+
+	if violations := validation.Violations(cause); violations != nil {
+		value, err := json.Marshal(violations)
+		if err == nil {
+			headers = append(headers, kafka.Header{Key: HeaderDLQViolations, Value: value})
+		}
+	}
+
+	return headers
+}
@@ -0,0 +1,196 @@
+package kafka
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"wb-kafka-service/internal/models"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type EventType string
+
+const (
+	// EventOrderCreated and EventOrderUpdated carry a full models.Order.
+	EventOrderCreated EventType = models.EventOrderCreated
+	EventOrderUpdated EventType = models.EventOrderUpdated
+	// EventOrderCancelled carries a models.Cancellation.
+	EventOrderCancelled EventType = models.EventOrderCancelled
+	// EventOrderItemStatusChanged carries a models.ItemStatusChange.
+	EventOrderItemStatusChanged EventType = models.EventOrderItemStatusChanged
+	// EventOrderDeliveryChanged carries a models.DeliveryChange.
+	EventOrderDeliveryChanged EventType = models.EventOrderDeliveryChanged
+)
+
+// EnvelopeSchemaVersion is the version of the Envelope layout written by this
+// build. Legacy messages that carry a bare order are reported as version 0.
+const EnvelopeSchemaVersion = 1
+
+// Kafka header names set on every enveloped message, so consumers and tools
+// can route or filter without parsing the value.
+const (
+	HeaderEventType      = "event-type"
+	HeaderSchemaVersion  = "schema-version"
+	HeaderProducerID     = "producer-id"
+	HeaderTimestamp      = "timestamp"
+	HeaderIdempotencyKey = "idempotency-key"
+)
+
+// Envelope wraps every order event published to Kafka.
+type Envelope struct {
+	EventType      EventType       `json:"event_type"`
+	SchemaVersion  int             `json:"schema_version"`
+	ProducerID     string          `json:"producer_id"`
+	Timestamp      time.Time       `json:"timestamp"`
+	IdempotencyKey string          `json:"idempotency_key"`
+	OrderUid       string          `json:"order_uid"`
+	Payload        json.RawMessage `json:"payload"`
+
+	// reapply is set by the replayer to apply the event even if the ledger
+	// has it, see models.OrderEvent.Reapply.
+	reapply bool
+}
+
+// NewEnvelope wraps payload in an envelope with a fresh idempotency key.
+func NewEnvelope(eventType EventType, producerID, orderUid string, payload any) (Envelope, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		EventType:      eventType,
+		SchemaVersion:  EnvelopeSchemaVersion,
+		ProducerID:     producerID,
+		Timestamp:      time.Now().UTC(),
+		IdempotencyKey: key,
+		OrderUid:       orderUid,
+		Payload:        data,
+	}, nil
+}
+
+// NewOrderEvent wraps a full order, as carried by order.created and order.updated.
+func NewOrderEvent(eventType EventType, producerID string, order *models.Order) (Envelope, error) {
+	return NewEnvelope(eventType, producerID, order.OrderUid, order)
+}
+
+// Order decodes the payload of an event that carries a full order.
+func (e Envelope) Order() (models.Order, error) {
+	var order models.Order
+	err := json.Unmarshal(e.Payload, &order)
+	return order, err
+}
+
+// Decode unmarshals the payload into v, which must match the event type.
+func (e Envelope) Decode(v any) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// OrderEvent is the order_events history entry for this envelope.
+func (e Envelope) OrderEvent() models.OrderEvent {
+	return models.OrderEvent{
+		EventType:      string(e.EventType),
+		IdempotencyKey: e.IdempotencyKey,
+		Payload:        e.Payload,
+		Reapply:        e.reapply,
+	}
+}
+
+// EncodeMessage turns the envelope into a Kafka message keyed by order_uid,
+// so all events of one order land on the same partition in order.
+func EncodeMessage(env Envelope) (kafka.Message, error) {
+	value, err := json.Marshal(env)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+
+	return kafka.Message{
+		Key:   []byte(env.OrderUid),
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: HeaderEventType, Value: []byte(env.EventType)},
+			{Key: HeaderSchemaVersion, Value: []byte(strconv.Itoa(env.SchemaVersion))},
+			{Key: HeaderProducerID, Value: []byte(env.ProducerID)},
+			{Key: HeaderTimestamp, Value: []byte(env.Timestamp.Format(time.RFC3339Nano))},
+			{Key: HeaderIdempotencyKey, Value: []byte(env.IdempotencyKey)},
+		},
+	}, nil
+}
+
+// DecodeMessage reads an enveloped message or, for messages written before
+// envelopes existed, wraps the bare order JSON as an order.created event
+// whose idempotency key is the message position.
+func DecodeMessage(msg kafka.Message) (Envelope, error) {
+	if headerValue(msg, HeaderSchemaVersion) != "" || looksEnveloped(msg.Value) {
+		var env Envelope
+		if err := json.Unmarshal(msg.Value, &env); err != nil {
+			return Envelope{}, fmt.Errorf("error unmarshalling envelope: %w", err)
+		}
+		if env.SchemaVersion > EnvelopeSchemaVersion {
+			return Envelope{}, fmt.Errorf("unsupported envelope schema version %d", env.SchemaVersion)
+		}
+		if env.EventType == "" {
+			return Envelope{}, errors.New("envelope has no event type")
+		}
+		if env.IdempotencyKey == "" {
+			env.IdempotencyKey = messagePosition(msg)
+		}
+		return env, nil
+	}
+
+	var order struct {
+		OrderUid string `json:"order_uid"`
+	}
+	if err := json.Unmarshal(msg.Value, &order); err != nil {
+		return Envelope{}, fmt.Errorf("error unmarshalling order: %w", err)
+	}
+
+	return Envelope{
+		EventType:      EventOrderCreated,
+		Timestamp:      msg.Time,
+		IdempotencyKey: messagePosition(msg),
+		OrderUid:       order.OrderUid,
+		Payload:        msg.Value,
+	}, nil
+}
+
+// looksEnveloped catches enveloped messages whose headers were dropped, e.g.
+// by a tool that copied only the values between topics.
+func looksEnveloped(value []byte) bool {
+	var probe struct {
+		EventType string          `json:"event_type"`
+		Payload   json.RawMessage `json:"payload"`
+	}
+	return json.Unmarshal(value, &probe) == nil && probe.EventType != "" && probe.Payload != nil
+}
+
+func headerValue(msg kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func messagePosition(msg kafka.Message) string {
+	return fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+}
+
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
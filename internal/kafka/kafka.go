@@ -2,113 +2,201 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"wb-kafka-service/internal/cache"
 	"wb-kafka-service/internal/config"
 	"wb-kafka-service/pkg/logger"
 	"wb-kafka-service/internal/models"
+	"wb-kafka-service/internal/stream"
+	"wb-kafka-service/pkg/breaker"
 	"wb-kafka-service/pkg/postgres"
-	"wb-kafka-service/pkg/unmarshal"
 
+	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/segmentio/kafka-go"
-	"github.com/go-playground/validator/v10"
 )
 
-var Cache = make(map[int]models.Order)
+// Feed receives every order the consumer pipeline stores; it backs the live
+// order stream.
+var Feed = stream.NewHub()
 
-var validate = validator.New()
+// InitKafka runs a consumer until the process exits.
+func InitKafka(cfg config.AppConfig, db postgres.PostgresDB, log logger.Logger, cacheClient cache.MemCacheClient, cacheOpts cache.Options) {
+	NewConsumer(cfg, db, log, cacheClient, cacheOpts).Run(context.Background())
+}
 
-func InitKafka(cfg config.AppConfig, db postgres.PostgresDB, log logger.Logger, cacheClient cache.MemCacheClient) {
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  []string{cfg.Kafka.Broker},
-		Topic:    cfg.Kafka.Topic,
-		GroupID:  "order-group",
-		MinBytes: 10e3, // 10KB
-		MaxBytes: 10e6, // 10MB
-	})
-	defer reader.Close()
+// processMessage handles one message read from Kafka. Duplicates are skipped;
+// messages that can never be handled, as they don't decode or the change is
+// invalid or rejected, go to the dead-letter topic. Any other error, or a
+// failure to dead-letter, is returned, as the message should be retried once
+// the DB or Kafka is back; see Retryable. So is the error of a message that
+// was interrupted because ctx was cancelled.
+func processMessage(ctx context.Context, db postgres.PostgresDB, log logger.Logger, cacheClient cache.MemCacheClient, cacheOpts cache.Options, deadLetters *DeadLetterWriter, msg kafka.Message) error {
+	env, err := DecodeMessage(msg)
+	if err != nil {
+		log.Error("Error decoding message", err)
+		return deadLetter(ctx, deadLetters, msg, ReasonUndecodable, err)
+	}
 
-	log.Info("Kafka consumer initialized")
+	err = HandleEvent(ctx, db, log, cacheClient, cacheOpts, env)
+	if errors.Is(err, postgres.ErrDuplicateMessage) {
+		Stats.Duplicates.Add(1)
+		log.Info(fmt.Sprintf("Skipped duplicate %s event %s for order %s", env.EventType, env.IdempotencyKey, env.OrderUid))
+		return nil
+	}
+	if errors.Is(err, breaker.ErrOpen) || (err != nil && ctx.Err() != nil) {
+		return err
+	}
+	if Retryable(err) {
+		log.Error(fmt.Sprintf("Error handling %s event for order %s, retrying", env.EventType, env.OrderUid), err)
+		return err
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("Error handling %s event for order %s", env.EventType, env.OrderUid), err)
+		return deadLetter(ctx, deadLetters, msg, ReasonOf(err), err)
+	}
 
-	orders := unmarshal.ReadOrdersFromDirectory(log, "../.././materials")
+	Stats.Processed.Add(1)
+	log.Info(fmt.Sprintf("Processed %s event for order %s from Kafka", env.EventType, env.OrderUid))
+	return nil
+}
 
-	for _, order := range orders {
-		err := validate.Struct(order)
+// deadLetter sends msg to the dead-letter topic. It returns the error if that
+// failed, so the message is retried rather than lost.
+func deadLetter(ctx context.Context, deadLetters *DeadLetterWriter, msg kafka.Message, reason Reason, cause error) error {
+	if err := deadLetters.Send(ctx, msg, reason, cause); err != nil {
+		return err
+	}
+	Stats.Failed.Add(1)
+	Stats.DeadLettered.Add(1)
+	return nil
+}
+
+// HandleEvent applies one order event to the DB and keeps the cache in step.
+// It returns postgres.ErrDuplicateMessage, without changing anything, for an
+// event that was already processed.
+func HandleEvent(ctx context.Context, db postgres.PostgresDB, log logger.Logger, cacheClient cache.MemCacheClient, cacheOpts cache.Options, env Envelope) error {
+	var orderID int
+	var err error
+	switch env.EventType {
+	case EventOrderCreated:
+		order, err := env.Order()
 		if err != nil {
-			log.Error(fmt.Sprintf("Validation failed for order from directory: %v", order.OrderUid), err)
-			continue
+			return &PayloadError{What: "order", Err: err}
 		}
-		err = db.InsertOrderToDB(context.Background(), &order)
+		return storeOrder(ctx, db, log, cacheClient, cacheOpts, env.OrderEvent(), &order)
+	case EventOrderUpdated:
+		order, err := env.Order()
 		if err != nil {
-			log.Error(fmt.Sprintf("Error inserting order into DB: %v", order.ID), err)
-			continue
+			return &PayloadError{What: "order", Err: err}
 		}
-
-		err = cache.SaveToCache(log, cacheClient, &order)
+		orderID, err = db.UpdateOrder(ctx, env.OrderEvent(), &order)
 		if err != nil {
-			log.Error(fmt.Sprintf("Error saving order to cache: %v", order.ID), err)
-			continue
+			return err
 		}
-
-		Cache[order.ID] = order
-
-		log.Info(fmt.Sprintf("Processed order from directory: %v", order))
-	}
-
-	for {
-		msg, err := reader.ReadMessage(context.Background())
+	case EventOrderItemStatusChanged:
+		var change models.ItemStatusChange
+		if err := env.Decode(&change); err != nil {
+			return &PayloadError{What: "item status change", Err: err}
+		}
+		orderID, err = db.UpdateItemStatus(ctx, env.OrderEvent(), change)
 		if err != nil {
-			log.Error("Error reading message from Kafka", err)
-			continue
+			return err
 		}
-
-		var order models.Order
-		err = json.Unmarshal(msg.Value, &order)
+	case EventOrderDeliveryChanged:
+		var change models.DeliveryChange
+		if err := env.Decode(&change); err != nil {
+			return &PayloadError{What: "delivery change", Err: err}
+		}
+		orderID, err = db.UpdateDelivery(ctx, env.OrderEvent(), change)
 		if err != nil {
-			log.Error("Error unmarshalling order", err)
-			continue
+			return err
 		}
-
-		err = db.InsertOrderToDB(context.Background(), &order)
+	case EventOrderCancelled:
+		var cancellation models.Cancellation
+		if err := env.Decode(&cancellation); err != nil {
+			return &PayloadError{What: "cancellation", Err: err}
+		}
+		orderID, err = db.CancelOrder(ctx, env.OrderEvent(), cancellation)
 		if err != nil {
-			log.Error(fmt.Sprintf("Error inserting order into DB: %v", order.ID), err)
-			continue
+			return err
 		}
+	default:
+		log.Warn(fmt.Sprintf("Skipping unsupported event %s for order %s", env.EventType, env.OrderUid), nil)
+		return nil
+	}
 
-		err = cache.SaveToCache(log, cacheClient, &order)
-		if err != nil {
-			log.Error(fmt.Sprintf("Error saving order to cache: %v", order.ID), err)
-			continue
+	return refreshCachedOrder(ctx, db, log, cacheClient, cacheOpts, orderID)
+}
+
+// refreshCachedOrder drops the cached copy of a changed order and caches the
+// current state from the DB.
+func refreshCachedOrder(ctx context.Context, db postgres.PostgresDB, log logger.Logger, cacheClient cache.MemCacheClient, cacheOpts cache.Options, orderID int) error {
+	order, err := db.GetOrderFromDB(ctx, orderID)
+	if err != nil {
+		// Without the current state we can't rewrite the entry, but readers
+		// must not keep seeing the old one.
+		if err := cacheClient.Delete(cache.OrderKey(orderID)); err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			log.Error(fmt.Sprintf("Error deleting cached order: %v", orderID), err)
 		}
+		return fmt.Errorf("error reloading order %d: %w", orderID, err)
+	}
 
-		Cache[order.ID] = order
+	err = cache.InvalidateOrder(log, cacheClient, order)
+	if err != nil {
+		log.Error(fmt.Sprintf("Error invalidating cached order: %v", order.ID), err)
+	}
 
-		log.Info(fmt.Sprintf("Processed order from Kafka: %v", order))
+	// The change is stored, so a cache error must not fail the event; old
+	// entries expire with their TTL.
+	err = cache.SaveToCache(log, cacheClient, cacheOpts, order)
+	if errors.Is(err, breaker.ErrOpen) {
+		log.Warn(fmt.Sprintf("Memcache unavailable, order %d not cached", orderID), err)
+		return nil
+	}
+	if err != nil {
+		log.Warn(fmt.Sprintf("Error saving order %d to cache", orderID), err)
+		return nil
 	}
-}
 
-func ProduceOrder(cfg config.AppConfig, order *models.Order, log logger.Logger) error {
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: []string{cfg.Kafka.Broker},
-		Topic:   cfg.Kafka.Topic,
-	})
-	defer writer.Close()
+	return nil
+}
 
-	orderData, err := json.Marshal(order)
+// storeOrder writes order to the DB, publishes it to the live feed and
+// refreshes its cache entries.
+func storeOrder(ctx context.Context, db postgres.PostgresDB, log logger.Logger, cacheClient cache.MemCacheClient, cacheOpts cache.Options, event models.OrderEvent, order *models.Order) error {
+	created, err := db.CreateOrder(ctx, event, order)
+	if errors.Is(err, postgres.ErrDuplicateMessage) {
+		return err
+	}
 	if err != nil {
-		log.Error("Error marshalling order", err)
+		log.Error(fmt.Sprintf("Error inserting order into DB: %v", order.ID), err)
 		return err
 	}
 
-	err = writer.WriteMessages(context.Background(), kafka.Message{
-		Value: orderData,
-	})
+	// An order with this order_uid was already stored and kept its data, so
+	// the incoming one is neither announced nor cached.
+	if created {
+		Feed.Publish(order)
+	}
+
+	// The order is stored, so a cache error must not fail the event.
+	err = cache.InvalidateOrder(log, cacheClient, order)
 	if err != nil {
-		log.Error("Error writing message to Kafka", err)
-		return err
+		log.Warn(fmt.Sprintf("Error invalidating cached order %d", order.ID), err)
+	}
+	if !created {
+		return nil
+	}
+
+	err = cache.SaveToCache(log, cacheClient, cacheOpts, order)
+	if errors.Is(err, breaker.ErrOpen) {
+		log.Warn(fmt.Sprintf("Memcache unavailable, order %d not cached", order.ID), err)
+		return nil
+	}
+	if err != nil {
+		log.Warn(fmt.Sprintf("Error saving order %d to cache", order.ID), err)
 	}
 
-	log.Info(fmt.Sprintf("Produced order: %v", order))
 	return nil
 }
@@ -0,0 +1,108 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+)
+
+// EventPublisher publishes envelopes and reports whether the broker accepted them.
+type EventPublisher interface {
+	Publish(ctx context.Context, env Envelope) error
+}
+
+// NewOutboxProducer creates a producer for the outbox topic. It is always
+// synchronous, because a row may only be marked sent once Kafka acknowledged it.
+func NewOutboxProducer(cfg config.AppConfig, log logger.Logger) (*OrderProducer, error) {
+	cfg.Kafka.Producer.Async = false
+	return NewTopicProducer(cfg, cfg.Kafka.Outbox.Topic, log)
+}
+
+// OutboxRelay publishes rows of the outbox table in id order and marks them
+// sent. Delivery is at least once: a row published just before a crash is sent
+// again, with the same idempotency key.
+type OutboxRelay struct {
+	db         postgres.PostgresDB
+	publisher  EventPublisher
+	producerID string
+	log        logger.Logger
+
+	pollInterval time.Duration
+	batchSize    int
+	maxBackoff   time.Duration
+}
+
+func NewOutboxRelay(cfg config.AppConfig, db postgres.PostgresDB, publisher EventPublisher, log logger.Logger) *OutboxRelay {
+	return &OutboxRelay{
+		db:           db,
+		publisher:    publisher,
+		producerID:   cfg.Kafka.ProducerID,
+		log:          log,
+		pollInterval: cfg.Kafka.Outbox.PollInterval,
+		batchSize:    cfg.Kafka.Outbox.BatchSize,
+		maxBackoff:   cfg.Kafka.Outbox.MaxBackoff,
+	}
+}
+
+// Run relays until ctx is cancelled. A full batch is followed by the next one
+// right away; after a failure the wait doubles up to the configured maximum.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	r.log.Info("Outbox relay started")
+
+	backoff := r.pollInterval
+	for {
+		wait := r.pollInterval
+		sent, err := r.RelayBatch(ctx)
+		switch {
+		case err != nil:
+			backoff = min(2*backoff, r.maxBackoff)
+			wait = backoff
+		case sent == r.batchSize:
+			backoff = r.pollInterval
+			wait = 0
+		default:
+			backoff = r.pollInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			r.log.Info("Outbox relay stopped")
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RelayBatch publishes one batch of pending rows and returns how many were sent.
+// It stops at the first failure, so rows of one order are never reordered.
+// Relays on several instances share the table: each claims the rows it
+// publishes, see postgres.PostgresDB.RelayOutbox.
+func (r *OutboxRelay) RelayBatch(ctx context.Context) (int, error) {
+	return r.db.RelayOutbox(ctx, r.batchSize, func(messages []models.OutboxMessage) (int, error) {
+		for i, msg := range messages {
+			if err := r.publisher.Publish(ctx, r.envelope(msg)); err != nil {
+				r.log.Error(fmt.Sprintf("Error relaying outbox message %d (attempt %d)", msg.ID, msg.Attempts+1), err)
+				return i, err
+			}
+		}
+		return len(messages), nil
+	})
+}
+
+// envelope wraps an outbox row. The idempotency key is derived from the row id,
+// so consumers can drop the duplicates at-least-once delivery produces.
+func (r *OutboxRelay) envelope(msg models.OutboxMessage) Envelope {
+	return Envelope{
+		EventType:      EventType(msg.EventType),
+		SchemaVersion:  EnvelopeSchemaVersion,
+		ProducerID:     r.producerID,
+		Timestamp:      msg.CreatedAt.UTC(),
+		IdempotencyKey: fmt.Sprintf("outbox/%d", msg.ID),
+		OrderUid:       msg.OrderUid,
+		Payload:        msg.Payload,
+	}
+}
@@ -0,0 +1,255 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/breaker"
+	"wb-kafka-service/pkg/logger"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// DeliveryReport tells the outcome of one published event.
+type DeliveryReport struct {
+	OrderUid       string
+	IdempotencyKey string
+	EventType      EventType
+	Partition      int
+	Offset         int64
+	Err            error
+}
+
+// OrderProducer publishes order events over one long-lived Kafka writer.
+// It is safe for concurrent use. Close must be called to flush pending events.
+type OrderProducer struct {
+	writer     *kafka.Writer
+	producerID string
+	log        logger.Logger
+	// breaker is nil unless set with UseBreaker.
+	breaker *breaker.Breaker
+
+	mu         sync.RWMutex
+	onDelivery func(DeliveryReport)
+}
+
+// NewOrderProducer creates a producer configured by the kafka.producer config
+// section. In async mode Publish only enqueues events; their outcome is
+// reported to the callback set with OnDelivery.
+func NewOrderProducer(cfg config.AppConfig, log logger.Logger) (*OrderProducer, error) {
+	return NewTopicProducer(cfg, cfg.Kafka.Topic, log)
+}
+
+// NewTopicProducer is NewOrderProducer publishing to topic instead of kafka.topic.
+func NewTopicProducer(cfg config.AppConfig, topic string, log logger.Logger) (*OrderProducer, error) {
+	pc := cfg.Kafka.Producer
+
+	compression, err := ParseCompression(pc.Compression)
+	if err != nil {
+		return nil, err
+	}
+	acks, err := ParseRequiredAcks(pc.RequiredAcks)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &OrderProducer{producerID: cfg.Kafka.ProducerID, log: log}
+	p.writer = &kafka.Writer{
+		Addr:  kafka.TCP(cfg.Kafka.Broker),
+		Topic: topic,
+		// Messages are keyed by order_uid; hashing keeps each order on one partition.
+		Balancer:     &kafka.Hash{},
+		BatchSize:    pc.BatchSize,
+		BatchBytes:   pc.BatchBytes,
+		BatchTimeout: pc.BatchTimeout,
+		Compression:  compression,
+		RequiredAcks: acks,
+		MaxAttempts:  pc.MaxAttempts,
+		Async:        pc.Async,
+		Completion:   p.complete,
+	}
+
+	return p, nil
+}
+
+// OnDelivery sets the callback invoked once for every event published in async
+// mode. It runs on the writer's goroutines and must not block. In sync mode the
+// outcome is the error returned by Publish and the callback is not used.
+func (p *OrderProducer) OnDelivery(callback func(DeliveryReport)) {
+	p.mu.Lock()
+	p.onDelivery = callback
+	p.mu.Unlock()
+}
+
+// UseBreaker makes Publish fail fast with breaker.ErrOpen while Kafka is
+// down. It must be called before the first Publish. In async mode only errors
+// returned by Publish itself are counted, not failed deliveries.
+func (p *OrderProducer) UseBreaker(b *breaker.Breaker) {
+	p.breaker = b
+}
+
+// UseTransport sends the writer's requests over t instead of connecting to
+// the broker directly, e.g. to go through a proxy or a fake broker in tests.
+// It must be called before the first Publish.
+func (p *OrderProducer) UseTransport(t kafka.RoundTripper) {
+	p.writer.Transport = t
+}
+
+// PublishOrder wraps order in an envelope of the given type and publishes it.
+func (p *OrderProducer) PublishOrder(ctx context.Context, eventType EventType, order *models.Order) error {
+	env, err := NewOrderEvent(eventType, p.producerID, order)
+	if err != nil {
+		p.log.Error("Error marshalling order", err)
+		return err
+	}
+	return p.Publish(ctx, env)
+}
+
+// PublishOrders publishes a batch of orders as events of the given type in one
+// write. In sync mode, if only some events failed, the first result has the
+// error of each order, nil for those that were published; the second result
+// is set if the whole batch failed. In async mode outcomes go to OnDelivery.
+func (p *OrderProducer) PublishOrders(ctx context.Context, eventType EventType, orders []*models.Order) ([]error, error) {
+	msgs := make([]kafka.Message, 0, len(orders))
+	for _, order := range orders {
+		env, err := NewOrderEvent(eventType, p.producerID, order)
+		if err != nil {
+			p.log.Error(fmt.Sprintf("Error marshalling order %s", order.OrderUid), err)
+			return nil, err
+		}
+		msg, err := EncodeMessage(env)
+		if err != nil {
+			p.log.Error("Error marshalling envelope", err)
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	write := func() error { return p.writer.WriteMessages(ctx, msgs...) }
+	var err error
+	if p.breaker != nil {
+		err = p.breaker.Do(write)
+	} else {
+		err = write()
+	}
+
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) && len(writeErrs) == len(orders) {
+		p.log.Error(fmt.Sprintf("Error writing %d of %d %s events to Kafka", writeErrs.Count(), len(orders), eventType), err)
+		return writeErrs, nil
+	}
+	if err != nil {
+		p.log.Error(fmt.Sprintf("Error writing %d %s events to Kafka", len(orders), eventType), err)
+		return nil, err
+	}
+
+	return make([]error, len(orders)), nil
+}
+
+// PublishChange publishes a lifecycle change such as models.ItemStatusChange.
+func (p *OrderProducer) PublishChange(ctx context.Context, eventType EventType, orderUid string, change any) error {
+	env, err := NewEnvelope(eventType, p.producerID, orderUid, change)
+	if err != nil {
+		p.log.Error("Error marshalling order change", err)
+		return err
+	}
+	return p.Publish(ctx, env)
+}
+
+// Publish sends env. In sync mode it returns once the broker acknowledged the
+// write; in async mode it returns once the event is queued.
+func (p *OrderProducer) Publish(ctx context.Context, env Envelope) error {
+	msg, err := EncodeMessage(env)
+	if err != nil {
+		p.log.Error("Error marshalling envelope", err)
+		return err
+	}
+
+	if p.breaker != nil {
+		err = p.breaker.Do(func() error { return p.writer.WriteMessages(ctx, msg) })
+	} else {
+		err = p.writer.WriteMessages(ctx, msg)
+	}
+	if err != nil {
+		p.log.Error(fmt.Sprintf("Error writing %s event for order %s to Kafka", env.EventType, env.OrderUid), err)
+		return err
+	}
+
+	return nil
+}
+
+// Close flushes pending events and closes the writer.
+func (p *OrderProducer) Close() error {
+	err := p.writer.Close()
+	if err != nil {
+		p.log.Error("Error closing Kafka producer", err)
+		return err
+	}
+
+	p.log.Info("Kafka producer closed")
+	return nil
+}
+
+// Async reports whether Publish only enqueues events.
+func (p *OrderProducer) Async() bool {
+	return p.writer.Async
+}
+
+func (p *OrderProducer) complete(messages []kafka.Message, err error) {
+	if !p.writer.Async {
+		return
+	}
+
+	p.mu.RLock()
+	callback := p.onDelivery
+	p.mu.RUnlock()
+	if callback == nil {
+		return
+	}
+
+	for _, msg := range messages {
+		callback(DeliveryReport{
+			OrderUid:       string(msg.Key),
+			IdempotencyKey: headerValue(msg, HeaderIdempotencyKey),
+			EventType:      EventType(headerValue(msg, HeaderEventType)),
+			Partition:      msg.Partition,
+			Offset:         msg.Offset,
+			Err:            err,
+		})
+	}
+}
+
+// ParseCompression parses the kafka.producer.compression setting.
+func ParseCompression(name string) (kafka.Compression, error) {
+	switch name {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	default:
+		return 0, fmt.Errorf("unknown Kafka compression %q", name)
+	}
+}
+
+// ParseRequiredAcks parses the kafka.producer.required_acks setting.
+func ParseRequiredAcks(name string) (kafka.RequiredAcks, error) {
+	switch name {
+	case "", "all":
+		return kafka.RequireAll, nil
+	case "one":
+		return kafka.RequireOne, nil
+	case "none":
+		return kafka.RequireNone, nil
+	default:
+		return 0, fmt.Errorf("unknown Kafka required_acks %q", name)
+	}
+}
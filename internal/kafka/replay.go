@@ -0,0 +1,399 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// replayIdleTimeout is how long a partition may stay silent before its replay ends.
+const replayIdleTimeout = 10 * time.Second
+
+// ReplayOptions choose what Replay re-reads and what it does with it.
+type ReplayOptions struct {
+	// Partitions to replay; all partitions of the topic when empty.
+	Partitions []int
+	// Offsets gives the start offset per partition. Partitions missing from it
+	// start at Since, or at the first retained offset if Since is zero.
+	Offsets map[int]int64
+	Since   time.Time
+	// DryRun only decodes and validates messages.
+	DryRun bool
+	// IgnoreLedger reapplies events that the processed-messages ledger already
+	// has, e.g. after a bug corrupted the data they produced. They were
+	// recorded and published the first time, so they are not added to the
+	// order history or the outbox again.
+	IgnoreLedger bool
+}
+
+// PartitionProgress is how far Replay got in one partition. End is the high
+// watermark when the replay started; messages produced later are not replayed.
+type PartitionProgress struct {
+	Partition int
+	Start     int64
+	Next      int64
+	End       int64
+}
+
+// ReplayProgress counts replayed messages by outcome.
+type ReplayProgress struct {
+	Partitions []PartitionProgress
+	Processed  int
+	Duplicates int
+	Invalid    int
+	Failed     int
+	Errors     []string
+}
+
+// Replayer re-reads the orders topic outside the consumer group and runs the
+// messages through the consumer's pipeline.
+type Replayer struct {
+	cfg         config.AppConfig
+	db          postgres.PostgresDB
+	log         logger.Logger
+	cacheClient cache.MemCacheClient
+	cacheOpts   cache.Options
+	opts        ReplayOptions
+
+	mu       sync.Mutex
+	progress ReplayProgress
+}
+
+// NewReplayer creates a replayer. db and cacheClient are not used in dry-run mode.
+func NewReplayer(cfg config.AppConfig, db postgres.PostgresDB, log logger.Logger, cacheClient cache.MemCacheClient, cacheOpts cache.Options, opts ReplayOptions) *Replayer {
+	return &Replayer{cfg: cfg, db: db, log: log, cacheClient: cacheClient, cacheOpts: cacheOpts, opts: opts}
+}
+
+// Progress returns a snapshot of the progress so far.
+func (r *Replayer) Progress() ReplayProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p := r.progress
+	p.Partitions = append([]PartitionProgress(nil), r.progress.Partitions...)
+	p.Errors = append([]string(nil), r.progress.Errors...)
+	return p
+}
+
+// PartitionReader reads the messages of one partition from its start offset.
+// A *kafka.Reader is one.
+type PartitionReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	Close() error
+}
+
+// partitionMessage is a message read by Replay from partitions[index].
+type partitionMessage struct {
+	index int
+	msg   kafka.Message
+}
+
+// Run replays every chosen partition up to its high watermark.
+func (r *Replayer) Run(ctx context.Context) error {
+	partitions, err := r.plan(ctx)
+	if err != nil {
+		return err
+	}
+	return r.Replay(ctx, partitions, r.openPartition)
+}
+
+// Replay replays partitions with the readers open returns. The partitions
+// are read concurrently, but their messages are applied one at a time on the
+// calling goroutine, as the pipeline is not safe for concurrent use.
+func (r *Replayer) Replay(ctx context.Context, partitions []PartitionProgress, open func(PartitionProgress) (PartitionReader, error)) error {
+	r.mu.Lock()
+	r.progress.Partitions = append([]PartitionProgress(nil), partitions...)
+	r.mu.Unlock()
+
+	msgs := make(chan partitionMessage)
+	var wg sync.WaitGroup
+	errs := make([]error, len(partitions))
+	for i, p := range partitions {
+		if p.Start >= p.End {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, p PartitionProgress) {
+			defer wg.Done()
+			errs[i] = r.readPartition(ctx, i, p, open, msgs)
+		}(i, p)
+	}
+	go func() {
+		wg.Wait()
+		close(msgs)
+	}()
+
+	for m := range msgs {
+		if !r.replayMessage(ctx, m.msg) {
+			continue
+		}
+
+		r.mu.Lock()
+		r.progress.Partitions[m.index].Next = m.msg.Offset + 1
+		r.mu.Unlock()
+	}
+
+	return errors.Join(errs...)
+}
+
+// plan resolves the start offset and the high watermark of every partition.
+func (r *Replayer) plan(ctx context.Context) ([]PartitionProgress, error) {
+	partitions := r.opts.Partitions
+	if len(partitions) == 0 {
+		conn, err := kafka.DialContext(ctx, "tcp", r.cfg.Kafka.Broker)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to Kafka: %w", err)
+		}
+		defer conn.Close()
+
+		found, err := conn.ReadPartitions(r.cfg.Kafka.Topic)
+		if err != nil {
+			return nil, fmt.Errorf("error reading partitions of %s: %w", r.cfg.Kafka.Topic, err)
+		}
+		for _, p := range found {
+			partitions = append(partitions, p.ID)
+		}
+		sort.Ints(partitions)
+	}
+
+	var plan []PartitionProgress
+	for _, partition := range partitions {
+		conn, err := kafka.DialLeader(ctx, "tcp", r.cfg.Kafka.Broker, r.cfg.Kafka.Topic, partition)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to the leader of partition %d: %w", partition, err)
+		}
+
+		first, end, err := conn.ReadOffsets()
+		start := first
+		if err == nil {
+			if offset, ok := r.opts.Offsets[partition]; ok {
+				start = max(offset, first)
+			} else if !r.opts.Since.IsZero() {
+				start, err = conn.ReadOffset(r.opts.Since)
+			}
+		}
+		conn.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading offsets of partition %d: %w", partition, err)
+		}
+
+		plan = append(plan, PartitionProgress{Partition: partition, Start: start, Next: start, End: end})
+	}
+
+	return plan, nil
+}
+
+// openPartition returns a reader of p from its start offset.
+func (r *Replayer) openPartition(p PartitionProgress) (PartitionReader, error) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   []string{r.cfg.Kafka.Broker},
+		Topic:     r.cfg.Kafka.Topic,
+		Partition: p.Partition,
+		MinBytes:  1,
+		MaxBytes:  10e6, // 10MB
+	})
+
+	err := reader.SetOffset(p.Start)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("error seeking partition %d to %d: %w", p.Partition, p.Start, err)
+	}
+	return reader, nil
+}
+
+// readPartition sends the messages of partitions[index] to msgs up to its end.
+func (r *Replayer) readPartition(ctx context.Context, index int, p PartitionProgress, open func(PartitionProgress) (PartitionReader, error), msgs chan<- partitionMessage) error {
+	reader, err := open(p)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for {
+		// Offsets up to End may be missing, e.g. after compaction, so a
+		// partition that stays silent for replayIdleTimeout is done.
+		fetchCtx, cancel := context.WithTimeout(ctx, replayIdleTimeout)
+		msg, err := reader.FetchMessage(fetchCtx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			r.log.Warn(fmt.Sprintf("No messages in partition %d after offset %d, stopping before %d", p.Partition, r.Progress().Partitions[index].Next, p.End), nil)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading partition %d: %w", p.Partition, err)
+		}
+
+		select {
+		case msgs <- partitionMessage{index: index, msg: msg}:
+		case <-ctx.Done():
+			return fmt.Errorf("error reading partition %d: %w", p.Partition, ctx.Err())
+		}
+
+		if msg.Offset+1 >= p.End {
+			return nil
+		}
+	}
+}
+
+// replayMessage applies msg and counts the outcome. It reports false if msg
+// was interrupted because ctx was cancelled, so it is neither counted nor
+// treated as replayed.
+func (r *Replayer) replayMessage(ctx context.Context, msg kafka.Message) bool {
+	env, err := DecodeMessage(msg)
+	if err == nil {
+		env.reapply = r.opts.IgnoreLedger
+		if r.opts.DryRun {
+			err = CheckEvent(env)
+		} else {
+			err = HandleEvent(ctx, r.db, r.log, r.cacheClient, r.cacheOpts, env)
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case err == nil:
+		r.progress.Processed++
+	case errors.Is(err, postgres.ErrDuplicateMessage):
+		r.progress.Duplicates++
+	case validation.Violations(err) != nil:
+		r.progress.Invalid++
+		r.progress.Errors = append(r.progress.Errors, fmt.Sprintf("%s: %v", messagePosition(msg), err))
+	default:
+		r.progress.Failed++
+		r.progress.Errors = append(r.progress.Errors, fmt.Sprintf("%s: %v", messagePosition(msg), err))
+	}
+	return true
+}
+
+// CheckEvent decodes and validates the payload of env without applying it.
+func CheckEvent(env Envelope) error {
+	switch env.EventType {
+	case EventOrderCreated, EventOrderUpdated:
+		order, err := env.Order()
+		if err != nil {
+			return &PayloadError{What: "order", Err: err}
+		}
+		return validation.Default.Validate(&order)
+	case EventOrderItemStatusChanged:
+		var change models.ItemStatusChange
+		if err := env.Decode(&change); err != nil {
+			return &PayloadError{What: "item status change", Err: err}
+		}
+		return validation.Default.ValidateStruct(change)
+	case EventOrderDeliveryChanged:
+		var change models.DeliveryChange
+		if err := env.Decode(&change); err != nil {
+			return &PayloadError{What: "delivery change", Err: err}
+		}
+		return validation.Default.ValidateStruct(change)
+	case EventOrderCancelled:
+		var cancellation models.Cancellation
+		if err := env.Decode(&cancellation); err != nil {
+			return &PayloadError{What: "cancellation", Err: err}
+		}
+		return validation.Default.ValidateStruct(cancellation)
+	default:
+		return nil
+	}
+}
+
+// ParseReplayOptions parses the -partitions, -offset and -since flags of the
+// replay tool. offsets is either one offset for every partition in
+// partitions, or a list of partition=offset pairs; since is an RFC 3339 time.
+func ParseReplayOptions(partitions, offsets, since string) (ReplayOptions, error) {
+	var opts ReplayOptions
+	var err error
+	opts.Partitions, err = parsePartitions(partitions)
+	if err != nil {
+		return ReplayOptions{}, err
+	}
+	opts.Offsets, err = parseOffsets(offsets, opts.Partitions)
+	if err != nil {
+		return ReplayOptions{}, err
+	}
+	// Pairs without -partitions replay just the partitions they name, rather
+	// than every partition with the others read from the start.
+	if len(opts.Partitions) == 0 {
+		for p := range opts.Offsets {
+			opts.Partitions = append(opts.Partitions, p)
+		}
+		sort.Ints(opts.Partitions)
+	}
+	if since = strings.TrimSpace(since); since != "" {
+		opts.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			return ReplayOptions{}, fmt.Errorf("invalid time %q, want RFC 3339 such as 2024-01-02T15:04:05Z", since)
+		}
+	}
+	return opts, nil
+}
+
+func parsePartitions(list string) ([]int, error) {
+	var partitions []int
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		p, err := strconv.Atoi(s)
+		if err != nil || p < 0 {
+			return nil, fmt.Errorf("invalid partition %q", s)
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
+
+// parseOffsets parses either one offset, applied to every partition in
+// partitions, or a list of partition=offset pairs.
+func parseOffsets(list string, partitions []int) (map[int]int64, error) {
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return nil, nil
+	}
+
+	offsets := make(map[int]int64)
+	if !strings.Contains(list, "=") {
+		offset, err := strconv.ParseInt(list, 10, 64)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid offset %q", list)
+		}
+		if len(partitions) == 0 {
+			return nil, fmt.Errorf("a single -offset needs -partitions")
+		}
+		for _, p := range partitions {
+			offsets[p] = offset
+		}
+		return offsets, nil
+	}
+
+	for _, pair := range strings.Split(list, ",") {
+		partition, offset, found := strings.Cut(strings.TrimSpace(pair), "=")
+		p, err := strconv.Atoi(partition)
+		if err != nil || !found || p < 0 {
+			return nil, fmt.Errorf("invalid partition offset %q", pair)
+		}
+		o, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil || o < 0 {
+			return nil, fmt.Errorf("invalid partition offset %q", pair)
+		}
+		offsets[p] = o
+	}
+	return offsets, nil
+}
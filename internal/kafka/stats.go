@@ -0,0 +1,15 @@
+package kafka
+
+import "sync/atomic"
+
+// ConsumerStats counts what the consumer did with the messages it read.
+type ConsumerStats struct {
+	Processed atomic.Int64
+	// Duplicates are redelivered messages found in the processed-messages ledger.
+	Duplicates   atomic.Int64
+	Failed       atomic.Int64
+	DeadLettered atomic.Int64
+}
+
+// Stats is updated by InitKafka.
+var Stats ConsumerStats
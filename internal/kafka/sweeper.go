@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+)
+
+// sweepBatch is how many rows one DELETE removes at most, so a large backlog
+// doesn't hold locks for long.
+const sweepBatch = 1000
+
+// Sweeper deletes the rows of a table that grows with every message once they
+// are older than the retention.
+type Sweeper struct {
+	what      string
+	delete    func(ctx context.Context, before time.Time, limit int) (int64, error)
+	log       logger.Logger
+	retention time.Duration
+	interval  time.Duration
+}
+
+// NewLedgerSweeper sweeps the processed-messages ledger. A message redelivered
+// or replayed after its entry is gone is applied again, so the retention must
+// cover the topic's retention.
+func NewLedgerSweeper(cfg config.AppConfig, db postgres.PostgresDB, log logger.Logger) *Sweeper {
+	return &Sweeper{
+		what:      "processed-messages ledger entries",
+		delete:    db.DeleteProcessedMessages,
+		log:       log,
+		retention: cfg.Kafka.Consumer.LedgerRetention,
+		interval:  cfg.Kafka.Consumer.LedgerSweepInterval,
+	}
+}
+
+// NewOutboxSweeper sweeps outbox rows that were sent. Unsent rows are kept
+// however old they are.
+func NewOutboxSweeper(cfg config.AppConfig, db postgres.PostgresDB, log logger.Logger) *Sweeper {
+	return &Sweeper{
+		what:      "sent outbox messages",
+		delete:    db.DeleteSentOutbox,
+		log:       log,
+		retention: cfg.Kafka.Outbox.Retention,
+		interval:  cfg.Kafka.Outbox.SweepInterval,
+	}
+}
+
+// Run sweeps right away, then every interval until ctx is cancelled. It
+// returns at once if the retention is negative.
+func (s *Sweeper) Run(ctx context.Context) {
+	if s.retention < 0 {
+		return
+	}
+	s.log.Info(fmt.Sprintf("Sweeper of %s started, retention %s", s.what, s.retention))
+
+	for {
+		deleted, err := s.Sweep(ctx)
+		if err != nil {
+			s.log.Error(fmt.Sprintf("Error sweeping %s", s.what), err)
+		} else if deleted > 0 {
+			s.log.Info(fmt.Sprintf("Deleted %d expired %s", deleted, s.what))
+		}
+
+		select {
+		case <-ctx.Done():
+			s.log.Info(fmt.Sprintf("Sweeper of %s stopped", s.what))
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// Sweep deletes the rows older than the retention, in batches, and returns
+// how many it deleted.
+func (s *Sweeper) Sweep(ctx context.Context) (int64, error) {
+	before := time.Now().Add(-s.retention)
+	var total int64
+	for {
+		deleted, err := s.delete(ctx, before, sweepBatch)
+		total += deleted
+		if err != nil || deleted < sweepBatch {
+			return total, err
+		}
+	}
+}
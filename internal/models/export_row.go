@@ -0,0 +1,49 @@
+package models
+
+// ExportRow is one item of an order flattened with the order, its delivery
+// and its payment. An order without items is exported as one row with zero
+// item fields.
+type ExportRow struct {
+	OrderID         int    `json:"order_id"`
+	OrderUid        string `json:"order_uid"`
+	TrackNumber     string `json:"track_number"`
+	Entry           string `json:"entry"`
+	Locale          string `json:"locale"`
+	CustomerID      string `json:"customer_id"`
+	DeliveryService string `json:"delivery_service"`
+	Shardkey        string `json:"shardkey"`
+	SmID            int    `json:"sm_id"`
+	DateCreated     string `json:"date_created"`
+	OofShard        string `json:"oof_shard"`
+	Status          string `json:"status"`
+
+	DeliveryName    string `json:"delivery_name"`
+	DeliveryPhone   string `json:"delivery_phone"`
+	DeliveryZip     string `json:"delivery_zip"`
+	DeliveryCity    string `json:"delivery_city"`
+	DeliveryAddress string `json:"delivery_address"`
+	DeliveryRegion  string `json:"delivery_region"`
+	DeliveryEmail   string `json:"delivery_email"`
+
+	PaymentTransaction  string `json:"payment_transaction"`
+	PaymentRequestID    string `json:"payment_request_id"`
+	PaymentCurrency     string `json:"payment_currency"`
+	PaymentProvider     string `json:"payment_provider"`
+	PaymentAmount       int    `json:"payment_amount"`
+	PaymentDT           int64  `json:"payment_dt"`
+	PaymentBank         string `json:"payment_bank"`
+	PaymentDeliveryCost int    `json:"payment_delivery_cost"`
+	PaymentGoodsTotal   int    `json:"payment_goods_total"`
+	PaymentCustomFee    int    `json:"payment_custom_fee"`
+
+	ItemChrtID     int    `json:"item_chrt_id"`
+	ItemPrice      int    `json:"item_price"`
+	ItemRid        string `json:"item_rid"`
+	ItemName       string `json:"item_name"`
+	ItemSale       int    `json:"item_sale"`
+	ItemSize       string `json:"item_size"`
+	ItemTotalPrice int    `json:"item_total_price"`
+	ItemNmID       int    `json:"item_nm_id"`
+	ItemBrand      string `json:"item_brand"`
+	ItemStatus     int    `json:"item_status"`
+}
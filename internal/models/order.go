@@ -13,6 +13,7 @@ type Order struct {
 	SmID              int      `json:"sm_id" validate:"required"`
 	DateCreated       string   `json:"date_created" validate:"required"`
 	OofShard          string   `json:"oof_shard" validate:"required"`
+	Status            string   `json:"status,omitempty"`
 	Delivery          Delivery `json:"delivery" validate:"required"`
 	Payment           Payment  `json:"payment" validate:"required"`
 	Items             []Items  `json:"items" validate:"dive"`
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	OrderStatusActive    = "active"
+	OrderStatusCancelled = "cancelled"
+)
+
+// Event types recorded in order_events and carried by Kafka envelopes.
+const (
+	EventOrderCreated           = "order.created"
+	EventOrderUpdated           = "order.updated"
+	EventOrderCancelled         = "order.cancelled"
+	EventOrderItemStatusChanged = "order.item_status_changed"
+	EventOrderDeliveryChanged   = "order.delivery_changed"
+)
+
+// OrderEvent is one entry of an order's change history.
+type OrderEvent struct {
+	ID             int
+	OrderID        int
+	EventType      string
+	IdempotencyKey string
+	Payload        json.RawMessage
+	CreatedAt      time.Time
+	// Reapply applies the event even if its idempotency key is in the
+	// processed-messages ledger. It was then recorded and published the first
+	// time, so it isn't added to the history or the outbox again.
+	Reapply bool
+}
+
+// ItemStatusChange sets the status of one item of an order.
+type ItemStatusChange struct {
+	OrderUid string `json:"order_uid" validate:"required"`
+	ChrtID   int    `json:"chrt_id" validate:"required"`
+	Status   int    `json:"status" validate:"required"`
+}
+
+// DeliveryChange replaces the delivery address of an order.
+type DeliveryChange struct {
+	OrderUid string   `json:"order_uid" validate:"required"`
+	Delivery Delivery `json:"delivery" validate:"required"`
+}
+
+// Cancellation cancels an order.
+type Cancellation struct {
+	OrderUid string `json:"order_uid" validate:"required"`
+	Reason   string `json:"reason"`
+}
+
+// OutboxMessage is a stored change waiting to be published to the outbox topic.
+type OutboxMessage struct {
+	ID        int64
+	OrderID   int
+	OrderUid  string
+	EventType string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+	Attempts  int
+}
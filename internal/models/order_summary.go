@@ -0,0 +1,18 @@
+package models
+
+// OrderSummary is an order without its items and personal data, as listed in
+// a customer's order history.
+type OrderSummary struct {
+	ID              int    `json:"id"`
+	OrderUid        string `json:"order_uid"`
+	TrackNumber     string `json:"track_number"`
+	DateCreated     string `json:"date_created"`
+	Status          string `json:"status"`
+	DeliveryService string `json:"delivery_service"`
+	DeliveryCity    string `json:"delivery_city"`
+	Currency        string `json:"currency"`
+	Amount          int    `json:"amount"`
+	GoodsTotal      int    `json:"goods_total"`
+	DeliveryCost    int    `json:"delivery_cost"`
+	ItemCount       int    `json:"item_count"`
+}
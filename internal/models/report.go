@@ -0,0 +1,38 @@
+package models
+
+// RevenueRow is the revenue of one day in one currency through one payment
+// provider. Day is the date part of date_created.
+type RevenueRow struct {
+	Day      string `json:"day"`
+	Currency string `json:"currency"`
+	Provider string `json:"provider"`
+	Orders   int    `json:"orders"`
+	Revenue  int64  `json:"revenue"`
+}
+
+// ProductRow is the sales of a brand or an nm_id paid in Currency. Quantity
+// counts sold items.
+type ProductRow struct {
+	Key      string `json:"key"`
+	Currency string `json:"currency"`
+	Quantity int64  `json:"quantity"`
+	Revenue  int64  `json:"revenue"`
+}
+
+// DeliveryCostRow is the average delivery cost of orders shipped by a delivery
+// service to a region.
+type DeliveryCostRow struct {
+	DeliveryService string  `json:"delivery_service"`
+	Region          string  `json:"region"`
+	Orders          int     `json:"orders"`
+	AvgDeliveryCost float64 `json:"avg_delivery_cost"`
+}
+
+// SaleBucket counts items sold with a sale percentage from SaleFrom to SaleTo,
+// inclusive.
+type SaleBucket struct {
+	SaleFrom int   `json:"sale_from"`
+	SaleTo   int   `json:"sale_to"`
+	Items    int64 `json:"items"`
+	Revenue  int64 `json:"revenue"`
+}
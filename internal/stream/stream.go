@@ -0,0 +1,130 @@
+package stream
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wb-kafka-service/internal/models"
+)
+
+// OrderEvent is the summary of a stored order sent to feed subscribers.
+type OrderEvent struct {
+	Seq             uint64    `json:"seq"`
+	ID              int       `json:"id"`
+	OrderUid        string    `json:"order_uid"`
+	TrackNumber     string    `json:"track_number"`
+	CustomerID      string    `json:"customer_id"`
+	DeliveryService string    `json:"delivery_service"`
+	City            string    `json:"city"`
+	Amount          int       `json:"amount"`
+	Currency        string    `json:"currency"`
+	Items           int       `json:"items"`
+	DateCreated     string    `json:"date_created"`
+	StoredAt        time.Time `json:"stored_at"`
+}
+
+// Filter selects the orders a subscriber receives. Empty fields match any order.
+type Filter struct {
+	CustomerID      string
+	DeliveryService string
+}
+
+// Match reports whether e passes the filter.
+func (f Filter) Match(e OrderEvent) bool {
+	return (f.CustomerID == "" || f.CustomerID == e.CustomerID) &&
+		(f.DeliveryService == "" || f.DeliveryService == e.DeliveryService)
+}
+
+// Subscription delivers matching events through C. If the subscriber falls
+// behind by more than its buffer, it is evicted: Evicted is closed and no more
+// events are sent.
+type Subscription struct {
+	C       <-chan OrderEvent
+	Evicted <-chan struct{}
+
+	events  chan OrderEvent
+	evicted chan struct{}
+	filter  Filter
+}
+
+// Stats counts the hub's subscribers and events.
+type Stats struct {
+	Subscribers int    `json:"subscribers"`
+	Published   uint64 `json:"published"`
+	Evicted     uint64 `json:"evicted"`
+}
+
+// Hub fans stored orders out to the subscribers of the live feed. Publish
+// never blocks: a subscriber with a full buffer is dropped instead of slowing
+// down the consumer.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+
+	seq     atomic.Uint64
+	evicted atomic.Uint64
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers a subscriber with room for buffer undelivered events.
+func (h *Hub) Subscribe(filter Filter, buffer int) *Subscription {
+	events := make(chan OrderEvent, max(1, buffer))
+	evicted := make(chan struct{})
+	sub := &Subscription{C: events, Evicted: evicted, events: events, evicted: evicted, filter: filter}
+
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe removes sub. It is safe to call after sub was evicted.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	delete(h.subscribers, sub)
+	h.mu.Unlock()
+}
+
+// Publish sends the summary of order to every matching subscriber.
+func (h *Hub) Publish(order *models.Order) {
+	event := OrderEvent{
+		Seq:             h.seq.Add(1),
+		ID:              order.ID,
+		OrderUid:        order.OrderUid,
+		TrackNumber:     order.TrackNumber,
+		CustomerID:      order.CustomerID,
+		DeliveryService: order.DeliveryService,
+		City:            order.Delivery.City,
+		Amount:          order.Payment.Amount,
+		Currency:        order.Payment.Currency,
+		Items:           len(order.Items),
+		DateCreated:     order.DateCreated,
+		StoredAt:        time.Now().UTC(),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		if !sub.filter.Match(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(h.subscribers, sub)
+			close(sub.evicted)
+			h.evicted.Add(1)
+		}
+	}
+}
+
+// Stats returns the current counters.
+func (h *Hub) Stats() Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return Stats{Subscribers: len(h.subscribers), Published: h.seq.Load(), Evicted: h.evicted.Load()}
+}
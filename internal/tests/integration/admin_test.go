@@ -0,0 +1,225 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"wb-kafka-service/internal/auth"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/handlers"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).Times(2)
+
+	keys, err := auth.NewAPIKeys([]config.APIKey{
+		{Name: "ops", Key: "secret", Role: "admin"},
+		{Name: "desk", Key: "support-key", Role: "support"},
+	})
+	require.NoError(t, err)
+
+	var principal *auth.Principal
+	next := func(w http.ResponseWriter, r *http.Request) { principal = auth.FromContext(r.Context()) }
+	request := func(authorization string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/admin/consumer/pause", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	handlers.RequireAdmin(mockLogger, nil, next)(w, request("Bearer secret"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	handlers.RequireAdmin(mockLogger, keys, next)(w, request(""))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="wb-kafka-service"`, w.Header().Get("WWW-Authenticate"))
+
+	w = httptest.NewRecorder()
+	handlers.RequireAdmin(mockLogger, keys, next)(w, request("Bearer wrong"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	handlers.RequireAdmin(mockLogger, keys, next)(w, request("Bearer support-key"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Nil(t, principal)
+
+	handlers.RequireAdmin(mockLogger, keys, next)(httptest.NewRecorder(), request("Bearer secret"))
+	require.NotNil(t, principal)
+	assert.Equal(t, auth.Principal{Subject: "ops", Role: auth.RoleAdmin, Method: "api_key"}, *principal)
+}
+
+func adminRequest(target string, values map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, nil)
+	for name, value := range values {
+		r.SetPathValue(name, value)
+	}
+	return r
+}
+
+func TestHandlerCacheEvictAndRefresh(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Info(gomock.Any()).AnyTimes()
+
+	order := materialOrders(t)[0]
+	order.ID = 5
+
+	var deleted []string
+	mockCache.EXPECT().Delete(gomock.Any()).DoAndReturn(func(key string) error {
+		deleted = append(deleted, key)
+		return memcache.ErrCacheMiss
+	}).AnyTimes()
+	mockDB.EXPECT().GetOrderFromDB(gomock.Any(), 5).Return(&order, nil).Times(2)
+	mockDB.EXPECT().GetOrderFromDB(gomock.Any(), 6).Return(nil, postgres.ErrOrderNotFound).Times(2)
+
+	w := httptest.NewRecorder()
+	handlers.HandlerCacheEvict(mockLogger, mockDB, mockCache, w, adminRequest("/admin/cache/orders/5/evict", map[string]string{"id": "5"}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, deleted, cache.OrderKey(5))
+	assert.Contains(t, deleted, cache.DeliveryKey(order.Delivery.ID))
+	assert.Contains(t, deleted, cache.ItemKey(order.Items[0].ID))
+
+	// An order missing from the DB still loses its order key.
+	deleted = nil
+	w = httptest.NewRecorder()
+	handlers.HandlerCacheEvict(mockLogger, mockDB, mockCache, w, adminRequest("/admin/cache/orders/6/evict", map[string]string{"id": "6"}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, deleted, cache.OrderKey(6))
+
+	var stored []string
+	mockCache.EXPECT().Set(gomock.Any()).DoAndReturn(func(item *memcache.Item) error {
+		stored = append(stored, item.Key)
+		return nil
+	}).AnyTimes()
+
+	w = httptest.NewRecorder()
+	handlers.HandlerCacheRefresh(mockLogger, mockDB, mockCache, testOptions(), w, adminRequest("/admin/cache/orders/5/refresh", map[string]string{"id": "5"}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, stored, cache.OrderKey(5))
+
+	w = httptest.NewRecorder()
+	handlers.HandlerCacheRefresh(mockLogger, mockDB, mockCache, testOptions(), w, adminRequest("/admin/cache/orders/6/refresh", map[string]string{"id": "6"}))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	handlers.HandlerCacheEvict(mockLogger, mockDB, mockCache, w, adminRequest("/admin/cache/orders/x/evict", map[string]string{"id": "x"}))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCacheFlushFamilyAndWarmUp(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Info(gomock.Any()).AnyTimes()
+
+	orders := materialOrders(t)[:3]
+	byID := make(map[int]*models.Order)
+	for i := range orders {
+		orders[i].ID = 3 - i
+		byID[orders[i].ID] = &orders[i]
+	}
+	mockDB.EXPECT().ListOrderIDs(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, before, limit int) ([]int, error) {
+		var ids []int
+		for id := 3; id >= 1 && len(ids) < limit; id-- {
+			if before == 0 || id < before {
+				ids = append(ids, id)
+			}
+		}
+		return ids, nil
+	}).AnyTimes()
+	mockDB.EXPECT().GetOrderFromDB(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, id int) (*models.Order, error) {
+		return byID[id], nil
+	}).AnyTimes()
+
+	var deleted []string
+	mockCache.EXPECT().Delete(gomock.Any()).DoAndReturn(func(key string) error {
+		deleted = append(deleted, key)
+		return nil
+	}).AnyTimes()
+
+	w := httptest.NewRecorder()
+	handlers.HandlerCacheFlush(mockLogger, mockDB, mockCache, w, adminRequest("/admin/cache/families/payment/flush", map[string]string{"family": "payment"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, deleted, 3)
+	for _, key := range deleted {
+		assert.True(t, strings.HasPrefix(key, cache.SchemaVersion+":payment:"), key)
+	}
+
+	w = httptest.NewRecorder()
+	handlers.HandlerCacheFlush(mockLogger, mockDB, mockCache, w, adminRequest("/admin/cache/families/sessions/flush", map[string]string{"family": "sessions"}))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var stored []string
+	mockCache.EXPECT().Set(gomock.Any()).DoAndReturn(func(item *memcache.Item) error {
+		stored = append(stored, item.Key)
+		return nil
+	}).AnyTimes()
+
+	w = httptest.NewRecorder()
+	handlers.HandlerCacheWarmUp(mockLogger, mockDB, mockCache, testOptions(), w, httptest.NewRequest(http.MethodPost, "/admin/cache/warmup?limit=2", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"warmed": 2}`, w.Body.String())
+	assert.Contains(t, stored, cache.OrderKey(3))
+	assert.Contains(t, stored, cache.OrderKey(2))
+	assert.NotContains(t, stored, cache.OrderKey(1))
+}
+
+func TestHandlerLogLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	log, err := logger.NewLogger(path, false)
+	require.NoError(t, err)
+	defer log.Close()
+
+	w := httptest.NewRecorder()
+	handlers.HandlerLogLevel(log, w, httptest.NewRequest(http.MethodGet, "/admin/log-level", nil))
+	assert.JSONEq(t, `{"level": "info"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	handlers.HandlerLogLevel(log, w, httptest.NewRequest(http.MethodPost, "/admin/log-level", strings.NewReader(`{"level": "warn"}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "warn", log.Level())
+
+	w = httptest.NewRecorder()
+	handlers.HandlerLogLevel(log, w, httptest.NewRequest(http.MethodPost, "/admin/log-level", strings.NewReader(`{"level": "debug"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	log.Info("dropped")
+	log.Error("kept", nil)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry logger.LogEntry
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		messages = append(messages, entry.Message)
+	}
+	assert.Equal(t, []string{"Log level set to warn", "kept"}, messages)
+}
@@ -0,0 +1,264 @@
+package tests
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"wb-kafka-service/internal/auth"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/handlers"
+	"wb-kafka-service/internal/web"
+	"wb-kafka-service/pkg/generator/generatortest"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func bearer(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/order?id=1", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestHMACTokens(t *testing.T) {
+	secret := []byte("hmac-secret")
+	tokens := auth.NewHMACTokens(secret)
+
+	token, err := auth.IssueHMACToken(secret, "alice", auth.RoleSupport, time.Hour)
+	require.NoError(t, err)
+
+	p, err := tokens.Authenticate(bearer(token))
+	require.NoError(t, err)
+	assert.Equal(t, auth.Principal{Subject: "alice", Role: auth.RoleSupport, Method: "hmac"}, *p)
+
+	// The access_token query parameter is only taken by routes wrapped in
+	// handlers.AllowQueryToken.
+	_, err = tokens.Authenticate(httptest.NewRequest(http.MethodGet, "/order?id=1&access_token="+token, nil))
+	assert.ErrorIs(t, err, auth.ErrNoCredentials)
+
+	expired, err := auth.IssueHMACToken(secret, "alice", auth.RoleSupport, -time.Minute)
+	require.NoError(t, err)
+	_, err = tokens.Authenticate(bearer(expired))
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	other, err := auth.IssueHMACToken([]byte("other"), "alice", auth.RoleAdmin, time.Hour)
+	require.NoError(t, err)
+	_, err = tokens.Authenticate(bearer(other))
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	_, err = tokens.Authenticate(bearer("plain-api-key"))
+	assert.ErrorIs(t, err, auth.ErrNoCredentials)
+}
+
+func TestAPIKeys_KeysWithDots(t *testing.T) {
+	secret := []byte("hmac-secret")
+	keys, err := auth.NewAPIKeys([]config.APIKey{{Name: "ops", Key: "ops.v2.key", Role: "admin"}, {Name: "desk", Key: "desk.key", Role: "support"}})
+	require.NoError(t, err)
+	authn := auth.Chain{keys, auth.NewHMACTokens(secret)}
+
+	for key, subject := range map[string]string{"ops.v2.key": "ops", "desk.key": "desk"} {
+		p, err := authn.Authenticate(bearer(key))
+		require.NoError(t, err, key)
+		assert.Equal(t, subject, p.Subject)
+		assert.Equal(t, "api_key", p.Method)
+	}
+
+	// Unknown values that look signed are left to the token authenticators.
+	token, err := auth.IssueHMACToken(secret, "alice", auth.RoleSupport, time.Hour)
+	require.NoError(t, err)
+	_, err = keys.Authenticate(bearer(token))
+	assert.ErrorIs(t, err, auth.ErrNoCredentials)
+	p, err := authn.Authenticate(bearer(token))
+	require.NoError(t, err)
+	assert.Equal(t, "hmac", p.Method)
+
+	_, err = keys.Authenticate(bearer("unknown-key"))
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+}
+
+func signJWT(t *testing.T, header, claims map[string]any, signer func(signed string) []byte) string {
+	segment := func(v any) string {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := segment(header) + "." + segment(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signer(signed))
+}
+
+func TestJWT_RS256AndHS256(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	hsSecret := []byte("0123456789abcdef0123456789abcdef")
+
+	jwks := map[string]any{"keys": []map[string]any{
+		{
+			"kty": "RSA", "kid": "rsa-1", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+		{"kty": "oct", "kid": "hs-1", "k": base64.RawURLEncoding.EncodeToString(hsSecret)},
+	}}
+	data, err := json.Marshal(jwks)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+
+	var cfg config.AppConfig
+	cfg.Auth.JWT = config.JWTConfig{JWKSFile: path, Issuer: "https://id.example", Audience: "orders", RoleClaim: "roles"}
+	authn, err := auth.New(cfg)
+	require.NoError(t, err)
+
+	rs256 := func(signed string) []byte {
+		digest := sha256.Sum256([]byte(signed))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+		return sig
+	}
+	hs256 := func(secret []byte) func(string) []byte {
+		return func(signed string) []byte {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write([]byte(signed))
+			return mac.Sum(nil)
+		}
+	}
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"sub": "bob", "iss": "https://id.example", "aud": []string{"orders", "other"},
+			"exp": time.Now().Add(time.Hour).Unix(), "roles": []string{"viewer", "support", "admin"},
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	token := signJWT(t, map[string]any{"alg": "RS256", "kid": "rsa-1"}, claims(nil), rs256)
+	p, err := authn.Authenticate(bearer(token))
+	require.NoError(t, err)
+	assert.Equal(t, auth.Principal{Subject: "bob", Role: auth.RoleAdmin, Method: "jwt"}, *p)
+
+	token = signJWT(t, map[string]any{"alg": "HS256"}, claims(map[string]any{"roles": "support"}), hs256(hsSecret))
+	p, err = authn.Authenticate(bearer(token))
+	require.NoError(t, err)
+	assert.Equal(t, auth.RoleSupport, p.Role)
+
+	rejected := map[string]string{
+		"expired":        signJWT(t, map[string]any{"alg": "RS256"}, claims(map[string]any{"exp": time.Now().Add(-time.Minute).Unix()}), rs256),
+		"wrong issuer":   signJWT(t, map[string]any{"alg": "RS256"}, claims(map[string]any{"iss": "https://evil.example"}), rs256),
+		"wrong audience": signJWT(t, map[string]any{"alg": "RS256"}, claims(map[string]any{"aud": "billing"}), rs256),
+		"unknown role":   signJWT(t, map[string]any{"alg": "RS256"}, claims(map[string]any{"roles": "viewer"}), rs256),
+		"wrong kid":      signJWT(t, map[string]any{"alg": "RS256", "kid": "hs-1"}, claims(nil), rs256),
+		"wrong secret":   signJWT(t, map[string]any{"alg": "HS256"}, claims(nil), hs256([]byte("guess"))),
+		"alg none":       signJWT(t, map[string]any{"alg": "none"}, claims(nil), func(string) []byte { return nil }),
+		// An RS256 public key must not be accepted as an HS256 secret.
+		"key confusion": signJWT(t, map[string]any{"alg": "HS256", "kid": "rsa-1"}, claims(nil), hs256(rsaKey.N.Bytes())),
+	}
+	for name, token := range rejected {
+		_, err := authn.Authenticate(bearer(token))
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials, name)
+	}
+}
+
+func TestMaskOrder(t *testing.T) {
+	order := materialOrders(t)[0]
+	order.Delivery.Name = "Test Testov"
+	order.Delivery.Phone = "+9720000000"
+	order.Delivery.Email = "test@gmail.com"
+	order.Payment.Transaction = "b563feb7b2b84b6test"
+
+	masked := auth.MaskOrder(&order)
+	assert.Equal(t, "T*** T*****", masked.Delivery.Name)
+	assert.Equal(t, "+9*******00", masked.Delivery.Phone)
+	assert.Equal(t, "t***@gmail.com", masked.Delivery.Email)
+	assert.Equal(t, strings.Repeat("*", 15)+"test", masked.Payment.Transaction)
+	assert.Equal(t, order.Delivery.City, masked.Delivery.City)
+	assert.Equal(t, order.Payment.Amount, masked.Payment.Amount)
+
+	// The original, which may be cached, keeps its data.
+	assert.Equal(t, "Test Testov", order.Delivery.Name)
+}
+
+func TestHandlerOrder_MasksPIIForSupport(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	order := generatortest.New(t).Order()
+	order.ID = 4
+	order.Locale = "en"
+	order.Delivery.Email = "customer@example.com"
+
+	mockCache.EXPECT().Get(gomock.Any()).Return(nil, memcache.ErrCacheMiss).AnyTimes()
+	mockCache.EXPECT().Set(gomock.Any()).Return(nil).AnyTimes()
+	mockDB.EXPECT().GetOrderFromDB(gomock.Any(), 4).Return(&order, nil).Times(2)
+
+	loader := cache.NewOrderLoader(testOptions(), mockCache, mockDB, mockLogger)
+	renderer, err := web.NewRenderer(mockLogger, "")
+	require.NoError(t, err)
+	keys, err := auth.NewAPIKeys([]config.APIKey{{Name: "ops", Key: "admin-key", Role: "admin"}, {Name: "desk", Key: "support-key", Role: "support"}})
+	require.NoError(t, err)
+
+	handler := handlers.RequireReader(mockLogger, keys, func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerOrder(mockLogger, loader, renderer, w, r)
+	})
+
+	for key, email := range map[string]string{"support-key": "c*******@example.com", "admin-key": "customer@example.com"} {
+		r := httptest.NewRequest(http.MethodGet, "/order?id=4", nil)
+		r.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		require.Equal(t, http.StatusOK, w.Code, key)
+		assert.Contains(t, w.Body.String(), "<td>"+email+"</td>", key)
+	}
+	assert.Equal(t, "customer@example.com", order.Delivery.Email)
+}
+
+func TestAllowQueryToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	secret := []byte("hmac-secret")
+	token, err := auth.IssueHMACToken(secret, "alice", auth.RoleSupport, time.Hour)
+	require.NoError(t, err)
+
+	var seen *http.Request
+	handler := handlers.AllowQueryToken(handlers.RequireReader(mockLogger, auth.NewHMACTokens(secret), func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+	}))
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/orders/stream?customer_id=c1&access_token="+token, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "alice", auth.FromContext(seen.Context()).Subject)
+	// The token doesn't reach anything that logs or echoes the URL.
+	assert.Equal(t, "/orders/stream?customer_id=c1", seen.RequestURI)
+	assert.Equal(t, "customer_id=c1", seen.URL.RawQuery)
+
+	// A rejected token isn't echoed in the problem either.
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/orders/stream?access_token=", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotContains(t, w.Body.String(), "access_token")
+}
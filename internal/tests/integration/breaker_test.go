@@ -0,0 +1,266 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/breaker"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a breaker clock that only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestBreaker returns a breaker on a fake clock that records its transitions.
+func newTestBreaker(threshold int, cooldown time.Duration) (*breaker.Breaker, *fakeClock, *[]string) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var transitions []string
+	b := breaker.New("test", breaker.Settings{
+		FailureThreshold: threshold,
+		Cooldown:         cooldown,
+		IsFailure:        postgres.IsFailure,
+		Now:              clock.Now,
+		OnStateChange: func(name string, from, to breaker.State) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	})
+	return b, clock, &transitions
+}
+
+func TestBreaker_OpensAndRecovers(t *testing.T) {
+	b, clock, transitions := newTestBreaker(2, time.Minute)
+	down := errors.New("connection refused")
+
+	assert.ErrorIs(t, b.Do(func() error { return postgres.ErrOrderNotFound }), postgres.ErrOrderNotFound)
+	assert.ErrorIs(t, b.Do(func() error { return down }), down)
+	assert.Equal(t, breaker.Closed, b.State())
+	assert.ErrorIs(t, b.Do(func() error { return down }), down)
+	assert.True(t, b.IsOpen())
+
+	called := false
+	assert.ErrorIs(t, b.Do(func() error { called = true; return nil }), breaker.ErrOpen)
+	assert.False(t, called)
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, breaker.HalfOpen, b.State())
+	assert.ErrorIs(t, b.Do(func() error { return down }), down)
+	assert.True(t, b.IsOpen())
+
+	clock.Advance(time.Minute)
+	assert.NoError(t, b.Do(func() error { return nil }))
+	assert.Equal(t, breaker.Closed, b.State())
+	assert.Equal(t, []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}, *transitions)
+}
+
+func TestBreaker_OpensAtThreshold(t *testing.T) {
+	b, _, transitions := newTestBreaker(3, time.Minute)
+	down := errors.New("connection refused")
+
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, b.Do(func() error { return down }), down)
+	}
+	// A success resets the count of consecutive failures.
+	assert.NoError(t, b.Do(func() error { return nil }))
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, b.Do(func() error { return down }), down)
+	}
+	assert.Equal(t, breaker.Closed, b.State())
+	assert.Empty(t, *transitions)
+
+	assert.ErrorIs(t, b.Do(func() error { return down }), down)
+	assert.Equal(t, breaker.Open, b.State())
+	assert.Equal(t, []string{"closed->open"}, *transitions)
+}
+
+func TestBreaker_StaysOpenForTheCooldown(t *testing.T) {
+	b, clock, _ := newTestBreaker(1, time.Minute)
+	b.Do(func() error { return errors.New("connection refused") })
+
+	clock.Advance(time.Minute - time.Nanosecond)
+	assert.Equal(t, breaker.Open, b.State())
+	_, err := breaker.Call(b, func() (int, error) { return 1, nil })
+	assert.ErrorIs(t, err, breaker.ErrOpen)
+
+	clock.Advance(time.Nanosecond)
+	assert.Equal(t, breaker.HalfOpen, b.State())
+	n, err := breaker.Call(b, func() (int, error) { return 1, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, breaker.Closed, b.State())
+}
+
+func TestBreaker_LetsOneProbeThrough(t *testing.T) {
+	b, clock, _ := newTestBreaker(1, time.Minute)
+	b.Do(func() error { return errors.New("connection refused") })
+	clock.Advance(time.Minute)
+
+	// While the probe runs, other calls are rejected without being made.
+	var nested error
+	called := false
+	err := b.Do(func() error {
+		nested = b.Do(func() error { called = true; return nil })
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, nested, breaker.ErrOpen)
+	assert.False(t, called)
+	assert.Equal(t, breaker.Closed, b.State())
+
+	// A failed probe reopens the breaker for a full cooldown.
+	b.Do(func() error { return errors.New("connection refused") })
+	clock.Advance(time.Minute)
+	assert.Error(t, b.Do(func() error { return errors.New("connection refused") }))
+	assert.Equal(t, breaker.Open, b.State())
+	clock.Advance(time.Minute - time.Nanosecond)
+	assert.Equal(t, breaker.Open, b.State())
+}
+
+func TestBreaker_RecordsPanicsAsFailures(t *testing.T) {
+	b, clock, transitions := newTestBreaker(1, time.Minute)
+	assert.PanicsWithValue(t, "boom", func() {
+		b.Do(func() error { panic("boom") })
+	})
+	assert.Equal(t, breaker.Open, b.State())
+
+	// A panicking probe reopens the breaker instead of blocking it half-open.
+	clock.Advance(time.Minute)
+	assert.Panics(t, func() {
+		b.Do(func() error { panic("boom") })
+	})
+	assert.Equal(t, breaker.Open, b.State())
+
+	clock.Advance(time.Minute)
+	assert.NoError(t, b.Do(func() error { return nil }))
+	assert.Equal(t, breaker.Closed, b.State())
+	assert.Equal(t, []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}, *transitions)
+}
+
+func TestBreaker_ClassifiesFailures(t *testing.T) {
+	b, clock, _ := newTestBreaker(1, time.Minute)
+
+	// Outcomes that IsFailure rejects don't open the breaker.
+	for _, err := range []error{
+		postgres.ErrOrderNotFound,
+		postgres.ErrItemNotFound,
+		postgres.ErrOrderCancelled,
+		postgres.ErrDuplicateMessage,
+		postgres.ErrOrderExists,
+		context.Canceled,
+	} {
+		assert.ErrorIs(t, b.Do(func() error { return err }), err)
+		assert.Equal(t, breaker.Closed, b.State(), err.Error())
+	}
+
+	// Nor do they fail a probe.
+	b.Do(func() error { return errors.New("connection refused") })
+	clock.Advance(time.Minute)
+	assert.ErrorIs(t, b.Do(func() error { return postgres.ErrOrderNotFound }), postgres.ErrOrderNotFound)
+	assert.Equal(t, breaker.Closed, b.State())
+
+	// Without IsFailure every error counts.
+	strict := breaker.New("strict", breaker.Settings{FailureThreshold: 1, Cooldown: time.Minute})
+	strict.Do(func() error { return postgres.ErrOrderNotFound })
+	assert.True(t, strict.IsOpen())
+}
+
+func TestOrderLoader_DegradesWhenDependencyIsDown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	// No logger calls are expected: open breakers must not add error logs.
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	cacheBreaker := breaker.New("memcached", breaker.Settings{FailureThreshold: 1, Cooldown: time.Minute, IsFailure: cache.IsFailure})
+	dbBreaker := breaker.New("postgres", breaker.Settings{FailureThreshold: 1, Cooldown: time.Minute, IsFailure: postgres.IsFailure})
+	cacheBreaker.Do(func() error { return errors.New("memcache down") })
+
+	mockDB.EXPECT().GetOrderFromDB(gomock.Any(), 1).Return(&models.Order{ID: 1, OrderUid: "uid-1"}, nil)
+	mockDB.EXPECT().GetOrderFromDB(gomock.Any(), 2).Return(nil, errors.New("db down"))
+
+	loader := cache.NewOrderLoader(testOptions(),
+		cache.NewBreakerMemCache(mockCache, cacheBreaker),
+		postgres.NewBreakerPostgresDB(mockDB, dbBreaker),
+		mockLogger)
+
+	// DB-only: memcache is skipped without being called.
+	order, err := loader.Get(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "uid-1", order.OrderUid)
+
+	_, err = loader.Get(context.Background(), 2)
+	assert.Error(t, err)
+	assert.True(t, dbBreaker.IsOpen())
+
+	// Both down: the order isn't cached and the DB isn't asked.
+	_, err = loader.Get(context.Background(), 3)
+	assert.ErrorIs(t, err, breaker.ErrOpen)
+
+	_, err = cache.NewBreakerMemCache(mockCache, cacheBreaker).Get("v2:order:1")
+	assert.ErrorIs(t, err, breaker.ErrOpen)
+	assert.False(t, cache.IsFailure(memcache.ErrCacheMiss))
+}
+
+func TestBreakerPostgresDB_ExportReleasesTheProbe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	b, clock, _ := newTestBreaker(1, time.Minute)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	db := postgres.NewBreakerPostgresDB(mockDB, b)
+
+	b.Do(func() error { return errors.New("connection refused") })
+	clock.Advance(time.Minute)
+	require.Equal(t, breaker.HalfOpen, b.State())
+
+	// Once the probing export gets its first row, other calls go through
+	// while it still streams.
+	mockDB.EXPECT().ExportOrders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, filter postgres.ExportFilter, batch int, fn func(*models.ExportRow) error) error {
+			for i := 1; i <= 2; i++ {
+				if err := fn(&models.ExportRow{OrderID: i}); err != nil {
+					return err
+				}
+			}
+			return errors.New("connection reset")
+		})
+	mockDB.EXPECT().GetOrderFromDB(gomock.Any(), 1).Return(&models.Order{ID: 1}, nil)
+
+	var during error
+	err := db.ExportOrders(context.Background(), postgres.ExportFilter{}, postgres.DefaultExportBatch, func(row *models.ExportRow) error {
+		if row.OrderID == 2 {
+			_, during = db.GetOrderFromDB(context.Background(), 1)
+		}
+		return nil
+	})
+	assert.Error(t, err)
+	assert.NoError(t, during)
+	assert.Equal(t, breaker.Closed, b.State())
+
+	// An export that fails before its first row reopens the breaker.
+	b.Do(func() error { return errors.New("connection refused") })
+	clock.Advance(time.Minute)
+	mockDB.EXPECT().ExportOrders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("connection refused"))
+	assert.Error(t, db.ExportOrders(context.Background(), postgres.ExportFilter{}, postgres.DefaultExportBatch, func(*models.ExportRow) error { return nil }))
+	assert.Equal(t, breaker.Open, b.State())
+}
@@ -3,6 +3,7 @@ package tests
 import (
 	"encoding/json"
 	"testing"
+	"time"
 	"wb-kafka-service/internal/models"
 	"wb-kafka-service/internal/cache"
 	"wb-kafka-service/pkg/logger"
@@ -12,6 +13,18 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+func testOptions() cache.Options {
+	return cache.Options{
+		Codec:            cache.JSONCodec{},
+		OrderTTL:         time.Minute,
+		ItemTTL:          2 * time.Minute,
+		DeliveryTTL:      3 * time.Minute,
+		PaymentTTL:       4 * time.Minute,
+		NotFoundTTL:      time.Second,
+		EarlyRefreshBeta: 1,
+	}
+}
+
 func TestSaveToCache_Success(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -29,12 +42,44 @@ func TestSaveToCache_Success(t *testing.T) {
 
 	orderData, _ := json.Marshal(order)
 
-	mockCache.EXPECT().Set(&memcache.Item{Key: "order:1", Value: orderData}).Return(nil)
-	mockCache.EXPECT().Set(&memcache.Item{Key: "item:1", Value: []byte("0")}).Return(nil)
-	mockCache.EXPECT().Set(&memcache.Item{Key: "delivery:1", Value: []byte("John Doe")}).Return(nil)
-	mockCache.EXPECT().Set(&memcache.Item{Key: "payment:1", Value: []byte("trans123")}).Return(nil)
+	mockCache.EXPECT().Set(gomock.Any()).DoAndReturn(func(item *memcache.Item) error {
+		assert.Equal(t, "v2:order:1", item.Key)
+		assert.Equal(t, orderData, item.Value)
+		assert.Equal(t, int32(60), item.Expiration)
+		assert.InDelta(t, time.Now().Add(time.Minute).Unix(), int64(item.Flags), 1)
+		return nil
+	})
+	mockCache.EXPECT().Set(&memcache.Item{Key: "v2:item:1", Value: []byte("0"), Expiration: 120}).Return(nil)
+	mockCache.EXPECT().Set(&memcache.Item{Key: "v2:delivery:1", Value: []byte("John Doe"), Expiration: 180}).Return(nil)
+	mockCache.EXPECT().Set(&memcache.Item{Key: "v2:payment:1", Value: []byte("trans123"), Expiration: 240}).Return(nil)
+
+	err := cache.SaveToCache(mockLogger, mockCache, testOptions(), order)
+
+	assert.NoError(t, err)
+}
+
+func TestInvalidateOrder_DeletesAllFamilies(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	order := &models.Order{
+		ID:       5,
+		Delivery: models.Delivery{ID: 6},
+		Payment:  models.Payment{ID: 7},
+		Items:    []models.Items{{ID: 8}, {ID: 9}},
+	}
+
+	mockCache.EXPECT().Delete("v2:order:5").Return(nil)
+	mockCache.EXPECT().Delete("v2:order_not_found:5").Return(memcache.ErrCacheMiss)
+	mockCache.EXPECT().Delete("v2:delivery:6").Return(nil)
+	mockCache.EXPECT().Delete("v2:payment:7").Return(nil)
+	mockCache.EXPECT().Delete("v2:item:8").Return(nil)
+	mockCache.EXPECT().Delete("v2:item:9").Return(memcache.ErrCacheMiss)
 
-	err := cache.SaveToCache(mockLogger, mockCache, order)
+	err := cache.InvalidateOrder(mockLogger, mockCache, order)
 
 	assert.NoError(t, err)
 }
@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/unmarshal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCodecs(tb testing.TB) []cache.Codec {
+	codecs := []cache.Codec{cache.JSONCodec{}, cache.BinaryCodec{}}
+	for _, base := range []cache.Codec{cache.JSONCodec{}, cache.BinaryCodec{}} {
+		for _, algorithm := range []string{"snappy", "zstd"} {
+			codec, err := cache.NewCompressedCodec(base, algorithm, 0)
+			require.NoError(tb, err)
+			codecs = append(codecs, codec)
+		}
+	}
+	return codecs
+}
+
+func materialOrders(tb testing.TB) []models.Order {
+	log, err := logger.NewLogger("", false)
+	require.NoError(tb, err)
+	orders := unmarshal.ReadOrdersFromDirectory(log, "../../../materials")
+	require.NotEmpty(tb, orders)
+	for i := range orders {
+		orders[i].ID = i + 1
+	}
+	return orders
+}
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	orders := materialOrders(t)
+
+	for _, codec := range testCodecs(t) {
+		t.Run(codec.Name(), func(t *testing.T) {
+			for _, order := range orders {
+				data, err := codec.Marshal(&order)
+				require.NoError(t, err)
+
+				var decoded models.Order
+				require.NoError(t, codec.Unmarshal(data, &decoded))
+				assert.Equal(t, order, decoded)
+			}
+		})
+	}
+}
+
+func TestCodecs_RejectForeignData(t *testing.T) {
+	order := materialOrders(t)[0]
+	codecs := testCodecs(t)
+
+	for _, writer := range codecs {
+		data, err := writer.Marshal(&order)
+		require.NoError(t, err)
+
+		for _, reader := range codecs {
+			// Compressed codecs over the same base read each other's values by design.
+			readerBase, _, readerCompressed := strings.Cut(reader.Name(), "+")
+			writerBase, _, writerCompressed := strings.Cut(writer.Name(), "+")
+			if readerBase == writerBase && readerCompressed == writerCompressed {
+				continue
+			}
+			var decoded models.Order
+			err := reader.Unmarshal(data, &decoded)
+			assert.Error(t, err, fmt.Sprintf("%s decoded %s data", reader.Name(), writer.Name()))
+		}
+	}
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	orders := materialOrders(b)
+
+	for _, codec := range testCodecs(b) {
+		var size int
+		for _, order := range orders {
+			data, _ := codec.Marshal(&order)
+			size += len(data)
+		}
+
+		b.Run(codec.Name()+"/marshal", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for j := range orders {
+					if _, err := codec.Marshal(&orders[j]); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(size)/float64(len(orders)), "bytes/order")
+		})
+
+		encoded := make([][]byte, len(orders))
+		for j := range orders {
+			encoded[j], _ = codec.Marshal(&orders[j])
+		}
+
+		b.Run(codec.Name()+"/unmarshal", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, data := range encoded {
+					var order models.Order
+					if err := codec.Unmarshal(data, &order); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
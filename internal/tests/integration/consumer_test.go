@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/ratelimit"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumer_PauseAndResume(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Info(gomock.Any()).AnyTimes()
+
+	var cfg config.AppConfig
+	cfg.Kafka.Broker = "localhost:9092"
+	cfg.Kafka.Topic = "orders"
+	consumer := kafka.NewConsumer(cfg, nil, mockLogger, nil, testOptions())
+
+	var dbDown atomic.Bool
+	consumer.PauseWhen("db unavailable", dbDown.Load)
+	assert.Equal(t, kafka.ConsumerStatus{}, consumer.Status())
+
+	dbDown.Store(true)
+	assert.Equal(t, kafka.ConsumerStatus{Paused: true, Reason: "db unavailable"}, consumer.Status())
+
+	consumer.Pause()
+	assert.Equal(t, kafka.ConsumerStatus{Paused: true, Reason: kafka.PauseManual}, consumer.Status())
+
+	consumer.Resume()
+	assert.Equal(t, kafka.ConsumerStatus{Paused: true, Reason: "db unavailable"}, consumer.Status())
+
+	dbDown.Store(false)
+	assert.Equal(t, kafka.ConsumerStatus{}, consumer.Status())
+}
+
+func TestBucket_LimitsRate(t *testing.T) {
+	bucket := ratelimit.NewBucket(100, 5)
+	for i := 0; i < 5; i++ {
+		assert.True(t, bucket.Allow())
+	}
+	assert.False(t, bucket.Allow())
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, bucket.Wait(context.Background()))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	empty := ratelimit.NewBucket(0, 1)
+	assert.True(t, empty.Allow())
+	assert.ErrorIs(t, empty.Wait(ctx), context.Canceled)
+}
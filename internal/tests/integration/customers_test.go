@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wb-kafka-service/internal/handlers"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/breaker"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func customerOrdersRequest(customerID, query string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/customers/"+customerID+"/orders"+query, nil)
+	r.SetPathValue("customer_id", customerID)
+	return r
+}
+
+func TestHandlerCustomerOrders_Pages(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	orders := []models.OrderSummary{
+		{ID: 7, DateCreated: "2024-05-03T10:00:00Z", Status: models.OrderStatusActive, DeliveryCity: "Kazan", Currency: "RUB", Amount: 1500, ItemCount: 2},
+		{ID: 4, DateCreated: "2024-05-02T10:00:00Z", Status: models.OrderStatusCancelled, DeliveryCity: "Omsk", Currency: "RUB", Amount: 300, ItemCount: 1},
+		{ID: 9, DateCreated: "2024-05-01T10:00:00Z", Status: models.OrderStatusActive, DeliveryCity: "Kazan", Currency: "RUB", Amount: 800, ItemCount: 3},
+	}
+
+	gomock.InOrder(
+		mockDB.EXPECT().ListCustomerOrders(gomock.Any(), postgres.CustomerOrdersQuery{CustomerID: "alice", Limit: 3}).Return(orders, nil),
+		mockDB.EXPECT().ListCustomerOrders(gomock.Any(), postgres.CustomerOrdersQuery{
+			CustomerID: "alice", AfterDate: "2024-05-02T10:00:00Z", AfterID: 4, Limit: 3,
+		}).Return(orders[2:], nil),
+	)
+
+	w := httptest.NewRecorder()
+	handlers.HandlerCustomerOrders(mockLogger, mockDB, w, customerOrdersRequest("alice", "?limit=2"))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var page handlers.CustomerOrders
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+	assert.Equal(t, "alice", page.CustomerID)
+	assert.Equal(t, handlers.SortDateCreatedDesc, page.Sort)
+	assert.Equal(t, orders[:2], page.Orders)
+	require.NotEmpty(t, page.NextCursor)
+
+	w = httptest.NewRecorder()
+	handlers.HandlerCustomerOrders(mockLogger, mockDB, w, customerOrdersRequest("alice", "?limit=2&cursor="+page.NextCursor))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	page = handlers.CustomerOrders{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+	assert.Equal(t, orders[2:], page.Orders)
+	assert.Empty(t, page.NextCursor, "the last page has no cursor")
+}
+
+func TestHandlerCustomerOrders_SortAndErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+
+	mockDB.EXPECT().ListCustomerOrders(gomock.Any(), postgres.CustomerOrdersQuery{CustomerID: "bob", Ascending: true, Limit: 21}).Return(nil, nil)
+
+	w := httptest.NewRecorder()
+	handlers.HandlerCustomerOrders(mockLogger, mockDB, w, customerOrdersRequest("bob", "?sort=date_created"))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"customer_id":"bob","sort":"date_created","orders":[]}`, w.Body.String())
+
+	for _, query := range []string{"?sort=amount", "?limit=0", "?limit=101", "?cursor=%21%21"} {
+		w = httptest.NewRecorder()
+		handlers.HandlerCustomerOrders(mockLogger, mockDB, w, customerOrdersRequest("bob", query))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	mockDB.EXPECT().ListCustomerOrders(gomock.Any(), gomock.Any()).Return(nil, breaker.ErrOpen)
+	w = httptest.NewRecorder()
+	handlers.HandlerCustomerOrders(mockLogger, mockDB, w, customerOrdersRequest("bob", ""))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
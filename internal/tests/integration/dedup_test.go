@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleEvent_SkipsDuplicates(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// No cache or logger calls are expected: a duplicate changes nothing.
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	order := materialOrders(t)[0]
+	created, err := kafka.NewOrderEvent(kafka.EventOrderCreated, "test-producer", &order)
+	require.NoError(t, err)
+	cancelled, err := kafka.NewEnvelope(kafka.EventOrderCancelled, "test-producer", order.OrderUid, models.Cancellation{OrderUid: order.OrderUid})
+	require.NoError(t, err)
+
+	mockDB.EXPECT().CreateOrder(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, event models.OrderEvent, o *models.Order) (bool, error) {
+		assert.Equal(t, created.IdempotencyKey, event.IdempotencyKey)
+		return false, postgres.ErrDuplicateMessage
+	})
+	mockDB.EXPECT().CancelOrder(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, event models.OrderEvent, c models.Cancellation) (int, error) {
+		assert.Equal(t, cancelled.IdempotencyKey, event.IdempotencyKey)
+		return 0, postgres.ErrDuplicateMessage
+	})
+
+	err = kafka.HandleEvent(context.Background(), mockDB, mockLogger, mockCache, testOptions(), created)
+	assert.ErrorIs(t, err, postgres.ErrDuplicateMessage)
+
+	err = kafka.HandleEvent(context.Background(), mockDB, mockLogger, mockCache, testOptions(), cancelled)
+	assert.ErrorIs(t, err, postgres.ErrDuplicateMessage)
+}
@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+	"wb-kafka-service/internal/kafka"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeMessage_Enveloped(t *testing.T) {
+	order := materialOrders(t)[0]
+
+	env, err := kafka.NewOrderEvent(kafka.EventOrderUpdated, "test-producer", &order)
+	require.NoError(t, err)
+
+	msg, err := kafka.EncodeMessage(env)
+	require.NoError(t, err)
+	assert.Equal(t, order.OrderUid, string(msg.Key))
+
+	decoded, err := kafka.DecodeMessage(msg)
+	require.NoError(t, err)
+	assert.Equal(t, kafka.EventOrderUpdated, decoded.EventType)
+	assert.Equal(t, kafka.EnvelopeSchemaVersion, decoded.SchemaVersion)
+	assert.Equal(t, "test-producer", decoded.ProducerID)
+	assert.Equal(t, env.IdempotencyKey, decoded.IdempotencyKey)
+
+	decodedOrder, err := decoded.Order()
+	require.NoError(t, err)
+	assert.Equal(t, order, decodedOrder)
+
+	// Headers may be lost when messages are copied between topics.
+	msg.Headers = nil
+	decoded, err = kafka.DecodeMessage(msg)
+	require.NoError(t, err)
+	assert.Equal(t, kafka.EventOrderUpdated, decoded.EventType)
+}
+
+func TestDecodeMessage_LegacyBareOrder(t *testing.T) {
+	order := materialOrders(t)[0]
+	value, err := json.Marshal(order)
+	require.NoError(t, err)
+
+	decoded, err := kafka.DecodeMessage(kafkago.Message{Topic: "orders", Partition: 2, Offset: 17, Value: value})
+	require.NoError(t, err)
+	assert.Equal(t, kafka.EventOrderCreated, decoded.EventType)
+	assert.Equal(t, 0, decoded.SchemaVersion)
+	assert.Equal(t, order.OrderUid, decoded.OrderUid)
+	assert.Equal(t, "orders/2/17", decoded.IdempotencyKey)
+
+	decodedOrder, err := decoded.Order()
+	require.NoError(t, err)
+	assert.Equal(t, order, decodedOrder)
+}
@@ -0,0 +1,274 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/pkg/breaker"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lifecycleEvents returns one event of every type but order.created for order.
+func lifecycleEvents(t *testing.T, order models.Order) map[kafka.EventType]kafka.Envelope {
+	updated, err := kafka.NewOrderEvent(kafka.EventOrderUpdated, "test-producer", &order)
+	require.NoError(t, err)
+	itemStatus, err := kafka.NewEnvelope(kafka.EventOrderItemStatusChanged, "test-producer", order.OrderUid,
+		models.ItemStatusChange{OrderUid: order.OrderUid, ChrtID: order.Items[0].ChrtID, Status: 302})
+	require.NoError(t, err)
+	delivery, err := kafka.NewEnvelope(kafka.EventOrderDeliveryChanged, "test-producer", order.OrderUid,
+		models.DeliveryChange{OrderUid: order.OrderUid, Delivery: order.Delivery})
+	require.NoError(t, err)
+	cancelled, err := kafka.NewEnvelope(kafka.EventOrderCancelled, "test-producer", order.OrderUid,
+		models.Cancellation{OrderUid: order.OrderUid, Reason: "customer request"})
+	require.NoError(t, err)
+
+	return map[kafka.EventType]kafka.Envelope{
+		kafka.EventOrderUpdated:           updated,
+		kafka.EventOrderItemStatusChanged: itemStatus,
+		kafka.EventOrderDeliveryChanged:   delivery,
+		kafka.EventOrderCancelled:         cancelled,
+	}
+}
+
+// expectChange sets up the DB call applying env and returns it.
+func expectChange(t *testing.T, mockDB *postgres.MockPostgresDB, env kafka.Envelope) *gomock.Call {
+	checkEvent := func(event models.OrderEvent) {
+		assert.Equal(t, env.IdempotencyKey, event.IdempotencyKey)
+		assert.Equal(t, string(env.EventType), event.EventType)
+	}
+	switch env.EventType {
+	case kafka.EventOrderUpdated:
+		return mockDB.EXPECT().UpdateOrder(gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, event models.OrderEvent, order *models.Order) {
+			checkEvent(event)
+		})
+	case kafka.EventOrderItemStatusChanged:
+		return mockDB.EXPECT().UpdateItemStatus(gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, event models.OrderEvent, change models.ItemStatusChange) {
+			checkEvent(event)
+			assert.Equal(t, 302, change.Status)
+		})
+	case kafka.EventOrderDeliveryChanged:
+		return mockDB.EXPECT().UpdateDelivery(gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, event models.OrderEvent, change models.DeliveryChange) {
+			checkEvent(event)
+		})
+	default:
+		return mockDB.EXPECT().CancelOrder(gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, event models.OrderEvent, cancellation models.Cancellation) {
+			checkEvent(event)
+			assert.Equal(t, "customer request", cancellation.Reason)
+		})
+	}
+}
+
+func TestHandleEvent_AppliesChanges(t *testing.T) {
+	order := materialOrders(t)[0]
+
+	for eventType, env := range lifecycleEvents(t, order) {
+		t.Run(string(eventType), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockCache := cache.NewMockMemCacheClient(ctrl)
+			mockDB := postgres.NewMockPostgresDB(ctrl)
+			mockLogger := logger.NewMockLogger(ctrl)
+			mockLogger.EXPECT().Info(gomock.Any()).AnyTimes()
+
+			// The changed order is reloaded and cached again.
+			expectChange(t, mockDB, env).Return(order.ID, nil)
+			mockDB.EXPECT().GetOrderFromDB(gomock.Any(), order.ID).Return(&order, nil)
+			mockCache.EXPECT().Delete(gomock.Any()).Return(memcache.ErrCacheMiss).AnyTimes()
+			mockCache.EXPECT().Set(gomock.Any()).Return(nil).MinTimes(1)
+
+			assert.NoError(t, kafka.HandleEvent(context.Background(), mockDB, mockLogger, mockCache, testOptions(), env))
+		})
+	}
+}
+
+func TestHandleEvent_RejectedChanges(t *testing.T) {
+	order := materialOrders(t)[0]
+	events := lifecycleEvents(t, order)
+
+	tests := []struct {
+		name string
+		env  kafka.Envelope
+		err  error
+	}{
+		{"update of a cancelled order", events[kafka.EventOrderUpdated], postgres.ErrOrderCancelled},
+		{"item status of a cancelled order", events[kafka.EventOrderItemStatusChanged], postgres.ErrOrderCancelled},
+		{"status of an unknown item", events[kafka.EventOrderItemStatusChanged], postgres.ErrItemNotFound},
+		{"delivery of an unknown order", events[kafka.EventOrderDeliveryChanged], postgres.ErrOrderNotFound},
+		{"cancellation of a cancelled order", events[kafka.EventOrderCancelled], postgres.ErrOrderCancelled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// Nothing changed, so nothing is reloaded or cached.
+			mockCache := cache.NewMockMemCacheClient(ctrl)
+			mockDB := postgres.NewMockPostgresDB(ctrl)
+			mockLogger := logger.NewMockLogger(ctrl)
+
+			expectChange(t, mockDB, tt.env).Return(0, tt.err)
+
+			err := kafka.HandleEvent(context.Background(), mockDB, mockLogger, mockCache, testOptions(), tt.env)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, kafka.ReasonRejected, kafka.ReasonOf(err))
+			assert.False(t, postgres.IsFailure(err))
+		})
+	}
+}
+
+func TestHandleEvent_Errors(t *testing.T) {
+	order := materialOrders(t)[0]
+	events := lifecycleEvents(t, order)
+
+	t.Run("undecodable change", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		env, err := kafka.NewEnvelope(kafka.EventOrderItemStatusChanged, "test-producer", order.OrderUid, "not a change")
+		require.NoError(t, err)
+
+		err = kafka.HandleEvent(context.Background(), postgres.NewMockPostgresDB(ctrl), logger.NewMockLogger(ctrl), cache.NewMockMemCacheClient(ctrl), testOptions(), env)
+		assert.ErrorContains(t, err, "error unmarshalling item status change")
+		assert.Equal(t, kafka.ReasonUndecodable, kafka.ReasonOf(err))
+		assert.False(t, kafka.Retryable(err))
+	})
+
+	t.Run("value too long", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := postgres.NewMockPostgresDB(ctrl)
+		mockLogger := logger.NewMockLogger(ctrl)
+		mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+		tooLong := &pgconn.PgError{Code: "22001", Message: "value too long for type character varying(255)"}
+		mockDB.EXPECT().CreateOrder(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(false, fmt.Errorf("error inserting delivery: %w", tooLong))
+
+		env, err := kafka.NewOrderEvent(kafka.EventOrderCreated, "test-producer", &order)
+		require.NoError(t, err)
+
+		err = kafka.HandleEvent(context.Background(), mockDB, mockLogger, cache.NewMockMemCacheClient(ctrl), testOptions(), env)
+		assert.ErrorIs(t, err, tooLong)
+		assert.False(t, postgres.IsFailure(err))
+		assert.False(t, kafka.Retryable(err))
+		assert.Equal(t, kafka.ReasonFailed, kafka.ReasonOf(err))
+	})
+
+	t.Run("reload failure drops the cached order", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockCache := cache.NewMockMemCacheClient(ctrl)
+		mockDB := postgres.NewMockPostgresDB(ctrl)
+		mockLogger := logger.NewMockLogger(ctrl)
+
+		reloadErr := errors.New("connection reset")
+		expectChange(t, mockDB, events[kafka.EventOrderDeliveryChanged]).Return(order.ID, nil)
+		mockDB.EXPECT().GetOrderFromDB(gomock.Any(), order.ID).Return(nil, reloadErr)
+		mockCache.EXPECT().Delete(cache.OrderKey(order.ID)).Return(nil)
+
+		err := kafka.HandleEvent(context.Background(), mockDB, mockLogger, mockCache, testOptions(), events[kafka.EventOrderDeliveryChanged])
+		assert.ErrorIs(t, err, reloadErr)
+		assert.True(t, kafka.Retryable(err))
+	})
+
+	t.Run("cache failure after the change is stored", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockCache := cache.NewMockMemCacheClient(ctrl)
+		mockDB := postgres.NewMockPostgresDB(ctrl)
+		mockLogger := logger.NewMockLogger(ctrl)
+		mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+		mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any())
+
+		expectChange(t, mockDB, events[kafka.EventOrderCancelled]).Return(order.ID, nil)
+		mockDB.EXPECT().GetOrderFromDB(gomock.Any(), order.ID).Return(&order, nil)
+		mockCache.EXPECT().Delete(gomock.Any()).Return(memcache.ErrCacheMiss).AnyTimes()
+		mockCache.EXPECT().Set(gomock.Any()).Return(errors.New("memcache: connection refused")).AnyTimes()
+
+		assert.NoError(t, kafka.HandleEvent(context.Background(), mockDB, mockLogger, mockCache, testOptions(), events[kafka.EventOrderCancelled]))
+	})
+
+	t.Run("unsupported event", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockLogger := logger.NewMockLogger(ctrl)
+		mockLogger.EXPECT().Warn(gomock.Any(), nil)
+
+		env, err := kafka.NewEnvelope("order.archived", "test-producer", order.OrderUid, struct{}{})
+		require.NoError(t, err)
+		assert.NoError(t, kafka.HandleEvent(context.Background(), postgres.NewMockPostgresDB(ctrl), mockLogger, cache.NewMockMemCacheClient(ctrl), testOptions(), env))
+	})
+}
+
+func TestHandleEvent_UsesCallerContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	order := materialOrders(t)[0]
+	env, err := kafka.NewOrderEvent(kafka.EventOrderCreated, "test-producer", &order)
+	require.NoError(t, err)
+
+	// A consumer that stops cancels the DB call in flight.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mockDB.EXPECT().CreateOrder(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, event models.OrderEvent, o *models.Order) (bool, error) {
+		return false, ctx.Err()
+	})
+
+	err = kafka.HandleEvent(ctx, mockDB, mockLogger, cache.NewMockMemCacheClient(ctrl), testOptions(), env)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRetryable(t *testing.T) {
+	violations := validation.Default.Validate(&models.Order{})
+	require.Error(t, violations)
+
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+		reason    kafka.Reason
+	}{
+		{"DB error", errors.New("connection refused"), true, kafka.ReasonFailed},
+		{"DB breaker open", breaker.ErrOpen, true, kafka.ReasonFailed},
+		{"undecodable payload", &kafka.PayloadError{What: "order", Err: errors.New("unexpected EOF")}, false, kafka.ReasonUndecodable},
+		{"invalid order", violations, false, kafka.ReasonInvalid},
+		{"missing order", postgres.ErrOrderNotFound, false, kafka.ReasonRejected},
+		{"cancelled order", fmt.Errorf("order 1: %w", postgres.ErrOrderCancelled), false, kafka.ReasonRejected},
+		{"duplicate", postgres.ErrDuplicateMessage, false, kafka.ReasonFailed},
+		{"value out of range", fmt.Errorf("error inserting item: %w", &pgconn.PgError{Code: "22003"}), false, kafka.ReasonFailed},
+		{"constraint violation", fmt.Errorf("error inserting order: %w", &pgconn.PgError{Code: "23514"}), false, kafka.ReasonFailed},
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, true, kafka.ReasonFailed},
+		{"unmarshallable payload", fmt.Errorf("error marshalling order: %w", &json.UnsupportedValueError{Str: "NaN"}), false, kafka.ReasonFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.retryable, kafka.Retryable(tt.err))
+			assert.Equal(t, tt.reason, kafka.ReasonOf(tt.err))
+		})
+	}
+}
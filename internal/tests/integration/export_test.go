@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wb-kafka-service/internal/export"
+	"wb-kafka-service/internal/handlers"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/breaker"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exportRows(n int) []models.ExportRow {
+	rows := make([]models.ExportRow, n)
+	for i := range rows {
+		rows[i] = models.ExportRow{
+			OrderID:         i/2 + 1,
+			OrderUid:        "uid-" + string(rune('a'+i%26)),
+			CustomerID:      "test",
+			DateCreated:     "2021-11-26T06:22:19Z",
+			DeliveryCity:    "Kiryat Mozkin, \"north\"",
+			PaymentAmount:   1817,
+			PaymentDT:       1637907727,
+			ItemNmID:        2389212 + i,
+			ItemSale:        -i,
+			ItemBrand:       "Vivienne Sabo",
+			PaymentCurrency: "USD",
+		}
+	}
+	return rows
+}
+
+// exportFrom expects ExportOrders calls that stream rows.
+func exportFrom(mockDB *postgres.MockPostgresDB, filter interface{}, rows []models.ExportRow) *gomock.Call {
+	return mockDB.EXPECT().ExportOrders(gomock.Any(), filter, postgres.DefaultExportBatch, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, filter postgres.ExportFilter, batch int, fn func(*models.ExportRow) error) error {
+			for i := range rows {
+				row := rows[i]
+				if err := fn(&row); err != nil {
+					return &postgres.CallbackError{Err: err}
+				}
+			}
+			return nil
+		})
+}
+
+func TestColumnarRoundTrip(t *testing.T) {
+	rows := exportRows(7)
+
+	var buf bytes.Buffer
+	w := export.NewColumnarWriter(&buf, 3)
+	for i := range rows {
+		require.NoError(t, w.Write(&rows[i]))
+	}
+	require.NoError(t, w.Close())
+
+	r, err := export.NewColumnarReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, r.Columns(), len(export.Columns))
+
+	column := func(name string) int {
+		for i, c := range r.Columns() {
+			if c.Name == name {
+				return i
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return -1
+	}
+
+	var groups []int
+	var nmIDs []int64
+	var cities []string
+	for {
+		group, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		groups = append(groups, group.Rows)
+		nmIDs = append(nmIDs, group.Ints[column("item_nm_id")]...)
+		cities = append(cities, group.Strings[column("delivery_city")]...)
+		assert.Equal(t, int64(-len(nmIDs)+1), group.Ints[column("item_sale")][group.Rows-1])
+	}
+
+	assert.Equal(t, []int{3, 3, 1}, groups)
+	for i, row := range rows {
+		assert.Equal(t, int64(row.ItemNmID), nmIDs[i])
+		assert.Equal(t, row.DeliveryCity, cities[i])
+	}
+
+	// A truncated file is detected instead of read as a shorter export.
+	r, err = export.NewColumnarReader(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	require.NoError(t, err)
+	for err == nil {
+		_, err = r.Next()
+	}
+	assert.ErrorIs(t, err, export.ErrCorrupt)
+}
+
+func TestColumnarReader_RejectsHugeLengths(t *testing.T) {
+	huge := binary.AppendUvarint(nil, 1<<62)
+
+	// A schema length past the limit.
+	_, err := export.NewColumnarReader(bytes.NewReader(append([]byte("WBCOL1\n"), huge...)))
+	assert.ErrorIs(t, err, export.ErrCorrupt)
+
+	// A schema length within the limit but past the end of the input.
+	_, err = export.NewColumnarReader(bytes.NewReader(append([]byte("WBCOL1\n"), binary.AppendUvarint(nil, 1<<19)...)))
+	assert.ErrorIs(t, err, export.ErrCorrupt)
+
+	var buf bytes.Buffer
+	w := export.NewColumnarWriter(&buf, 3)
+	require.NoError(t, w.Close())
+	file := buf.Bytes()
+	// Replace the footer with a row group whose first chunk claims too much.
+	body := bytes.Clone(file[:len(file)-len("WBCOL1\n")-2])
+
+	for _, group := range [][]byte{
+		huge,
+		append(binary.AppendUvarint(nil, 1), huge...),
+		append(binary.AppendUvarint(nil, 1), binary.AppendUvarint(nil, 1<<29)...),
+	} {
+		r, err := export.NewColumnarReader(bytes.NewReader(append(bytes.Clone(body), group...)))
+		require.NoError(t, err)
+		_, err = r.Next()
+		assert.ErrorIs(t, err, export.ErrCorrupt)
+	}
+}
+
+func TestHandlerExport_Formats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Info(gomock.Any()).AnyTimes()
+
+	rows := exportRows(3)
+	exportFrom(mockDB, postgres.ExportFilter{
+		Range:      postgres.ReportRange{From: "2021-11-01", To: "2021-12-01"},
+		CustomerID: "test",
+	}, rows)
+	exportFrom(mockDB, postgres.ExportFilter{Status: models.OrderStatusActive}, rows)
+
+	w := httptest.NewRecorder()
+	handlers.HandlerExport(mockLogger, mockDB, w, httptest.NewRequest(http.MethodGet, "/api/v1/orders/export?from=2021-11-01&to=2021-11-30&customer_id=test", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="orders.csv"`, w.Header().Get("Content-Disposition"))
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, "order_id", records[0][0])
+	assert.Len(t, records[1], len(export.Columns))
+	assert.Equal(t, "Kiryat Mozkin, \"north\"", records[1][15])
+
+	w = httptest.NewRecorder()
+	handlers.HandlerExport(mockLogger, mockDB, w, httptest.NewRequest(http.MethodGet, "/api/v1/orders/export?format=ndjson&status=active", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	scanner := bufio.NewScanner(w.Body)
+	var decoded []models.ExportRow
+	for scanner.Scan() {
+		var row models.ExportRow
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &row))
+		decoded = append(decoded, row)
+	}
+	assert.Equal(t, rows, decoded)
+}
+
+func TestHandlerExport_Errors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	for _, query := range []string{"?format=xlsx", "?from=2021-13-01"} {
+		w := httptest.NewRecorder()
+		handlers.HandlerExport(mockLogger, mockDB, w, httptest.NewRequest(http.MethodGet, "/api/v1/orders/export"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	// Nothing was sent yet, so the failure is still reported as a problem.
+	mockDB.EXPECT().ExportOrders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(breaker.ErrOpen)
+	w := httptest.NewRecorder()
+	handlers.HandlerExport(mockLogger, mockDB, w, httptest.NewRequest(http.MethodGet, "/api/v1/orders/export", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Disposition"))
+
+	// Once rows were sent, the response is aborted.
+	mockDB.EXPECT().ExportOrders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, filter postgres.ExportFilter, batch int, fn func(*models.ExportRow) error) error {
+			rows := exportRows(2000)
+			for i := range rows {
+				if err := fn(&rows[i]); err != nil {
+					return err
+				}
+			}
+			return errors.New("connection reset")
+		})
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handlers.HandlerExport(mockLogger, mockDB, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/orders/export", nil))
+	})
+}
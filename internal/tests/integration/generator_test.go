@@ -0,0 +1,44 @@
+package tests
+
+import (
+	"testing"
+	"wb-kafka-service/pkg/generator"
+	"wb-kafka-service/pkg/generator/generatortest"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator_OrdersAreValidAndConsistent(t *testing.T) {
+	validate := validator.New()
+	gen := generatortest.New(t)
+
+	for _, order := range gen.Orders(200) {
+		assert.NoError(t, validate.Struct(order))
+
+		goodsTotal := 0
+		for _, item := range order.Items {
+			assert.Equal(t, order.TrackNumber, item.TrackNumber)
+			assert.Equal(t, generator.TotalPrice(item.Price, item.Sale), item.TotalPrice)
+			goodsTotal += item.TotalPrice
+		}
+		assert.Equal(t, goodsTotal, order.Payment.GoodsTotal)
+		assert.Equal(t, order.Payment.GoodsTotal+order.Payment.DeliveryCost+order.Payment.CustomFee, order.Payment.Amount)
+	}
+}
+
+func TestGenerator_SameSeedSameOrders(t *testing.T) {
+	assert.Equal(t, generator.New(42).Orders(5), generator.New(42).Orders(5))
+}
+
+func TestGenerator_TagDefectsFailValidation(t *testing.T) {
+	validate := validator.New()
+	gen := generatortest.New(t)
+
+	for _, defect := range []generator.Defect{generator.DefectMissingField, generator.DefectBadEmail} {
+		for i := 0; i < 20; i++ {
+			order := gen.InvalidOrder(defect)
+			assert.Error(t, validate.Struct(order), string(defect))
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/handlers"
+	"wb-kafka-service/internal/i18n"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/internal/web"
+	"wb-kafka-service/pkg/generator/generatortest"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundle_Negotiate(t *testing.T) {
+	bundle, err := i18n.Load()
+	require.NoError(t, err)
+	assert.Equal(t, validation.KnownLocales, bundle.Languages())
+
+	request := func(target, acceptLanguage string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Accept-Language", acceptLanguage)
+		return r
+	}
+
+	assert.Equal(t, "ru", bundle.Negotiate(request("/order?id=1&lang=ru", "en-US"), "en").Lang())
+	assert.Equal(t, "ru", bundle.Negotiate(request("/order?id=1", "de-DE, ru-RU;q=0.8, en;q=0.5"), "en").Lang())
+	assert.Equal(t, "en", bundle.Negotiate(request("/order?id=1&lang=xx", "ru;q=0, en;q=0.1"), "ru").Lang())
+	assert.Equal(t, "ru", bundle.Negotiate(request("/order?id=1", "de, *"), "ru").Lang())
+	assert.Equal(t, "en", bundle.Negotiate(request("/order?id=1", ""), "").Lang())
+
+	assert.Equal(t, []string{"fr", "en-GB", "en"}, i18n.AcceptedLanguages("en;q=0.7, fr, en-GB;q=0.9, de;q=0"))
+}
+
+func TestLocalizer_Formats(t *testing.T) {
+	bundle, err := i18n.Load()
+	require.NoError(t, err)
+	en, ru := bundle.Localizer("en"), bundle.Localizer("ru-RU")
+
+	assert.Equal(t, "1,250,000 RUB", en.Money(1250000, "RUB"))
+	assert.Equal(t, "1 250 000 RUB", ru.Money(1250000, "RUB"))
+	assert.Equal(t, "-1,000", en.Number(-1000))
+	assert.Equal(t, "999", ru.Number(999))
+	assert.Equal(t, "30%", en.Percent(30))
+	assert.Equal(t, "30 %", ru.Percent(30))
+
+	assert.Equal(t, "26 Nov 2021, 06:22 UTC", en.Date("2021-11-26T06:22:19Z"))
+	assert.Equal(t, "26 нояб. 2021 г., 07:00 UTC", ru.Unix(1637910000))
+	assert.Equal(t, "not a date", ru.Date("not a date"))
+
+	assert.Equal(t, "1 item", en.N("order.items", 1))
+	assert.Equal(t, "2 items", en.N("order.items", 2))
+	assert.Equal(t, "1 товар", ru.N("order.items", 1))
+	assert.Equal(t, "3 товара", ru.N("order.items", 3))
+	assert.Equal(t, "11 товаров", ru.N("order.items", 11))
+	assert.Equal(t, "21 товар", ru.N("order.items", 21))
+
+	assert.Equal(t, "Заказ b563", ru.T("order.title", "b563"))
+	assert.Equal(t, "missing.key", ru.T("missing.key"))
+}
+
+func TestCatalogs_HaveTheSameKeys(t *testing.T) {
+	keys := func(lang string) map[string]bool {
+		data, err := os.ReadFile("../../i18n/locales/" + lang + ".json")
+		require.NoError(t, err)
+		var catalog i18n.Catalog
+		require.NoError(t, json.Unmarshal(data, &catalog))
+
+		set := make(map[string]bool)
+		for key := range catalog.Messages {
+			set[key] = true
+		}
+		return set
+	}
+
+	// Plural forms differ between languages; every language needs ".other".
+	en, ru := keys("en"), keys("ru")
+	for key := range en {
+		if base, ok := strings.CutSuffix(key, ".one"); ok {
+			key = base + ".other"
+		}
+		assert.True(t, ru[key], "ru catalog is missing %s", key)
+	}
+}
+
+func TestHandlerOrder_UsesOrderLocale(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	order := generatortest.New(t).Order()
+	order.ID = 3
+	order.Locale = "ru"
+
+	mockCache.EXPECT().Get(gomock.Any()).Return(nil, memcache.ErrCacheMiss).AnyTimes()
+	mockCache.EXPECT().Set(gomock.Any()).Return(nil).Times(2)
+	mockDB.EXPECT().GetOrderFromDB(gomock.Any(), 3).Return(&order, nil).Times(2)
+
+	loader := cache.NewOrderLoader(testOptions(), mockCache, mockDB, mockLogger)
+	renderer, err := web.NewRenderer(mockLogger, "")
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handlers.HandlerOrder(mockLogger, loader, renderer, w, httptest.NewRequest(http.MethodGet, "/order?id=3", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ru", w.Header().Get("Content-Language"))
+	assert.Contains(t, w.Body.String(), `<html lang="ru">`)
+	assert.Contains(t, w.Body.String(), "Доставка")
+	assert.Contains(t, w.Body.String(), `href="/order?id=3&amp;lang=en"`)
+
+	// ?lang= wins over the order's locale and is kept by the search form.
+	w = httptest.NewRecorder()
+	handlers.HandlerOrder(mockLogger, loader, renderer, w, httptest.NewRequest(http.MethodGet, "/order?id=3&lang=en", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "en", w.Header().Get("Content-Language"))
+	assert.Contains(t, w.Body.String(), "Delivery Information")
+	assert.Contains(t, w.Body.String(), `<input type="hidden" name="lang" value="en">`)
+}
@@ -0,0 +1,426 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"wb-kafka-service/internal/importer"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/pkg/generator"
+	"wb-kafka-service/pkg/generator/generatortest"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+	"wb-kafka-service/pkg/unmarshal"
+
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compactOrder(tb testing.TB, order models.Order) string {
+	data, err := json.Marshal(order)
+	require.NoError(tb, err)
+	return string(data)
+}
+
+// readPositions lists what ReadOrders passes to its callback as
+// "line:order_uid" or "line:error".
+func readPositions(t *testing.T, name, input string) []string {
+	var got []string
+	err := unmarshal.ReadOrders(name, strings.NewReader(input), func(pos unmarshal.Position, order *models.Order, err error) error {
+		assert.Equal(t, name, pos.File)
+		if err != nil {
+			assert.Nil(t, order)
+			got = append(got, fmt.Sprintf("%d:error", pos.Line))
+			return nil
+		}
+		got = append(got, fmt.Sprintf("%d:%s", pos.Line, order.OrderUid))
+		return nil
+	})
+	require.NoError(t, err)
+	return got
+}
+
+func TestReadOrders_Formats(t *testing.T) {
+	gen := generatortest.New(t)
+	a, b := gen.Order(), gen.Order()
+	pretty, err := json.MarshalIndent(a, "", "  ")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		file  string
+		input string
+		want  []string
+	}{
+		{"single object", "a.json", "\n" + string(pretty) + "\n", []string{"2:" + a.OrderUid}},
+		{"concatenated objects", "a.json", string(pretty) + "\n" + string(pretty), []string{"1:" + a.OrderUid, fmt.Sprintf("%d:%s", bytes.Count(pretty, []byte("\n"))+2, a.OrderUid)}},
+		{"array", "a.json", "[\n" + compactOrder(t, a) + ",\n\n" + compactOrder(t, b) + "\n]\n", []string{"2:" + a.OrderUid, "4:" + b.OrderUid}},
+		{"array with a value of the wrong type", "a.json", "[" + compactOrder(t, a) + ",\n42,\n" + compactOrder(t, b) + "]", []string{"1:" + a.OrderUid, "2:error", "3:" + b.OrderUid}},
+		{"array with a syntax error", "a.json", "[\n" + compactOrder(t, a) + ",\n{\"order_uid\": }\n," + compactOrder(t, b) + "]", []string{"2:" + a.OrderUid, "3:error"}},
+		{"ndjson", "stdin", compactOrder(t, a) + "\n\n{bad\n" + compactOrder(t, b) + "\n", []string{"1:" + a.OrderUid, "3:error", "4:" + b.OrderUid}},
+		{"ndjson by extension", "a.ndjson", "{bad\n" + compactOrder(t, a), []string{"1:error", "2:" + a.OrderUid}},
+		{"empty", "stdin", "\n \n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, readPositions(t, tt.file, tt.input))
+		})
+	}
+}
+
+func TestImporter_Report(t *testing.T) {
+	gen := generatortest.New(t)
+	orders := gen.Orders(4)
+	invalid := gen.InvalidOrder(generator.DefectBadEmail)
+
+	var batches [][]string
+	sink := importer.SinkFunc(func(ctx context.Context, batch []*models.Order) ([]error, error) {
+		var uids []string
+		results := make([]error, len(batch))
+		for i, order := range batch {
+			uids = append(uids, order.OrderUid)
+			switch order.OrderUid {
+			case orders[2].OrderUid:
+				results[i] = importer.ErrDuplicate
+			case orders[3].OrderUid:
+				results[i] = errors.New("value too long")
+			}
+		}
+		batches = append(batches, uids)
+		return results, nil
+	})
+
+	input := strings.Join([]string{
+		compactOrder(t, orders[0]),
+		compactOrder(t, invalid),
+		compactOrder(t, orders[1]),
+		compactOrder(t, orders[0]),
+		"not json",
+		compactOrder(t, orders[2]),
+		compactOrder(t, orders[3]),
+	}, "\n")
+
+	im := importer.NewImporter(sink, 2)
+	require.NoError(t, im.Read(context.Background(), "stdin", strings.NewReader(input)))
+	require.NoError(t, im.Flush(context.Background()))
+
+	assert.Equal(t, [][]string{{orders[0].OrderUid, orders[1].OrderUid}, {orders[2].OrderUid, orders[3].OrderUid}}, batches)
+
+	report := im.Report()
+	assert.Equal(t, 2, report.Accepted)
+	assert.Empty(t, report.Failed)
+	require.Len(t, report.Duplicates, 2)
+	assert.Equal(t, "stdin:4: order "+orders[0].OrderUid+": already read at stdin:1", report.Duplicates[0].String())
+	assert.Equal(t, "stdin:6: order "+orders[2].OrderUid+": already imported", report.Duplicates[1].String())
+
+	require.Len(t, report.Rejected, 3)
+	assert.Equal(t, 2, report.Rejected[0].Position.Line)
+	assert.Contains(t, report.Rejected[0].Reason, "delivery.email")
+	assert.Equal(t, 5, report.Rejected[1].Position.Line)
+	assert.Empty(t, report.Rejected[1].OrderUid)
+	assert.Contains(t, report.Rejected[1].Reason, "invalid JSON")
+	assert.Equal(t, "stdin:7: order "+orders[3].OrderUid+": value too long", report.Rejected[2].String())
+
+	var out bytes.Buffer
+	report.Print(&out)
+	assert.True(t, strings.HasPrefix(out.String(), "accepted: 2, duplicates: 2, rejected: 3, failed: 0\nduplicates:\n  stdin:4: "), out.String())
+	assert.NotContains(t, out.String(), "failed:\n")
+}
+
+func TestImporter_StopsWhenABatchFails(t *testing.T) {
+	gen := generatortest.New(t)
+	orders := gen.Orders(3)
+
+	calls := 0
+	im := importer.NewImporter(importer.SinkFunc(func(ctx context.Context, batch []*models.Order) ([]error, error) {
+		calls++
+		return nil, errors.New("connection refused")
+	}), 2)
+
+	var input []string
+	for _, order := range orders {
+		input = append(input, compactOrder(t, order))
+	}
+	err := im.Read(context.Background(), "orders.ndjson", strings.NewReader(strings.Join(input, "\n")))
+	assert.EqualError(t, err, "connection refused")
+	assert.Equal(t, 1, calls)
+
+	report := im.Report()
+	assert.Zero(t, report.Accepted)
+	require.Len(t, report.Failed, 2)
+	assert.Equal(t, "orders.ndjson:2: order "+orders[1].OrderUid+": connection refused", report.Failed[1].String())
+}
+
+func TestDBSink_ReportsExistingOrdersAsDuplicates(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+
+	gen := generatortest.New(t)
+	a, b := gen.Order(), gen.Order()
+	batch := []*models.Order{&a, &b}
+	mockDB.EXPECT().ImportOrders(gomock.Any(), batch).Return([]error{nil, postgres.ErrOrderExists}, nil)
+
+	results, err := importer.DBSink(mockDB).Store(context.Background(), batch)
+	require.NoError(t, err)
+	assert.Equal(t, []error{nil, importer.ErrDuplicate}, results)
+	assert.False(t, postgres.IsFailure(postgres.ErrOrderExists))
+}
+
+func TestImporter_ListsPendingOrdersWhenStopped(t *testing.T) {
+	gen := generatortest.New(t)
+	orders := gen.Orders(4)
+
+	var stored []string
+	im := importer.NewImporter(importer.SinkFunc(func(ctx context.Context, batch []*models.Order) ([]error, error) {
+		for _, order := range batch {
+			stored = append(stored, order.OrderUid)
+		}
+		return make([]error, len(batch)), nil
+	}), 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var err error
+	for i, order := range orders {
+		if i == 3 {
+			cancel()
+		}
+		pos := unmarshal.Position{File: "orders.ndjson", Line: i + 1}
+		if err = im.Add(ctx, pos, &order, nil); err != nil {
+			break
+		}
+	}
+	require.ErrorIs(t, err, context.Canceled)
+
+	// The first batch was stored; the third order waited in the next one
+	// and the fourth was read when the import was interrupted.
+	report := im.Report()
+	assert.Equal(t, []string{orders[0].OrderUid, orders[1].OrderUid}, stored)
+	assert.Equal(t, 2, report.Accepted)
+	require.Len(t, report.Failed, 2)
+	assert.Equal(t, "orders.ndjson:3: order "+orders[2].OrderUid+": context canceled", report.Failed[0].String())
+	assert.Equal(t, "orders.ndjson:4: order "+orders[3].OrderUid+": context canceled", report.Failed[1].String())
+}
+
+func TestImporter_ForgetsOrdersOfAFailedBatch(t *testing.T) {
+	gen := generatortest.New(t)
+	orders := gen.Orders(2)
+
+	fail := true
+	im := importer.NewImporter(importer.SinkFunc(func(ctx context.Context, batch []*models.Order) ([]error, error) {
+		if fail {
+			return nil, errors.New("connection refused")
+		}
+		return make([]error, len(batch)), nil
+	}), 2)
+
+	pos := func(line int) unmarshal.Position { return unmarshal.Position{File: "stdin", Line: line} }
+	require.NoError(t, im.Add(context.Background(), pos(1), &orders[0], nil))
+	// An order_uid waiting in the batch is a duplicate already.
+	require.NoError(t, im.Add(context.Background(), pos(2), &orders[0], nil))
+	assert.Error(t, im.Add(context.Background(), pos(3), &orders[1], nil))
+
+	// The orders weren't stored, so reading them again isn't a duplicate.
+	fail = false
+	require.NoError(t, im.Add(context.Background(), pos(4), &orders[0], nil))
+	require.NoError(t, im.Flush(context.Background()))
+
+	report := im.Report()
+	assert.Equal(t, 1, report.Accepted)
+	require.Len(t, report.Duplicates, 1)
+	assert.Equal(t, "stdin:2: order "+orders[0].OrderUid+": already read at stdin:1", report.Duplicates[0].String())
+	assert.Len(t, report.Failed, 2)
+}
+
+// errAborted is what Postgres answers to statements of a transaction after
+// one failed, until it is rolled back to a savepoint.
+var errAborted = errors.New("current transaction is aborted, commands ignored until end of transaction block")
+
+// fakeImportTx is a pgx.Tx for ImportOrdersTx. It lists the rows inserted in
+// it as table names, "orders:<order_uid>" for orders, refuses the inserts
+// fail returns an error for and, like Postgres, fails every statement after
+// an error until a savepoint is rolled back.
+type fakeImportTx struct {
+	pgx.Tx
+	root   *fakeImportTx
+	parent *fakeImportTx
+	rows   []string
+	closed bool
+
+	// Set on the root only.
+	existing []string
+	fail     func(sql string, args []any) error
+	nextID   int
+	aborted  bool
+}
+
+func newFakeImportTx(existing []string, fail func(sql string, args []any) error) *fakeImportTx {
+	tx := &fakeImportTx{existing: existing, fail: fail}
+	tx.root = tx
+	return tx
+}
+
+func (tx *fakeImportTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	if tx.root.aborted {
+		return nil, errAborted
+	}
+	return &fakeImportTx{root: tx.root, parent: tx}, nil
+}
+
+func (tx *fakeImportTx) Commit(ctx context.Context) error {
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+	tx.closed = true
+	if tx.root.aborted {
+		return pgx.ErrTxCommitRollback
+	}
+	if tx.parent != nil {
+		tx.parent.rows = append(tx.parent.rows, tx.rows...)
+	}
+	return nil
+}
+
+func (tx *fakeImportTx) Rollback(ctx context.Context) error {
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+	tx.closed = true
+	tx.rows = nil
+	tx.root.aborted = false
+	return nil
+}
+
+func (tx *fakeImportTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if tx.root.aborted {
+		return nil, errAborted
+	}
+	return &fakeRows{uids: tx.root.existing}, nil
+}
+
+func (tx *fakeImportTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	root := tx.root
+	switch {
+	case root.aborted:
+		return fakeRow{err: errAborted}
+	case strings.HasPrefix(sql, "SELECT"):
+		return fakeRow{err: pgx.ErrNoRows}
+	}
+	if err := root.fail(sql, args); err != nil {
+		root.aborted = true
+		return fakeRow{err: err}
+	}
+
+	row := strings.Fields(sql)[2]
+	if row == "orders" {
+		row += ":" + args[0].(string)
+	}
+	tx.rows = append(tx.rows, row)
+	root.nextID++
+	return fakeRow{id: root.nextID}
+}
+
+// fakeRow answers the RETURNING id[, created_at] of an insert.
+type fakeRow struct {
+	id  int
+	err error
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	switch id := dest[0].(type) {
+	case *int:
+		*id = r.id
+	case *int64:
+		*id = int64(r.id)
+	}
+	if len(dest) > 1 {
+		*dest[1].(*time.Time) = time.Now()
+	}
+	return nil
+}
+
+// fakeRows returns the order_uids of existing orders.
+type fakeRows struct {
+	pgx.Rows
+	uids []string
+	uid  string
+}
+
+func (r *fakeRows) Next() bool {
+	if len(r.uids) == 0 {
+		return false
+	}
+	r.uid, r.uids = r.uids[0], r.uids[1:]
+	return true
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	*dest[0].(*string) = r.uid
+	return nil
+}
+
+func (r *fakeRows) Err() error { return nil }
+
+func (r *fakeRows) Close() {}
+
+func TestImportOrdersTx_RollsBackRejectedOrders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Info(gomock.Any()).AnyTimes()
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	gen := generatortest.New(t)
+	orders := gen.Orders(4)
+	invalid := gen.InvalidOrder(generator.DefectBadCurrency)
+	ok, tooLong, existing, after := &orders[0], &orders[1], &orders[2], &orders[3]
+
+	// The items of tooLong are refused after its delivery and payment were inserted.
+	tooLongErr := errors.New("value too long for type character varying(128)")
+	tx := newFakeImportTx([]string{existing.OrderUid}, func(sql string, args []any) error {
+		if strings.HasPrefix(sql, "INSERT INTO items") && args[1] == tooLong.Items[0].TrackNumber {
+			return tooLongErr
+		}
+		return nil
+	})
+
+	results, err := postgres.ImportOrdersTx(context.Background(), mockLogger, tx, []*models.Order{ok, tooLong, existing, &invalid, after})
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+	assert.NoError(t, results[0])
+	assert.ErrorContains(t, results[1], tooLongErr.Error())
+	assert.ErrorIs(t, results[2], postgres.ErrOrderExists)
+	assert.NotEmpty(t, validation.Violations(results[3]))
+	assert.NoError(t, results[4], "the batch goes on after a rolled back order")
+
+	// Nothing of tooLong is left, and every stored order has its event.
+	count := func(row string) int {
+		n := 0
+		for _, r := range tx.rows {
+			if r == row {
+				n++
+			}
+		}
+		return n
+	}
+	assert.Equal(t, 1, count("orders:"+ok.OrderUid))
+	assert.Equal(t, 1, count("orders:"+after.OrderUid))
+	assert.Zero(t, count("orders:"+tooLong.OrderUid))
+	for _, table := range []string{"delivery", "payment", "order_events", "outbox"} {
+		assert.Equal(t, 2, count(table), table)
+	}
+	assert.Equal(t, len(ok.Items)+len(after.Items), count("items"))
+}
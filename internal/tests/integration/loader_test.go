@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderLoader_CoalescesConcurrentMisses(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	mockCache.EXPECT().Get(gomock.Any()).Return(nil, memcache.ErrCacheMiss).AnyTimes()
+	mockCache.EXPECT().Set(gomock.Any()).Return(nil).Times(1)
+	mockDB.EXPECT().GetOrderFromDB(gomock.Any(), 1).DoAndReturn(func(ctx context.Context, orderID int) (*models.Order, error) {
+		time.Sleep(50 * time.Millisecond)
+		return &models.Order{ID: 1, OrderUid: "test-uid", Items: []models.Items{{ID: 7}}}, nil
+	}).Times(1)
+
+	loader := cache.NewOrderLoader(testOptions(), mockCache, mockDB, mockLogger)
+
+	var wg sync.WaitGroup
+	orders := make([]*models.Order, 20)
+	for i := range orders {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			order, err := loader.Get(context.Background(), 1)
+			assert.NoError(t, err)
+			orders[i] = order
+		}(i)
+	}
+	wg.Wait()
+
+	orders[0].Items[0].ID = 100
+	for _, order := range orders[1:] {
+		assert.Equal(t, "test-uid", order.OrderUid)
+		assert.Equal(t, 7, order.Items[0].ID)
+	}
+}
+
+func TestOrderLoader_CachesNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	gomock.InOrder(
+		mockCache.EXPECT().Get("v2:order:42").Return(nil, memcache.ErrCacheMiss),
+		mockCache.EXPECT().Get("v2:order_not_found:42").Return(nil, memcache.ErrCacheMiss),
+		mockDB.EXPECT().GetOrderFromDB(gomock.Any(), 42).Return(nil, postgres.ErrOrderNotFound),
+		mockCache.EXPECT().Set(&memcache.Item{Key: "v2:order_not_found:42", Value: []byte("1"), Expiration: 1}).Return(nil),
+		mockCache.EXPECT().Get("v2:order:42").Return(nil, memcache.ErrCacheMiss),
+		mockCache.EXPECT().Get("v2:order_not_found:42").Return(&memcache.Item{Key: "v2:order_not_found:42", Value: []byte("1")}, nil),
+	)
+
+	loader := cache.NewOrderLoader(testOptions(), mockCache, mockDB, mockLogger)
+
+	_, err := loader.Get(context.Background(), 42)
+	assert.ErrorIs(t, err, cache.ErrOrderNotFound)
+
+	_, err = loader.Get(context.Background(), 42)
+	assert.ErrorIs(t, err, cache.ErrOrderNotFound)
+}
@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func outboxConfig() config.AppConfig {
+	var cfg config.AppConfig
+	cfg.Kafka.ProducerID = "test-producer"
+	cfg.Kafka.Outbox.BatchSize = 10
+	cfg.Kafka.Outbox.PollInterval = time.Millisecond
+	cfg.Kafka.Outbox.MaxBackoff = time.Millisecond
+	return cfg
+}
+
+// relayOutbox expects a RelayOutbox call that claims messages and, like the
+// DB, wraps the error of publish in a CallbackError.
+func relayOutbox(mockDB *postgres.MockPostgresDB, messages []models.OutboxMessage) *gomock.Call {
+	return mockDB.EXPECT().RelayOutbox(gomock.Any(), 10, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, limit int, publish func([]models.OutboxMessage) (int, error)) (int, error) {
+			sent, err := publish(messages)
+			if err != nil {
+				return sent, &postgres.CallbackError{Err: err}
+			}
+			return sent, nil
+		})
+}
+
+func TestOutboxRelay_PublishesAndMarksSent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockPublisher := kafka.NewMockEventPublisher(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	messages := []models.OutboxMessage{
+		{ID: 3, OrderID: 1, OrderUid: "uid-1", EventType: models.EventOrderCreated, Payload: []byte(`{"order_uid":"uid-1"}`)},
+		{ID: 4, OrderID: 1, OrderUid: "uid-1", EventType: models.EventOrderCancelled, Payload: []byte(`{"order_uid":"uid-1"}`)},
+	}
+	var published []kafka.Envelope
+
+	relayOutbox(mockDB, messages)
+	mockPublisher.EXPECT().Publish(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, env kafka.Envelope) error {
+		published = append(published, env)
+		return nil
+	}).Times(2)
+
+	relay := kafka.NewOutboxRelay(outboxConfig(), mockDB, mockPublisher, mockLogger)
+	sent, err := relay.RelayBatch(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, kafka.EventOrderCreated, published[0].EventType)
+	assert.Equal(t, kafka.EventOrderCancelled, published[1].EventType)
+	assert.Equal(t, "outbox/3", published[0].IdempotencyKey)
+	assert.Equal(t, "uid-1", published[1].OrderUid)
+	assert.JSONEq(t, `{"order_uid":"uid-1"}`, string(published[1].Payload))
+}
+
+func TestOutboxRelay_StopsAtFirstFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockPublisher := kafka.NewMockEventPublisher(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	messages := []models.OutboxMessage{
+		{ID: 1, OrderUid: "uid-1", EventType: models.EventOrderCreated, Payload: []byte(`{}`)},
+		{ID: 2, OrderUid: "uid-1", EventType: models.EventOrderUpdated, Payload: []byte(`{}`)},
+		{ID: 3, OrderUid: "uid-1", EventType: models.EventOrderCancelled, Payload: []byte(`{}`)},
+	}
+	publishErr := errors.New("broker unavailable")
+
+	relayOutbox(mockDB, messages)
+	gomock.InOrder(
+		mockPublisher.EXPECT().Publish(gomock.Any(), gomock.Any()).Return(nil),
+		mockPublisher.EXPECT().Publish(gomock.Any(), gomock.Any()).Return(publishErr),
+	)
+	mockLogger.EXPECT().Error(gomock.Any(), publishErr)
+
+	relay := kafka.NewOutboxRelay(outboxConfig(), mockDB, mockPublisher, mockLogger)
+	sent, err := relay.RelayBatch(context.Background())
+
+	assert.ErrorIs(t, err, publishErr)
+	assert.False(t, postgres.IsFailure(err))
+	assert.Equal(t, 1, sent)
+}
+
+func TestLedgerSweeper_DeletesInBatches(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	var cfg config.AppConfig
+	cfg.Kafka.Consumer.LedgerRetention = 24 * time.Hour
+
+	var cutoffs []time.Time
+	deleteBatch := func(deleted int64, err error) func(ctx context.Context, before time.Time, limit int) (int64, error) {
+		return func(ctx context.Context, before time.Time, limit int) (int64, error) {
+			cutoffs = append(cutoffs, before)
+			return deleted, err
+		}
+	}
+	gomock.InOrder(
+		mockDB.EXPECT().DeleteProcessedMessages(gomock.Any(), gomock.Any(), 1000).DoAndReturn(deleteBatch(1000, nil)),
+		mockDB.EXPECT().DeleteProcessedMessages(gomock.Any(), gomock.Any(), 1000).DoAndReturn(deleteBatch(1000, nil)),
+		mockDB.EXPECT().DeleteProcessedMessages(gomock.Any(), gomock.Any(), 1000).DoAndReturn(deleteBatch(7, nil)),
+	)
+
+	deleted, err := kafka.NewLedgerSweeper(cfg, mockDB, logger.NewMockLogger(ctrl)).Sweep(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2007), deleted)
+	assert.WithinDuration(t, time.Now().Add(-24*time.Hour), cutoffs[0], time.Minute)
+	assert.Equal(t, cutoffs[0], cutoffs[2])
+
+	dbErr := errors.New("connection refused")
+	mockDB.EXPECT().DeleteProcessedMessages(gomock.Any(), gomock.Any(), 1000).DoAndReturn(deleteBatch(0, dbErr))
+	_, err = kafka.NewLedgerSweeper(cfg, mockDB, logger.NewMockLogger(ctrl)).Sweep(context.Background())
+	assert.ErrorIs(t, err, dbErr)
+}
+
+func TestOutboxSweeper_DeletesSentMessages(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	cfg := outboxConfig()
+	cfg.Kafka.Outbox.Retention = 48 * time.Hour
+
+	var cutoff time.Time
+	gomock.InOrder(
+		mockDB.EXPECT().DeleteSentOutbox(gomock.Any(), gomock.Any(), 1000).DoAndReturn(func(ctx context.Context, before time.Time, limit int) (int64, error) {
+			cutoff = before
+			return 1000, nil
+		}),
+		mockDB.EXPECT().DeleteSentOutbox(gomock.Any(), gomock.Any(), 1000).Return(int64(12), nil),
+	)
+
+	deleted, err := kafka.NewOutboxSweeper(cfg, mockDB, logger.NewMockLogger(ctrl)).Sweep(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1012), deleted)
+	assert.WithinDuration(t, time.Now().Add(-48*time.Hour), cutoff, time.Minute)
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/handlers"
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/internal/web"
+	"wb-kafka-service/pkg/generator"
+	"wb-kafka-service/pkg/generator/generatortest"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/golang/mock/gomock"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerOrder_InvalidOrderProblem(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	order := generatortest.New(t).InvalidOrder(generator.DefectTotalsMismatch)
+	order.ID = 1
+
+	mockCache.EXPECT().Get(gomock.Any()).Return(nil, memcache.ErrCacheMiss).AnyTimes()
+	mockCache.EXPECT().Set(gomock.Any()).Return(nil)
+	mockDB.EXPECT().GetOrderFromDB(gomock.Any(), 1).Return(&order, nil)
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any())
+
+	loader := cache.NewOrderLoader(testOptions(), mockCache, mockDB, mockLogger)
+	renderer, err := web.NewRenderer(mockLogger, "")
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	handlers.HandlerOrder(mockLogger, loader, renderer, w, httptest.NewRequest(http.MethodGet, "/order?id=1", nil))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+	var problem handlers.Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, handlers.ProblemInvalidOrder, problem.Type)
+	assert.Equal(t, "/order?id=1", problem.Instance)
+	require.NotEmpty(t, problem.Violations)
+	assert.Equal(t, "payment.goods_total", problem.Violations[0].Field)
+	assert.Equal(t, validation.RuleGoodsTotal, problem.Violations[0].Rule)
+}
+
+func TestDeadLetterHeaders_CarryViolations(t *testing.T) {
+	order := generatortest.New(t).InvalidOrder(generator.DefectBadLocale)
+	cause := validation.Default.Validate(&order)
+	msg := kafkago.Message{Topic: "orders", Partition: 2, Offset: 17}
+
+	assert.Equal(t, kafka.ReasonInvalid, kafka.ReasonOf(cause))
+	assert.Equal(t, kafka.ReasonRejected, kafka.ReasonOf(postgres.ErrOrderCancelled))
+	assert.Equal(t, kafka.ReasonFailed, kafka.ReasonOf(errors.New("connection refused")))
+
+	headers := make(map[string]string)
+	for _, h := range kafka.DeadLetterHeaders(msg, kafka.ReasonOf(cause), cause) {
+		headers[h.Key] = string(h.Value)
+	}
+	assert.Equal(t, "invalid", headers[kafka.HeaderDLQReason])
+	assert.Equal(t, "orders", headers[kafka.HeaderDLQTopic])
+	assert.Equal(t, "2", headers[kafka.HeaderDLQPartition])
+	assert.Equal(t, "17", headers[kafka.HeaderDLQOffset])
+
+	var violations []validation.Violation
+	require.NoError(t, json.Unmarshal([]byte(headers[kafka.HeaderDLQViolations]), &violations))
+	require.Len(t, violations, 1)
+	assert.Equal(t, validation.RuleLocale, violations[0].Rule)
+}
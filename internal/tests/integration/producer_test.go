@@ -0,0 +1,321 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/importer"
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/generator/generatortest"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/unmarshal"
+
+	"github.com/golang/mock/gomock"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBroker is a one-broker Kafka cluster for a kafka-go writer. Produce
+// requests to a partition in fail are refused with its error code.
+type fakeBroker struct {
+	topic      string
+	partitions int
+	fail       map[int]kafkago.Error
+
+	mu   sync.Mutex
+	keys map[int][]string
+}
+
+func newFakeBroker(topic string, partitions int) *fakeBroker {
+	return &fakeBroker{topic: topic, partitions: partitions, fail: map[int]kafkago.Error{}, keys: map[int][]string{}}
+}
+
+func (b *fakeBroker) RoundTrip(ctx context.Context, addr net.Addr, req protocol.Message) (protocol.Message, error) {
+	switch req := req.(type) {
+	case *metadata.Request:
+		topic := metadata.ResponseTopic{Name: b.topic}
+		for p := 0; p < b.partitions; p++ {
+			topic.Partitions = append(topic.Partitions, metadata.ResponsePartition{PartitionIndex: int32(p)})
+		}
+		return &metadata.Response{
+			Brokers: []metadata.ResponseBroker{{NodeID: 0, Host: "localhost", Port: 9092}},
+			Topics:  []metadata.ResponseTopic{topic},
+		}, nil
+	case *produce.Request:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		res := &produce.Response{}
+		for _, t := range req.Topics {
+			rt := produce.ResponseTopic{Topic: t.Topic}
+			for _, p := range t.Partitions {
+				partition := int(p.Partition)
+				rp := produce.ResponsePartition{Partition: p.Partition, BaseOffset: int64(len(b.keys[partition]))}
+				if code, ok := b.fail[partition]; ok {
+					rp.ErrorCode = int16(code)
+				} else {
+					keys, err := recordKeys(p.RecordSet.Records)
+					if err != nil {
+						return nil, err
+					}
+					b.keys[partition] = append(b.keys[partition], keys...)
+				}
+				rt.Partitions = append(rt.Partitions, rp)
+			}
+			res.Topics = append(res.Topics, rt)
+		}
+		return res, nil
+	default:
+		return nil, errors.New("fake broker: unexpected request")
+	}
+}
+
+// stored returns the keys of all stored messages, sorted.
+func (b *fakeBroker) stored() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []string
+	for _, k := range b.keys {
+		keys = append(keys, k...)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// partitionOf returns the partition the producer's hash balancer picks for key.
+func (b *fakeBroker) partitionOf(key string) int {
+	partitions := make([]int, b.partitions)
+	for i := range partitions {
+		partitions[i] = i
+	}
+	return (&kafkago.Hash{}).Balance(kafkago.Message{Key: []byte(key)}, partitions...)
+}
+
+func recordKeys(records protocol.RecordReader) ([]string, error) {
+	var keys []string
+	for {
+		record, err := records.ReadRecord()
+		if errors.Is(err, io.EOF) {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		key, err := protocol.ReadAll(record.Key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, string(key))
+	}
+}
+
+func producerConfig(async bool) config.AppConfig {
+	var cfg config.AppConfig
+	cfg.Kafka.Broker = "localhost:9092"
+	cfg.Kafka.Topic = "orders"
+	cfg.Kafka.ProducerID = "test-producer"
+	cfg.Kafka.Producer.Async = async
+	cfg.Kafka.Producer.BatchTimeout = time.Millisecond
+	cfg.Kafka.Producer.MaxAttempts = 1
+	return cfg
+}
+
+func newFakeProducer(t *testing.T, ctrl *gomock.Controller, broker *fakeBroker, async bool) *kafka.OrderProducer {
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Info(gomock.Any()).AnyTimes()
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	producer, err := kafka.NewOrderProducer(producerConfig(async), mockLogger)
+	require.NoError(t, err)
+	producer.UseTransport(broker)
+	return producer
+}
+
+func TestParseProducerSettings(t *testing.T) {
+	compressions := []struct {
+		name    string
+		want    kafkago.Compression
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"none", 0, false},
+		{"gzip", kafkago.Gzip, false},
+		{"snappy", kafkago.Snappy, false},
+		{"lz4", kafkago.Lz4, false},
+		{"zstd", kafkago.Zstd, false},
+		{"GZIP", 0, true},
+		{"brotli", 0, true},
+	}
+	for _, tt := range compressions {
+		t.Run("compression "+tt.name, func(t *testing.T) {
+			got, err := kafka.ParseCompression(tt.name)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "unknown Kafka compression")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	acks := []struct {
+		name    string
+		want    kafkago.RequiredAcks
+		wantErr bool
+	}{
+		{"", kafkago.RequireAll, false},
+		{"all", kafkago.RequireAll, false},
+		{"one", kafkago.RequireOne, false},
+		{"none", kafkago.RequireNone, false},
+		{"-1", 0, true},
+		{"2", 0, true},
+	}
+	for _, tt := range acks {
+		t.Run("required_acks "+tt.name, func(t *testing.T) {
+			got, err := kafka.ParseRequiredAcks(tt.name)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "unknown Kafka required_acks")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("invalid config", func(t *testing.T) {
+		cfg := producerConfig(false)
+		cfg.Kafka.Producer.Compression = "brotli"
+		_, err := kafka.NewOrderProducer(cfg, nil)
+		assert.Error(t, err)
+
+		cfg = producerConfig(false)
+		cfg.Kafka.Producer.RequiredAcks = "2"
+		_, err = kafka.NewOrderProducer(cfg, nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestOrderProducer_SyncPublish(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	broker := newFakeBroker("orders", 1)
+	producer := newFakeProducer(t, ctrl, broker, false)
+	defer producer.Close()
+
+	// Delivery reports are only for async mode.
+	producer.OnDelivery(func(report kafka.DeliveryReport) {
+		t.Errorf("unexpected delivery report for order %s", report.OrderUid)
+	})
+
+	order := generatortest.New(t).Order()
+	require.NoError(t, producer.PublishOrder(context.Background(), kafka.EventOrderCreated, &order))
+	assert.Equal(t, []string{order.OrderUid}, broker.stored())
+
+	broker.fail[0] = kafkago.MessageSizeTooLarge
+	err := producer.PublishOrder(context.Background(), kafka.EventOrderUpdated, &order)
+	var writeErrs kafkago.WriteErrors
+	require.ErrorAs(t, err, &writeErrs)
+	assert.ErrorIs(t, writeErrs[0], kafkago.MessageSizeTooLarge)
+	assert.Equal(t, []string{order.OrderUid}, broker.stored())
+}
+
+func TestOrderProducer_AsyncDelivery(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	broker := newFakeBroker("orders", 2)
+	producer := newFakeProducer(t, ctrl, broker, true)
+	require.True(t, producer.Async())
+
+	orders := generatortest.New(t).Orders(6)
+	broker.fail[1] = kafkago.MessageSizeTooLarge
+
+	var mu sync.Mutex
+	reports := map[string]kafka.DeliveryReport{}
+	producer.OnDelivery(func(report kafka.DeliveryReport) {
+		mu.Lock()
+		reports[report.OrderUid] = report
+		mu.Unlock()
+	})
+
+	// Publish only enqueues, so it succeeds even for the failing partition.
+	for i := range orders {
+		require.NoError(t, producer.PublishOrder(context.Background(), kafka.EventOrderCreated, &orders[i]))
+	}
+	require.NoError(t, producer.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, reports, len(orders))
+	var stored []string
+	for _, order := range orders {
+		report := reports[order.OrderUid]
+		assert.Equal(t, kafka.EventOrderCreated, report.EventType)
+		assert.NotEmpty(t, report.IdempotencyKey)
+		assert.Equal(t, broker.partitionOf(order.OrderUid), report.Partition)
+		if report.Partition == 1 {
+			assert.ErrorIs(t, report.Err, kafkago.MessageSizeTooLarge)
+			continue
+		}
+		assert.NoError(t, report.Err)
+		stored = append(stored, order.OrderUid)
+	}
+	sort.Strings(stored)
+	assert.Equal(t, stored, broker.stored())
+}
+
+func TestOrderProducer_PublishOrdersReportsEachOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	broker := newFakeBroker("orders", 2)
+	producer := newFakeProducer(t, ctrl, broker, false)
+	defer producer.Close()
+	broker.fail[1] = kafkago.MessageSizeTooLarge
+
+	// Enough orders to land on both partitions.
+	gen := generatortest.New(t)
+	var batch []*models.Order
+	onPartition := map[int]int{}
+	for len(batch) < 4 || onPartition[0] == 0 || onPartition[1] == 0 {
+		order := gen.Order()
+		batch = append(batch, &order)
+		onPartition[broker.partitionOf(order.OrderUid)]++
+	}
+
+	results, err := producer.PublishOrders(context.Background(), kafka.EventOrderCreated, batch)
+	require.NoError(t, err, "a partial failure is reported per order")
+	require.Len(t, results, len(batch))
+	var stored []string
+	for i, order := range batch {
+		if broker.partitionOf(order.OrderUid) == 1 {
+			assert.ErrorIs(t, results[i], kafkago.MessageSizeTooLarge, order.OrderUid)
+			continue
+		}
+		assert.NoError(t, results[i], order.OrderUid)
+		stored = append(stored, order.OrderUid)
+	}
+	sort.Strings(stored)
+	assert.Equal(t, stored, broker.stored())
+
+	// The importer counts the refused orders as rejected, the rest as accepted.
+	im := importer.NewImporter(importer.KafkaSink(producer), len(batch))
+	for i, order := range batch {
+		require.NoError(t, im.Add(context.Background(), unmarshal.Position{File: "stdin", Line: i + 1}, order, nil))
+	}
+	report := im.Report()
+	assert.Equal(t, onPartition[0], report.Accepted)
+	assert.Len(t, report.Rejected, onPartition[1])
+	assert.Empty(t, report.Failed)
+}
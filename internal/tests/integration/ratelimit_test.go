@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/handlers"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/ratelimit"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketTake(t *testing.T) {
+	b := ratelimit.NewBucket(2, 1)
+
+	ok, retry := b.Take()
+	assert.True(t, ok)
+	assert.Zero(t, retry)
+
+	ok, retry = b.Take()
+	assert.False(t, ok)
+	assert.InDelta(t, 500*time.Millisecond, retry, float64(50*time.Millisecond))
+
+	never := ratelimit.NewBucket(0, 1)
+	ok, _ = never.Take()
+	assert.True(t, ok, "a new bucket starts full")
+	ok, retry = never.Take()
+	assert.False(t, ok)
+	assert.Negative(t, retry, "a bucket without rate never refills")
+}
+
+func TestKeyedLimitsEachKey(t *testing.T) {
+	k := ratelimit.NewKeyed(1, 2)
+
+	for i := 0; i < 2; i++ {
+		ok, _ := k.Take("a")
+		assert.True(t, ok)
+	}
+	ok, retry := k.Take("a")
+	assert.False(t, ok)
+	assert.Positive(t, retry)
+
+	ok, _ = k.Take("b")
+	assert.True(t, ok, "keys have separate buckets")
+	assert.Equal(t, 2, k.Len())
+}
+
+func TestSemaphore(t *testing.T) {
+	s := ratelimit.NewSemaphore(1)
+	require.NoError(t, s.Acquire(context.Background()))
+	assert.Equal(t, 1, s.InUse())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Acquire(ctx), context.DeadlineExceeded)
+
+	s.Release()
+	require.NoError(t, s.Acquire(context.Background()))
+	s.Release()
+	assert.Zero(t, s.InUse())
+}
+
+func rateLimitConfig() config.AppConfig {
+	var cfg config.AppConfig
+	cfg.RateLimit.PerIP = config.RateConfig{Rate: 1, Burst: 2}
+	cfg.RateLimit.PerKey = config.RateConfig{Rate: 1, Burst: 1}
+	cfg.RateLimit.MaxConcurrent = 1
+	cfg.RateLimit.QueueTimeout = 20 * time.Millisecond
+	return cfg
+}
+
+func TestRateLimit_PerIP(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).Times(1)
+
+	limits := handlers.NewLimits(rateLimitConfig())
+	handler := handlers.RateLimit(mockLogger, limits, func(w http.ResponseWriter, r *http.Request) {})
+
+	request := func(addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/order?id=1", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:5000").Code)
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:5001").Code)
+
+	w := request("10.0.0.1:5002")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	retry, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, retry)
+
+	assert.Equal(t, http.StatusOK, request("10.0.0.2:5000").Code, "other addresses are not limited")
+	assert.Equal(t, int64(1), limits.Stats().Rejected[handlers.RejectedIPRate])
+}
+
+func TestRateLimit_PerKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).Times(1)
+
+	cfg := rateLimitConfig()
+	cfg.RateLimit.PerIP.Rate = 0
+	cfg.RateLimit.TrustForwardedFor = true
+	limits := handlers.NewLimits(cfg)
+	handler := handlers.RateLimit(mockLogger, limits, func(w http.ResponseWriter, r *http.Request) {})
+
+	request := func(key, forwardedFor string) int {
+		r := httptest.NewRequest(http.MethodGet, "/order?id=1", nil)
+		r.Header.Set("X-API-Key", key)
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request("key-a", "1.1.1.1"))
+	assert.Equal(t, http.StatusTooManyRequests, request("key-a", "2.2.2.2"), "a key is limited across addresses")
+	assert.Equal(t, http.StatusOK, request("key-b", "1.1.1.1"))
+	assert.Equal(t, int64(1), limits.Stats().Rejected[handlers.RejectedKeyRate])
+}
+
+func TestRateLimit_ForwardedFor(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+
+	cfg := rateLimitConfig()
+	cfg.RateLimit.PerIP.Burst = 1
+	cfg.RateLimit.TrustForwardedFor = true
+	handler := handlers.RateLimit(mockLogger, handlers.NewLimits(cfg), func(w http.ResponseWriter, r *http.Request) {})
+
+	request := func(forwardedFor string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request("9.9.9.9, 3.3.3.3"))
+	// Only the entry added by the proxy counts, not the forged ones before it.
+	assert.Equal(t, http.StatusTooManyRequests, request("8.8.8.8, 3.3.3.3"))
+	assert.Equal(t, http.StatusOK, request("3.3.3.3, 4.4.4.4"))
+}
+
+func TestLimitConcurrency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).Times(1)
+
+	limits := handlers.NewLimits(rateLimitConfig())
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := handlers.LimitConcurrency(mockLogger, limits, func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+
+	done := make(chan struct{})
+	go func() {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/order?id=1", nil))
+		close(done)
+	}()
+	<-entered
+	assert.Equal(t, 1, limits.Stats().InFlight)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/order?id=2", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	close(release)
+	<-done
+	assert.Zero(t, limits.Stats().InFlight)
+
+	metrics := httptest.NewRecorder()
+	handlers.HandlerMetrics(mockLogger, nil, limits, metrics, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.True(t, strings.Contains(metrics.Body.String(), `http_requests_rejected_total{reason="concurrency"} 1`))
+}
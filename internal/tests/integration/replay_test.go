@@ -0,0 +1,275 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/pkg/generator"
+	"wb-kafka-service/pkg/generator/generatortest"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/golang/mock/gomock"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckEvent(t *testing.T) {
+	gen := generatortest.New(t)
+
+	valid := gen.Order()
+	env, err := kafka.NewOrderEvent(kafka.EventOrderCreated, "test-producer", &valid)
+	require.NoError(t, err)
+	assert.NoError(t, kafka.CheckEvent(env))
+
+	invalid := gen.InvalidOrder(generator.DefectBadCurrency)
+	env, err = kafka.NewOrderEvent(kafka.EventOrderUpdated, "test-producer", &invalid)
+	require.NoError(t, err)
+	assert.NotEmpty(t, validation.Violations(kafka.CheckEvent(env)))
+
+	env, err = kafka.NewEnvelope(kafka.EventOrderCancelled, "test-producer", "uid-1", models.Cancellation{})
+	require.NoError(t, err)
+	violations := validation.Violations(kafka.CheckEvent(env))
+	require.Len(t, violations, 1)
+	assert.Equal(t, "order_uid", violations[0].Field)
+
+	env.Payload = []byte(`{"order_uid":`)
+	err = kafka.CheckEvent(env)
+	assert.Error(t, err)
+	assert.Nil(t, validation.Violations(err))
+}
+
+// fakePartitionReader serves queued messages, then blocks until ctx is done.
+type fakePartitionReader struct {
+	msgs []kafkago.Message
+}
+
+func (r *fakePartitionReader) FetchMessage(ctx context.Context) (kafkago.Message, error) {
+	if len(r.msgs) == 0 {
+		<-ctx.Done()
+		return kafkago.Message{}, ctx.Err()
+	}
+	msg := r.msgs[0]
+	r.msgs = r.msgs[1:]
+	return msg, nil
+}
+
+func (r *fakePartitionReader) Close() error {
+	return nil
+}
+
+// replayMessage encodes env as the message at offset in partition.
+func replayMessage(t *testing.T, env kafka.Envelope, partition int, offset int64) kafkago.Message {
+	msg, err := kafka.EncodeMessage(env)
+	require.NoError(t, err)
+	msg.Topic, msg.Partition, msg.Offset = "orders", partition, offset
+	return msg
+}
+
+// openFakePartitions returns an open func for Replayer.Replay serving msgs by partition.
+func openFakePartitions(msgs map[int][]kafkago.Message) func(kafka.PartitionProgress) (kafka.PartitionReader, error) {
+	return func(p kafka.PartitionProgress) (kafka.PartitionReader, error) {
+		return &fakePartitionReader{msgs: msgs[p.Partition]}, nil
+	}
+}
+
+func newReplayMocks(ctrl *gomock.Controller) (*postgres.MockPostgresDB, *cache.MockMemCacheClient, *logger.MockLogger) {
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockCache.EXPECT().Get(gomock.Any()).Return(nil, memcache.ErrCacheMiss).AnyTimes()
+	mockCache.EXPECT().Set(gomock.Any()).Return(nil).AnyTimes()
+	mockCache.EXPECT().Delete(gomock.Any()).Return(memcache.ErrCacheMiss).AnyTimes()
+
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Info(gomock.Any()).AnyTimes()
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	return postgres.NewMockPostgresDB(ctrl), mockCache, mockLogger
+}
+
+func TestParseReplayOptions(t *testing.T) {
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		partitions string
+		offsets    string
+		since      string
+		want       kafka.ReplayOptions
+		err        string
+	}{
+		{name: "defaults"},
+		{name: "partitions", partitions: " 0, 2,", want: kafka.ReplayOptions{Partitions: []int{0, 2}}},
+		{name: "one offset for every partition", partitions: "0,1", offsets: "120",
+			want: kafka.ReplayOptions{Partitions: []int{0, 1}, Offsets: map[int]int64{0: 120, 1: 120}}},
+		{name: "offset per partition", offsets: "3=120, 1=80",
+			want: kafka.ReplayOptions{Partitions: []int{1, 3}, Offsets: map[int]int64{3: 120, 1: 80}}},
+		{name: "offset per listed partition", partitions: "1,2,3", offsets: "3=120",
+			want: kafka.ReplayOptions{Partitions: []int{1, 2, 3}, Offsets: map[int]int64{3: 120}}},
+		{name: "since", since: "2024-05-01T00:00:00Z", want: kafka.ReplayOptions{Since: since}},
+		{name: "single offset without partitions", offsets: "120", err: "a single -offset needs -partitions"},
+		{name: "negative partition", partitions: "0,-1", err: `invalid partition "-1"`},
+		{name: "partition not a number", partitions: "a", err: `invalid partition "a"`},
+		{name: "negative offset", partitions: "0", offsets: "-5", err: `invalid offset "-5"`},
+		{name: "pair without offset", offsets: "0=120,1", err: `invalid partition offset "1"`},
+		{name: "pair with a bad offset", offsets: "0=x", err: `invalid partition offset "0=x"`},
+		{name: "since without a zone", since: "2024-05-01 00:00:00", err: `invalid time "2024-05-01 00:00:00"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := kafka.ParseReplayOptions(tt.partitions, tt.offsets, tt.since)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, opts)
+		})
+	}
+}
+
+func TestReplayer_CountsOutcomes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	gen := generatortest.New(t)
+	mockDB, mockCache, mockLogger := newReplayMocks(ctrl)
+
+	valid, duplicate, invalid := gen.Order(), gen.Order(), gen.InvalidOrder(generator.DefectBadCurrency)
+	events := map[string]kafka.Envelope{}
+	for name, order := range map[string]*models.Order{"valid": &valid, "duplicate": &duplicate, "invalid": &invalid} {
+		env, err := kafka.NewOrderEvent(kafka.EventOrderCreated, "test-producer", order)
+		require.NoError(t, err)
+		events[name] = env
+	}
+	delivery, err := kafka.NewEnvelope(kafka.EventOrderDeliveryChanged, "test-producer", valid.OrderUid,
+		models.DeliveryChange{OrderUid: valid.OrderUid, Delivery: valid.Delivery})
+	require.NoError(t, err)
+
+	mockDB.EXPECT().CreateOrder(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, event models.OrderEvent, order *models.Order) (bool, error) {
+		assert.False(t, event.Reapply)
+		switch order.OrderUid {
+		case duplicate.OrderUid:
+			return false, postgres.ErrDuplicateMessage
+		case invalid.OrderUid:
+			return false, validation.Default.Validate(order)
+		}
+		return true, nil
+	}).Times(3)
+	mockDB.EXPECT().UpdateDelivery(gomock.Any(), gomock.Any(), gomock.Any()).Return(0, errors.New("connection reset"))
+
+	undecodable := kafkago.Message{Topic: "orders", Partition: 0, Offset: 12, Value: []byte("not json")}
+	msgs := map[int][]kafkago.Message{
+		0: {replayMessage(t, events["valid"], 0, 10), replayMessage(t, events["duplicate"], 0, 11), undecodable},
+		1: {replayMessage(t, events["invalid"], 1, 0), replayMessage(t, delivery, 1, 1)},
+	}
+	partitions := []kafka.PartitionProgress{
+		{Partition: 0, Start: 10, Next: 10, End: 13},
+		{Partition: 1, Start: 0, Next: 0, End: 2},
+		// Empty partitions aren't read.
+		{Partition: 2, Start: 5, Next: 5, End: 5},
+	}
+
+	replayer := kafka.NewReplayer(config.AppConfig{}, mockDB, mockLogger, mockCache, testOptions(), kafka.ReplayOptions{})
+	require.NoError(t, replayer.Replay(context.Background(), partitions, openFakePartitions(msgs)))
+
+	progress := replayer.Progress()
+	assert.Equal(t, 1, progress.Processed)
+	assert.Equal(t, 1, progress.Duplicates)
+	assert.Equal(t, 1, progress.Invalid)
+	assert.Equal(t, 2, progress.Failed)
+	assert.Len(t, progress.Errors, 3)
+	assert.Equal(t, []kafka.PartitionProgress{
+		{Partition: 0, Start: 10, Next: 13, End: 13},
+		{Partition: 1, Start: 0, Next: 2, End: 2},
+		{Partition: 2, Start: 5, Next: 5, End: 5},
+	}, progress.Partitions)
+}
+
+func TestReplayer_WritesPartitionsConcurrently(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	gen := generatortest.New(t)
+	mockDB, mockCache, mockLogger := newReplayMocks(ctrl)
+
+	const partitionCount, perPartition = 4, 25
+	msgs := map[int][]kafkago.Message{}
+	var partitions []kafka.PartitionProgress
+	for p := 0; p < partitionCount; p++ {
+		for offset := int64(0); offset < perPartition; offset++ {
+			order := gen.Order()
+			env, err := kafka.NewOrderEvent(kafka.EventOrderCreated, "test-producer", &order)
+			require.NoError(t, err)
+			msgs[p] = append(msgs[p], replayMessage(t, env, p, offset))
+		}
+		partitions = append(partitions, kafka.PartitionProgress{Partition: p, End: perPartition})
+	}
+
+	// The pipeline isn't safe for concurrent use, so calls must not overlap.
+	var active int32
+	mockDB.EXPECT().CreateOrder(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, event models.OrderEvent, order *models.Order) (bool, error) {
+		assert.Equal(t, int32(1), atomic.AddInt32(&active, 1))
+		defer atomic.AddInt32(&active, -1)
+		time.Sleep(100 * time.Microsecond)
+		return true, nil
+	}).Times(partitionCount * perPartition)
+
+	replayer := kafka.NewReplayer(config.AppConfig{}, mockDB, mockLogger, mockCache, testOptions(), kafka.ReplayOptions{})
+	require.NoError(t, replayer.Replay(context.Background(), partitions, openFakePartitions(msgs)))
+
+	progress := replayer.Progress()
+	assert.Equal(t, partitionCount*perPartition, progress.Processed)
+	for _, p := range progress.Partitions {
+		assert.Equal(t, p.End, p.Next, "partition %d", p.Partition)
+	}
+}
+
+func TestReplayer_IgnoreLedgerReappliesWithoutRecording(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	gen := generatortest.New(t)
+	mockDB, mockCache, mockLogger := newReplayMocks(ctrl)
+
+	order := gen.Order()
+	env, err := kafka.NewOrderEvent(kafka.EventOrderCreated, "test-producer", &order)
+	require.NoError(t, err)
+
+	// The key is kept so the DB can tell the event was applied before and
+	// skip the history and the outbox.
+	mockDB.EXPECT().CreateOrder(gomock.Any(), gomock.Any(), gomock.Any()).Do(func(ctx context.Context, event models.OrderEvent, order *models.Order) {
+		assert.True(t, event.Reapply)
+		assert.Equal(t, env.IdempotencyKey, event.IdempotencyKey)
+	}).Return(true, nil)
+
+	replayer := kafka.NewReplayer(config.AppConfig{}, mockDB, mockLogger, mockCache, testOptions(), kafka.ReplayOptions{IgnoreLedger: true})
+	msgs := map[int][]kafkago.Message{0: {replayMessage(t, env, 0, 7)}}
+	require.NoError(t, replayer.Replay(context.Background(), []kafka.PartitionProgress{{Partition: 0, Start: 7, Next: 7, End: 8}}, openFakePartitions(msgs)))
+	assert.Equal(t, 1, replayer.Progress().Processed)
+}
+
+func TestReplayer_StopsOnCancel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB, mockCache, mockLogger := newReplayMocks(ctrl)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	// The reader has nothing before End, so only the cancellation ends the replay.
+	replayer := kafka.NewReplayer(config.AppConfig{}, mockDB, mockLogger, mockCache, testOptions(), kafka.ReplayOptions{})
+	err := replayer.Replay(ctx, []kafka.PartitionProgress{{Partition: 0, End: 3}}, openFakePartitions(nil))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, replayer.Progress().Partitions[0].Next)
+}
@@ -0,0 +1,198 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"wb-kafka-service/internal/analytics"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/handlers"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/pkg/breaker"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func reportRequest(report, query string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/reports/"+report+query, nil)
+	r.SetPathValue("report", report)
+	return r
+}
+
+// fakeMemcache expects Get and Set calls backed by a map.
+func fakeMemcache(mockCache *cache.MockMemCacheClient) map[string]*memcache.Item {
+	items := make(map[string]*memcache.Item)
+	mockCache.EXPECT().Get(gomock.Any()).DoAndReturn(func(key string) (*memcache.Item, error) {
+		if item, ok := items[key]; ok {
+			return item, nil
+		}
+		return nil, memcache.ErrCacheMiss
+	}).AnyTimes()
+	mockCache.EXPECT().Set(gomock.Any()).DoAndReturn(func(item *memcache.Item) error {
+		items[item.Key] = item
+		return nil
+	}).AnyTimes()
+	return items
+}
+
+func TestHandlerReport_CachesResults(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+	items := fakeMemcache(mockCache)
+
+	rows := []models.RevenueRow{
+		{Day: "2024-05-01", Currency: "RUB", Provider: "wbpay", Orders: 2, Revenue: 3000},
+		{Day: "2024-05-02", Currency: "USD", Provider: "wbpay", Orders: 1, Revenue: 40},
+	}
+	// May 1st to May 2nd inclusive ends before May 3rd.
+	mockDB.EXPECT().RevenueByDay(gomock.Any(), postgres.ReportRange{From: "2024-05-01", To: "2024-05-03"}).Return(rows, nil).Times(1)
+
+	reports := analytics.NewReports(mockLogger, mockDB, mockCache, time.Minute)
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handlers.HandlerReport(mockLogger, reports, w, reportRequest("revenue", "?from=2024-05-01&to=2024-05-02"))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var result analytics.Result[analytics.RevenueTable]
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		assert.Equal(t, analytics.RevenueTable(rows), result.Rows)
+		assert.False(t, result.GeneratedAt.IsZero())
+	}
+
+	item := items[cache.ReportKey(analytics.ReportRevenue, "2024-05-01", "2024-05-03")]
+	require.NotNil(t, item)
+	assert.Equal(t, int32(60), item.Expiration)
+}
+
+func TestHandlerReport_CSV(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+	fakeMemcache(mockCache)
+
+	mockDB.EXPECT().TopProducts(gomock.Any(), postgres.ReportRange{}, postgres.ProductsByNmID, postgres.SortByQuantity, 2).Return([]models.ProductRow{
+		{Key: "2389212", Currency: "RUB", Quantity: 7, Revenue: 2100},
+		{Key: "1111", Currency: "RUB", Quantity: 3, Revenue: 900},
+		{Key: "1111", Currency: "USD", Quantity: 1, Revenue: 12},
+	}, nil)
+	mockDB.EXPECT().SaleDistribution(gomock.Any(), postgres.ReportRange{}, 25).Return([]models.SaleBucket{
+		{SaleFrom: 25, SaleTo: 49, Items: 4, Revenue: 1000},
+	}, nil)
+	mockDB.EXPECT().DeliveryCosts(gomock.Any(), postgres.ReportRange{}).Return(nil, nil)
+
+	reports := analytics.NewReports(mockLogger, mockDB, mockCache, time.Minute)
+
+	w := httptest.NewRecorder()
+	handlers.HandlerReport(mockLogger, reports, w, reportRequest("top-products", "?by=nm_id&sort=quantity&limit=2&format=csv"))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "key,currency,quantity,revenue\n2389212,RUB,7,2100\n1111,RUB,3,900\n1111,USD,1,12\n", w.Body.String())
+
+	r := reportRequest("sale-distribution", "?width=25")
+	r.Header.Set("Accept", "text/csv")
+	w = httptest.NewRecorder()
+	handlers.HandlerReport(mockLogger, reports, w, r)
+	assert.Equal(t, "sale_from,sale_to,items,revenue\n25,49,4,1000\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	handlers.HandlerReport(mockLogger, reports, w, reportRequest("delivery-costs", ""))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"rows":[]`)
+}
+
+func TestHandlerReport_Errors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+	fakeMemcache(mockCache)
+
+	reports := analytics.NewReports(mockLogger, mockDB, mockCache, time.Minute)
+
+	for _, tc := range []struct {
+		report, query string
+		status        int
+	}{
+		{"unknown", "", http.StatusNotFound},
+		{"revenue", "?format=xml", http.StatusBadRequest},
+		{"revenue", "?from=May", http.StatusBadRequest},
+		{"revenue", "?from=2024-05-02&to=2024-05-01", http.StatusBadRequest},
+		{"top-products", "?by=color", http.StatusBadRequest},
+		{"top-products", "?limit=1000", http.StatusBadRequest},
+		{"sale-distribution", "?width=0", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		handlers.HandlerReport(mockLogger, reports, w, reportRequest(tc.report, tc.query))
+		assert.Equal(t, tc.status, w.Code, tc.report+tc.query)
+	}
+
+	mockDB.EXPECT().RevenueByDay(gomock.Any(), gomock.Any()).Return(nil, breaker.ErrOpen)
+	w := httptest.NewRecorder()
+	handlers.HandlerReport(mockLogger, reports, w, reportRequest("revenue", ""))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestReports_CoalescesConcurrentMisses(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	// No logger calls are expected: the open breaker is not logged per request.
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	memcacheBreaker := breaker.New("memcached", breaker.Settings{FailureThreshold: 1, Cooldown: time.Minute, IsFailure: cache.IsFailure})
+	memcacheBreaker.Do(func() error { return errors.New("memcache down") })
+
+	const callers = 5
+	rows := []models.RevenueRow{{Day: "2024-05-01", Currency: "RUB", Provider: "wbpay", Orders: 2, Revenue: 3000}}
+	release := make(chan struct{})
+	mockDB.EXPECT().RevenueByDay(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, rng postgres.ReportRange) ([]models.RevenueRow, error) {
+		<-release
+		return rows, nil
+	}).Times(1)
+
+	reports := analytics.NewReports(mockLogger, mockDB, cache.NewBreakerMemCache(mockCache, memcacheBreaker), time.Minute)
+	rng := postgres.ReportRange{From: "2024-05-01", To: "2024-05-02"}
+
+	var wg sync.WaitGroup
+	var started atomic.Int32
+	results := make([]analytics.Result[analytics.RevenueTable], callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			started.Add(1)
+			result, err := reports.Revenue(context.Background(), rng)
+			assert.NoError(t, err)
+			results[i] = result
+		}(i)
+	}
+	// Give every caller time to join the computation before it ends.
+	require.Eventually(t, func() bool { return started.Load() == callers }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for _, result := range results {
+		assert.Equal(t, analytics.RevenueTable(rows), result.Rows)
+		assert.Equal(t, results[0].GeneratedAt, result.GeneratedAt)
+	}
+}
@@ -0,0 +1,257 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/handlers"
+	"wb-kafka-service/internal/kafka"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/internal/stream"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func streamConfig() config.AppConfig {
+	var cfg config.AppConfig
+	cfg.Stream.ClientBuffer = 2
+	cfg.Stream.Heartbeat = time.Minute
+	return cfg
+}
+
+func TestHub_FiltersAndEvictsSlowSubscribers(t *testing.T) {
+	hub := stream.NewHub()
+	all := hub.Subscribe(stream.Filter{}, 2)
+	wbil := hub.Subscribe(stream.Filter{DeliveryService: "wbil"}, 2)
+
+	orders := materialOrders(t)
+	for i := range orders[:3] {
+		orders[i].DeliveryService = "meest"
+	}
+	orders[1].DeliveryService = "wbil"
+	for i := range orders[:3] {
+		hub.Publish(&orders[i])
+	}
+
+	// all had room for two of three events.
+	<-all.Evicted
+	assert.Len(t, all.C, 2)
+
+	require.Len(t, wbil.C, 1)
+	event := <-wbil.C
+	assert.Equal(t, orders[1].OrderUid, event.OrderUid)
+	assert.Equal(t, uint64(2), event.Seq)
+	assert.Equal(t, orders[1].Payment.Amount, event.Amount)
+	assert.Equal(t, len(orders[1].Items), event.Items)
+
+	assert.Equal(t, stream.Stats{Subscribers: 1, Published: 3, Evicted: 1}, hub.Stats())
+	hub.Unsubscribe(all)
+	hub.Unsubscribe(wbil)
+	assert.Equal(t, 0, hub.Stats().Subscribers)
+}
+
+func TestHandlerOrderStream_ServerSentEvents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	hub := stream.NewHub()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerOrderStream(mockLogger, hub, streamConfig(), w, r)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/orders/stream?customer_id=test")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, "retry: 3000\n: connected\n", readEvent())
+
+	orders := materialOrders(t)
+	orders[0].CustomerID = "other"
+	orders[1].CustomerID = "test"
+	orders[1].ID = 12
+	hub.Publish(&orders[0])
+	hub.Publish(&orders[1])
+
+	lines := strings.Split(readEvent(), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "id: 2", lines[0])
+	assert.Equal(t, "event: order", lines[1])
+
+	var event stream.OrderEvent
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &event))
+	assert.Equal(t, 12, event.ID)
+	assert.Equal(t, orders[1].OrderUid, event.OrderUid)
+}
+
+func TestHandlerOrderStream_WebSocket(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	hub := stream.NewHub()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerOrderStream(mockLogger, hub, streamConfig(), w, r)
+	}))
+	defer server.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/orders/stream", "", server.URL)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	require.Eventually(t, func() bool { return hub.Stats().Subscribers == 1 }, time.Second, 10*time.Millisecond)
+	order := materialOrders(t)[0]
+	hub.Publish(&order)
+
+	var message handlers.StreamMessage
+	require.NoError(t, websocket.JSON.Receive(ws, &message))
+	assert.Equal(t, "order", message.Type)
+	require.NotNil(t, message.Order)
+	assert.Equal(t, order.OrderUid, message.Order.OrderUid)
+
+	ws.Close()
+	require.Eventually(t, func() bool { return hub.Stats().Subscribers == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestHandlerOrderStream_WebSocketChecksOrigin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockLogger := logger.NewMockLogger(ctrl)
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any())
+
+	cfg := streamConfig()
+	cfg.Stream.AllowedOrigins = []string{"https://support.example.com/"}
+	hub := stream.NewHub()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlerOrderStream(mockLogger, hub, cfg, w, r)
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/orders/stream"
+
+	_, err := websocket.Dial(url, "", "https://evil.example.com")
+	var dialErr *websocket.DialError
+	require.ErrorAs(t, err, &dialErr)
+	assert.ErrorIs(t, dialErr.Err, websocket.ErrBadStatus)
+
+	for _, origin := range []string{server.URL, "https://support.example.com"} {
+		ws, err := websocket.Dial(url, "", origin)
+		require.NoError(t, err, origin)
+		ws.Close()
+	}
+}
+
+func TestHandleEvent_PublishesStoredOrders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	order := materialOrders(t)[0]
+	created, err := kafka.NewOrderEvent(kafka.EventOrderCreated, "test-producer", &order)
+	require.NoError(t, err)
+
+	mockDB.EXPECT().CreateOrder(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, event models.OrderEvent, o *models.Order) (bool, error) {
+		o.ID = 99
+		return true, nil
+	})
+	mockCache.EXPECT().Delete(gomock.Any()).Return(memcache.ErrCacheMiss).AnyTimes()
+	mockCache.EXPECT().Set(gomock.Any()).Return(nil).AnyTimes()
+	mockLogger.EXPECT().Info(gomock.Any()).AnyTimes()
+
+	sub := kafka.Feed.Subscribe(stream.Filter{CustomerID: order.CustomerID}, 1)
+	defer kafka.Feed.Unsubscribe(sub)
+
+	require.NoError(t, kafka.HandleEvent(context.Background(), mockDB, mockLogger, mockCache, testOptions(), created))
+	require.Len(t, sub.C, 1)
+	event := <-sub.C
+	assert.Equal(t, 99, event.ID)
+	assert.Equal(t, order.OrderUid, event.OrderUid)
+}
+
+func TestHandleEvent_PublishesOrdersTheCacheRejects(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	order := materialOrders(t)[0]
+	order.CustomerID = "cache-down"
+	created, err := kafka.NewOrderEvent(kafka.EventOrderCreated, "test-producer", &order)
+	require.NoError(t, err)
+
+	// The order is stored, so cache errors are only warnings.
+	mockDB.EXPECT().CreateOrder(gomock.Any(), gomock.Any(), gomock.Any()).Return(true, nil)
+	mockCache.EXPECT().Delete(gomock.Any()).Return(errors.New("memcache: connection refused")).AnyTimes()
+	mockCache.EXPECT().Set(gomock.Any()).Return(errors.New("memcache: connection refused")).AnyTimes()
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).Times(2)
+
+	sub := kafka.Feed.Subscribe(stream.Filter{CustomerID: order.CustomerID}, 1)
+	defer kafka.Feed.Unsubscribe(sub)
+
+	require.NoError(t, kafka.HandleEvent(context.Background(), mockDB, mockLogger, mockCache, testOptions(), created))
+	require.Len(t, sub.C, 1)
+	assert.Equal(t, order.OrderUid, (<-sub.C).OrderUid)
+}
+
+func TestHandleEvent_KeepsExistingOrders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	order := materialOrders(t)[0]
+	order.CustomerID = "redelivered"
+	created, err := kafka.NewOrderEvent(kafka.EventOrderCreated, "test-producer", &order)
+	require.NoError(t, err)
+
+	// The DB kept the stored order, so the incoming payload must not reach
+	// subscribers or the cache; only the cached copy is dropped.
+	mockDB.EXPECT().CreateOrder(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, event models.OrderEvent, o *models.Order) (bool, error) {
+		o.ID = 42
+		return false, nil
+	})
+	mockCache.EXPECT().Delete(cache.OrderKey(42)).Return(nil)
+	mockCache.EXPECT().Delete(gomock.Any()).Return(memcache.ErrCacheMiss).AnyTimes()
+	mockLogger.EXPECT().Info(gomock.Any()).AnyTimes()
+
+	sub := kafka.Feed.Subscribe(stream.Filter{CustomerID: order.CustomerID}, 1)
+	defer kafka.Feed.Unsubscribe(sub)
+
+	require.NoError(t, kafka.HandleEvent(context.Background(), mockDB, mockLogger, mockCache, testOptions(), created))
+	assert.Empty(t, sub.C)
+}
@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"testing"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/pkg/generator"
+	"wb-kafka-service/pkg/generator/generatortest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderValidator_AcceptsValidOrders(t *testing.T) {
+	for _, order := range materialOrders(t) {
+		// materials/incorrect.json has no track_number on purpose.
+		if order.TrackNumber == "" {
+			assert.Error(t, validation.Default.Validate(&order), order.OrderUid)
+			continue
+		}
+		assert.NoError(t, validation.Default.Validate(&order), order.OrderUid)
+	}
+
+	gen := generatortest.New(t)
+	for _, order := range gen.Orders(100) {
+		assert.NoError(t, validation.Default.Validate(&order))
+	}
+}
+
+func TestOrderValidator_EveryDefectIsReported(t *testing.T) {
+	gen := generatortest.New(t)
+	tests := []struct {
+		defect generator.Defect
+		rule   string
+		field  string
+	}{
+		{generator.DefectBadEmail, "email", "delivery.email"},
+		{generator.DefectTotalsMismatch, validation.RuleGoodsTotal, "payment.goods_total"},
+		{generator.DefectTrackMismatch, validation.RuleTrackNumber, ""},
+		{generator.DefectBadCurrency, validation.RuleCurrency, "payment.currency"},
+		{generator.DefectBadLocale, validation.RuleLocale, "locale"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.defect), func(t *testing.T) {
+			order := gen.InvalidOrder(tt.defect)
+			violations := validation.Violations(validation.Default.Validate(&order))
+			require.NotEmpty(t, violations)
+
+			found := false
+			for _, v := range violations {
+				if v.Rule == tt.rule && (tt.field == "" || v.Field == tt.field) {
+					found = true
+				}
+			}
+			assert.True(t, found, "no %s violation in %+v", tt.rule, violations)
+		})
+	}
+
+	for i := 0; i < 20; i++ {
+		order := gen.InvalidOrder(generator.DefectMissingField)
+		assert.Error(t, validation.Default.Validate(&order))
+	}
+}
+
+func TestOrderValidator_ListsAllViolations(t *testing.T) {
+	gen := generatortest.New(t)
+	order := gen.InvalidOrder(generator.DefectBadEmail, generator.DefectBadCurrency, generator.DefectBadLocale)
+	order.Payment.Amount++
+
+	violations := validation.Violations(validation.Default.Validate(&order))
+
+	var fields []string
+	for _, v := range violations {
+		fields = append(fields, v.Field)
+	}
+	assert.ElementsMatch(t, []string{"delivery.email", "payment.currency", "locale", "payment.amount"}, fields)
+}
@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wb-kafka-service/internal/cache"
+	"wb-kafka-service/internal/handlers"
+	"wb-kafka-service/internal/web"
+	"wb-kafka-service/pkg/generator/generatortest"
+	"wb-kafka-service/pkg/logger"
+	"wb-kafka-service/pkg/postgres"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerOrder_RendersOrderPage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCache := cache.NewMockMemCacheClient(ctrl)
+	mockDB := postgres.NewMockPostgresDB(ctrl)
+	mockLogger := logger.NewMockLogger(ctrl)
+
+	order := generatortest.New(t).Order()
+	order.ID = 7
+	order.Locale = "en"
+	order.Payment.Currency = "RUB"
+	order.Payment.PaymentDT = 1637910000
+	order.DateCreated = "2021-11-26T06:22:19Z"
+	order.Items = order.Items[:1]
+	order.Items[0].ID = 42
+	order.Items[0].Name = "<script>alert(1)</script>"
+	order.Items[0].TotalPrice = 1250000
+	order.Payment.GoodsTotal = 1250000
+	order.Payment.Amount = order.Payment.GoodsTotal + order.Payment.DeliveryCost + order.Payment.CustomFee
+
+	mockCache.EXPECT().Get(gomock.Any()).Return(nil, memcache.ErrCacheMiss).AnyTimes()
+	mockCache.EXPECT().Set(gomock.Any()).Return(nil)
+	mockDB.EXPECT().GetOrderFromDB(gomock.Any(), 7).Return(&order, nil)
+
+	loader := cache.NewOrderLoader(testOptions(), mockCache, mockDB, mockLogger)
+	renderer, err := web.NewRenderer(mockLogger, "")
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handlers.HandlerOrder(mockLogger, loader, renderer, w, httptest.NewRequest(http.MethodGet, "/order?id=7", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Contains(t, body, `<link rel="stylesheet" href="/static/style.css">`)
+	assert.Contains(t, body, `value="7"`)
+	assert.Contains(t, body, "26 Nov 2021, 06:22 UTC")
+	assert.Contains(t, body, "26 Nov 2021, 07:00 UTC")
+	assert.Contains(t, body, "1,250,000 RUB")
+	assert.Contains(t, body, "<td>1</td>")
+	assert.Contains(t, body, "&lt;script&gt;")
+	assert.NotContains(t, body, "<script>")
+
+	// Items are numbered in the template, not by rewriting the loaded order.
+	assert.Equal(t, 42, order.Items[0].ID)
+}
+
+func TestRenderer_StaticAndIndex(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLogger := logger.NewMockLogger(ctrl)
+	renderer, err := web.NewRenderer(mockLogger, "")
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	renderer.StaticHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/static/style.css", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/css")
+
+	w = httptest.NewRecorder()
+	handlers.HandlerIndex(mockLogger, renderer, w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `action="/order"`)
+
+	w = httptest.NewRecorder()
+	handlers.HandlerIndex(mockLogger, renderer, w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	handlers.HandlerLive(mockLogger, renderer, w, httptest.NewRequest(http.MethodGet, "/live?delivery_service=meest", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `name="delivery_service" placeholder="Delivery Service" value="meest"`)
+	assert.Contains(t, w.Body.String(), `<script src="/static/live.js"></script>`)
+}
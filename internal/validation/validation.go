@@ -0,0 +1,192 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"wb-kafka-service/internal/models"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Violation is one broken rule. Field is the JSON path of the offending value,
+// e.g. "payment.goods_total" or "items[1].track_number".
+type Violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error lists every rule an order breaks.
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		parts[i] = v.Field + ": " + v.Message
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+// Details lets the logger record the violations as structured data.
+func (e *Error) Details() any {
+	return e.Violations
+}
+
+// Violations returns the violations carried by err, or nil if err is not a validation error.
+func Violations(err error) []Violation {
+	var verr *Error
+	if errors.As(err, &verr) {
+		return verr.Violations
+	}
+	return nil
+}
+
+// Rules checked on top of the struct tags.
+const (
+	RuleGoodsTotal  = "goods_total_sum"
+	RuleAmount      = "amount_sum"
+	RuleTrackNumber = "track_number_match"
+	RuleCurrency    = "iso4217"
+	RuleLocale      = "locale"
+)
+
+// OrderValidator checks orders against their struct tags and the business
+// rules that relate fields to each other. It is safe for concurrent use.
+type OrderValidator struct {
+	validate *validator.Validate
+	locales  map[string]bool
+}
+
+// NewOrderValidator returns a validator accepting KnownLocales.
+func NewOrderValidator() *OrderValidator {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+
+	locales := make(map[string]bool, len(KnownLocales))
+	for _, locale := range KnownLocales {
+		locales[locale] = true
+	}
+
+	return &OrderValidator{validate: validate, locales: locales}
+}
+
+// Default is shared by every package that validates orders.
+var Default = NewOrderValidator()
+
+// Validate returns an *Error listing every violated rule, or nil.
+func (v *OrderValidator) Validate(order *models.Order) error {
+	violations := v.tagViolations(order)
+
+	goodsTotal := 0
+	for i, item := range order.Items {
+		goodsTotal += item.TotalPrice
+		if item.TrackNumber != "" && order.TrackNumber != "" && item.TrackNumber != order.TrackNumber {
+			violations = append(violations, Violation{
+				Field:   fmt.Sprintf("items[%d].track_number", i),
+				Rule:    RuleTrackNumber,
+				Message: fmt.Sprintf("must match the order track_number %q, got %q", order.TrackNumber, item.TrackNumber),
+			})
+		}
+	}
+
+	p := order.Payment
+	if p.GoodsTotal != goodsTotal {
+		violations = append(violations, Violation{
+			Field:   "payment.goods_total",
+			Rule:    RuleGoodsTotal,
+			Message: fmt.Sprintf("must equal the sum of items total_price %d, got %d", goodsTotal, p.GoodsTotal),
+		})
+	}
+	if want := p.GoodsTotal + p.DeliveryCost + p.CustomFee; p.Amount != want {
+		violations = append(violations, Violation{
+			Field:   "payment.amount",
+			Rule:    RuleAmount,
+			Message: fmt.Sprintf("must equal goods_total + delivery_cost + custom_fee = %d, got %d", want, p.Amount),
+		})
+	}
+
+	if p.Currency != "" && !isoCurrencies[p.Currency] {
+		violations = append(violations, Violation{
+			Field:   "payment.currency",
+			Rule:    RuleCurrency,
+			Message: fmt.Sprintf("must be an ISO 4217 currency code, got %q", p.Currency),
+		})
+	}
+	if order.Locale != "" && !v.locales[order.Locale] {
+		violations = append(violations, Violation{
+			Field:   "locale",
+			Rule:    RuleLocale,
+			Message: fmt.Sprintf("must be one of %s, got %q", strings.Join(KnownLocales, ", "), order.Locale),
+		})
+	}
+
+	if len(violations) > 0 {
+		return &Error{Violations: violations}
+	}
+	return nil
+}
+
+// ValidateStruct checks only the struct tags of s, e.g. a models.DeliveryChange.
+func (v *OrderValidator) ValidateStruct(s any) error {
+	violations := v.tagViolations(s)
+	if len(violations) > 0 {
+		return &Error{Violations: violations}
+	}
+	return nil
+}
+
+func (v *OrderValidator) tagViolations(s any) []Violation {
+	err := v.validate.Struct(s)
+	if err == nil {
+		return nil
+	}
+
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return []Violation{{Rule: "invalid", Message: err.Error()}}
+	}
+
+	violations := make([]Violation, 0, len(fieldErrors))
+	for _, fe := range fieldErrors {
+		violations = append(violations, Violation{
+			Field:   fieldPath(fe.Namespace()),
+			Rule:    fe.Tag(),
+			Message: tagMessage(fe),
+		})
+	}
+	return violations
+}
+
+// fieldPath drops the root struct name from a validator namespace such as
+// "Order.payment.goods_total".
+func fieldPath(namespace string) string {
+	_, path, found := strings.Cut(namespace, ".")
+	if !found {
+		return namespace
+	}
+	return path
+}
+
+func tagMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return fmt.Sprintf("must be an email address, got %q", fe.Value())
+	default:
+		return fmt.Sprintf("failed the %q rule", fe.Tag())
+	}
+}
@@ -0,0 +1,59 @@
+// Shows the orders of /orders/stream, newest first, with the filters of the page.
+(function () {
+  var maxRows = 200;
+  var status = document.getElementById("live-status");
+  var table = document.getElementById("live-orders");
+  var lang = table.dataset.lang;
+
+  function setStatus(name) {
+    status.textContent = status.dataset[name];
+  }
+
+  function cell(row, text, className) {
+    var td = row.insertCell();
+    td.textContent = text;
+    if (className) {
+      td.className = className;
+    }
+    return td;
+  }
+
+  function addOrder(order) {
+    var row = table.insertRow(1);
+    cell(row, new Date(order.stored_at).toLocaleTimeString(lang));
+
+    var link = document.createElement("a");
+    link.href = "/order?id=" + encodeURIComponent(order.id) + "&lang=" + encodeURIComponent(lang);
+    link.textContent = order.id;
+    cell(row, "").appendChild(link);
+
+    cell(row, order.track_number);
+    cell(row, order.customer_id);
+    cell(row, order.delivery_service);
+    cell(row, order.city);
+    cell(row, order.items.toLocaleString(lang), "number");
+    cell(row, order.amount.toLocaleString(lang) + " " + order.currency, "number");
+
+    while (table.rows.length > maxRows + 1) {
+      table.deleteRow(table.rows.length - 1);
+    }
+  }
+
+  var params = new URLSearchParams(window.location.search);
+  var query = new URLSearchParams();
+  // EventSource can't send headers, so a token in the page URL is passed on.
+  ["customer_id", "delivery_service", "access_token"].forEach(function (name) {
+    if (params.get(name)) {
+      query.set(name, params.get(name));
+    }
+  });
+
+  var source = new EventSource("/orders/stream?" + query.toString());
+  source.onopen = function () { setStatus("connected"); };
+  source.onerror = function () { setStatus("disconnected"); };
+  source.addEventListener("order", function (e) { addOrder(JSON.parse(e.data)); });
+  source.addEventListener("evicted", function () {
+    source.close();
+    setStatus("evicted");
+  });
+})();
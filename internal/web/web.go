@@ -0,0 +1,186 @@
+package web
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+
+	"wb-kafka-service/internal/i18n"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/internal/stream"
+	"wb-kafka-service/pkg/logger"
+)
+
+//go:embed templates static
+var files embed.FS
+
+// Pages that can be rendered. Each is parsed together with layout.html.
+const (
+	PageIndex = "index.html"
+	PageOrder = "order.html"
+	PageLive  = "live.html"
+)
+
+var pages = []string{PageIndex, PageOrder, PageLive}
+
+// PageData is passed to every page. Order is nil on the index page.
+type PageData struct {
+	// SearchID prefills the order search box in the header.
+	SearchID string
+	Order    *models.Order
+	// Filter prefills the filters of the live page.
+	Filter stream.Filter
+	// L translates messages and formats values in the negotiated language.
+	L *i18n.Localizer
+	// Lang is the language chosen with ?lang=, kept when searching; "" if the
+	// language was negotiated.
+	Lang      string
+	Languages []Language
+	// AccessToken is the access_token query parameter, kept by the filter
+	// form of the live page, which passes it on to the order stream.
+	AccessToken string
+}
+
+// Language is an entry of the language switcher.
+type Language struct {
+	Code string
+	Name string
+	URL  string
+}
+
+// Renderer renders the HTML pages. Templates are embedded in the binary and
+// parsed once; in dev mode they are read from disk and reparsed on every render,
+// so edits show up without a rebuild.
+type Renderer struct {
+	log logger.Logger
+	// dir is the directory holding templates/ and static/ in dev mode, or "".
+	dir       string
+	templates map[string]*template.Template
+	bundle    *i18n.Bundle
+}
+
+// NewRenderer parses the embedded templates. If devDir is not empty, templates
+// and static files are served from it instead, e.g. "../../internal/web".
+func NewRenderer(log logger.Logger, devDir string) (*Renderer, error) {
+	r := &Renderer{log: log, dir: devDir}
+
+	templates, err := parse(r.files())
+	if err != nil {
+		log.Error("Error parsing templates", err)
+		return nil, err
+	}
+	r.templates = templates
+
+	r.bundle, err = i18n.Load()
+	if err != nil {
+		log.Error("Error loading message catalogs", err)
+		return nil, err
+	}
+
+	if devDir != "" {
+		log.Info(fmt.Sprintf("Serving templates from %s", devDir))
+	}
+	return r, nil
+}
+
+// PageData returns the page data for r, with the language negotiated from the
+// request and fallbackLang, e.g. the order's locale.
+func (r *Renderer) PageData(req *http.Request, fallbackLang string) PageData {
+	data := PageData{
+		L:           r.bundle.Negotiate(req, fallbackLang),
+		Lang:        r.bundle.Match(req.URL.Query().Get("lang")),
+		AccessToken: req.URL.Query().Get("access_token"),
+	}
+
+	for _, lang := range r.bundle.Languages() {
+		u := *req.URL
+		query := u.Query()
+		query.Set("lang", lang)
+		u.RawQuery = query.Encode()
+		data.Languages = append(data.Languages, Language{
+			Code: lang,
+			Name: r.bundle.Localizer(lang).T("language.name"),
+			URL:  u.RequestURI(),
+		})
+	}
+	return data
+}
+
+// Render executes page with data. The page is rendered to a buffer first, so
+// a template error produces a 500 instead of a truncated page.
+func (r *Renderer) Render(w http.ResponseWriter, page string, data PageData) error {
+	templates, err := r.current()
+	if err != nil {
+		return err
+	}
+
+	tmpl, ok := templates[page]
+	if !ok {
+		return fmt.Errorf("unknown page %q", page)
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.ExecuteTemplate(&buf, "layout", data)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", data.L.Lang())
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// StaticHandler serves the files of the static directory; mount it under /static/.
+func (r *Renderer) StaticHandler() http.Handler {
+	static, err := fs.Sub(r.files(), "static")
+	if err != nil {
+		// The embedded tree always has static/; a dev directory may not.
+		r.log.Error("Error opening static files", err)
+		return http.NotFoundHandler()
+	}
+	return http.StripPrefix("/static/", http.FileServer(http.FS(static)))
+}
+
+func (r *Renderer) files() fs.FS {
+	if r.dir != "" {
+		return os.DirFS(r.dir)
+	}
+	return files
+}
+
+func (r *Renderer) current() (map[string]*template.Template, error) {
+	if r.dir == "" {
+		return r.templates, nil
+	}
+
+	templates, err := parse(r.files())
+	if err != nil {
+		r.log.Error("Error reloading templates", err)
+		return nil, err
+	}
+	return templates, nil
+}
+
+func parse(fsys fs.FS) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(pages))
+	for _, page := range pages {
+		tmpl, err := template.New(page).Funcs(funcs).ParseFS(fsys,
+			path.Join("templates", "layout.html"),
+			path.Join("templates", page))
+		if err != nil {
+			return nil, err
+		}
+		templates[page] = tmpl
+	}
+	return templates, nil
+}
+
+var funcs = template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}
@@ -0,0 +1,3 @@
+DROP TABLE order_events;
+DROP INDEX orders_order_uid_idx;
+ALTER TABLE orders DROP COLUMN status;
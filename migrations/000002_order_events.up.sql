@@ -0,0 +1,14 @@
+ALTER TABLE orders ADD COLUMN status VARCHAR(32) NOT NULL DEFAULT 'active';
+
+CREATE INDEX orders_order_uid_idx ON orders (order_uid);
+
+CREATE TABLE order_events (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER REFERENCES orders(id),
+    event_type VARCHAR(255),
+    idempotency_key VARCHAR(255),
+    payload JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX order_events_order_id_idx ON order_events (order_id, created_at);
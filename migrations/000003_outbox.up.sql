@@ -0,0 +1,13 @@
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    order_id INTEGER REFERENCES orders(id),
+    order_uid VARCHAR(255) NOT NULL,
+    event_type VARCHAR(255) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (id) WHERE sent_at IS NULL;
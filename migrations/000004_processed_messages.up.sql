@@ -0,0 +1,8 @@
+CREATE TABLE processed_messages (
+    idempotency_key VARCHAR(255) PRIMARY KEY,
+    event_type VARCHAR(255) NOT NULL,
+    order_uid VARCHAR(255) NOT NULL,
+    processed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX processed_messages_processed_at_idx ON processed_messages (processed_at);
@@ -0,0 +1,3 @@
+DROP INDEX items_track_number_idx;
+
+DROP INDEX orders_customer_id_date_created_idx;
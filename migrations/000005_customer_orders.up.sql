@@ -0,0 +1,3 @@
+CREATE INDEX orders_customer_id_date_created_idx ON orders (customer_id, date_created, id);
+
+CREATE INDEX items_track_number_idx ON items (track_number);
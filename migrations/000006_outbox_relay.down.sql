@@ -0,0 +1,3 @@
+DROP INDEX outbox_sent_at_idx;
+
+DROP INDEX outbox_pending_order_uid_idx;
@@ -0,0 +1,3 @@
+CREATE INDEX outbox_pending_order_uid_idx ON outbox (order_uid, id) WHERE sent_at IS NULL;
+
+CREATE INDEX outbox_sent_at_idx ON outbox (sent_at) WHERE sent_at IS NOT NULL;
@@ -0,0 +1,197 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed lets every call through and counts consecutive failures.
+	Closed State = iota
+	// Open rejects every call with ErrOpen until the cooldown has passed.
+	Open
+	// HalfOpen lets one probe call through; its outcome closes or reopens the breaker.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// ErrOpen is returned, without calling the dependency, while a breaker is open.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// Settings configure a Breaker.
+type Settings struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker.
+	FailureThreshold int
+	// Cooldown is how long the breaker stays open before a probe is let through.
+	Cooldown time.Duration
+	// IsFailure tells which errors count as failures of the dependency. By
+	// default every non-nil error does; errors such as "not found" should not.
+	IsFailure func(err error) bool
+	// OnStateChange is called, with the lock held, whenever the state changes.
+	OnStateChange func(name string, from, to State)
+	// Now returns the current time; time.Now by default.
+	Now func() time.Time
+}
+
+// Breaker is a circuit breaker around one dependency. It is safe for concurrent use.
+type Breaker struct {
+	name     string
+	settings Settings
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func New(name string, settings Settings) *Breaker {
+	if settings.FailureThreshold < 1 {
+		settings.FailureThreshold = 1
+	}
+	if settings.IsFailure == nil {
+		settings.IsFailure = func(err error) bool { return err != nil }
+	}
+	if settings.Now == nil {
+		settings.Now = time.Now
+	}
+	return &Breaker{name: name, settings: settings}
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State returns the current state. An open breaker whose cooldown has passed
+// is reported as half-open.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	return b.state
+}
+
+// IsOpen reports whether calls are currently rejected.
+func (b *Breaker) IsOpen() bool {
+	return b.State() == Open
+}
+
+// Do calls fn unless the breaker is open, and records its outcome. A panic in
+// fn is recorded as a failure and then propagates.
+func (b *Breaker) Do(fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	failed := true
+	defer func() { b.record(failed) }()
+	err := fn()
+	failed = err != nil && b.settings.IsFailure(err)
+	return err
+}
+
+// Begin admits a call like Do and returns record, which records the outcome
+// of the call. It suits long calls, such as a streamed read, that can report
+// once the dependency answered rather than when they end. record must be
+// called exactly once.
+func (b *Breaker) Begin() (record func(err error), err error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	return func(err error) {
+		b.record(err != nil && b.settings.IsFailure(err))
+	}, nil
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance()
+	switch b.state {
+	case Open:
+		return ErrOpen
+	case HalfOpen:
+		if b.probing {
+			return ErrOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *Breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case HalfOpen:
+		b.probing = false
+		if failed {
+			b.open()
+		} else {
+			b.failures = 0
+			b.setState(Closed)
+		}
+	case Closed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.settings.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+// advance moves an open breaker to half-open once the cooldown has passed.
+func (b *Breaker) advance() {
+	if b.state == Open && b.settings.Now().Sub(b.openedAt) >= b.settings.Cooldown {
+		b.setState(HalfOpen)
+	}
+}
+
+func (b *Breaker) open() {
+	b.openedAt = b.settings.Now()
+	b.failures = 0
+	b.setState(Open)
+}
+
+func (b *Breaker) setState(state State) {
+	if state == b.state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if b.settings.OnStateChange != nil {
+		b.settings.OnStateChange(b.name, from, state)
+	}
+}
+
+// Call is Do for functions that return a value.
+func Call[T any](b *Breaker, fn func() (T, error)) (T, error) {
+	var result T
+	err := b.Do(func() error {
+		var err error
+		result, err = fn()
+		return err
+	})
+	return result, err
+}
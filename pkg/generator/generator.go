@@ -0,0 +1,227 @@
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+	"wb-kafka-service/internal/models"
+)
+
+// Defect is a way to make a generated order invalid, for negative tests.
+type Defect string
+
+const (
+	// DefectMissingField clears one randomly chosen required field.
+	DefectMissingField Defect = "missing_field"
+	// DefectBadEmail puts a malformed address in delivery.email.
+	DefectBadEmail Defect = "bad_email"
+	// DefectTotalsMismatch makes payment.goods_total and amount disagree with the items.
+	DefectTotalsMismatch Defect = "totals_mismatch"
+	// DefectTrackMismatch gives one item a track number other than the order's.
+	DefectTrackMismatch Defect = "track_mismatch"
+	// DefectBadCurrency uses a currency code that isn't ISO 4217.
+	DefectBadCurrency Defect = "bad_currency"
+	// DefectBadLocale uses an unknown locale.
+	DefectBadLocale Defect = "bad_locale"
+)
+
+// Defects lists every supported defect.
+func Defects() []Defect {
+	return []Defect{DefectMissingField, DefectBadEmail, DefectTotalsMismatch, DefectTrackMismatch, DefectBadCurrency, DefectBadLocale}
+}
+
+// ParseDefects parses a comma-separated list of defect names.
+func ParseDefects(list string) ([]Defect, error) {
+	var defects []Defect
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, d := range Defects() {
+			if string(d) == name {
+				defects = append(defects, d)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown defect %q", name)
+		}
+	}
+	return defects, nil
+}
+
+var (
+	firstNames = []string{"Ivan", "Anna", "Dmitry", "Elena", "Sergey", "Olga", "Test", "Maria", "Alexey", "Natalia"}
+	lastNames  = []string{"Ivanov", "Petrova", "Smirnov", "Kuznetsova", "Testov", "Popov", "Volkova", "Sokolov"}
+	cities     = []struct{ city, region, zip string }{
+		{"Moscow", "Moscow", "101000"},
+		{"Saint Petersburg", "Leningrad Oblast", "190000"},
+		{"Kazan", "Tatarstan", "420000"},
+		{"Novosibirsk", "Novosibirsk Oblast", "630000"},
+		{"Kiryat Mozkin", "Kraiot", "2639809"},
+	}
+	streets    = []string{"Ploshad Mira", "Lenina", "Tverskaya", "Nevsky Prospekt", "Sadovaya", "Gagarina"}
+	emailHosts = []string{"gmail.com", "mail.ru", "yandex.ru", "example.com"}
+	currencies = []string{"USD", "EUR", "RUB"}
+	locales    = []string{"en", "ru"}
+	providers  = []string{"wbpay", "visa", "mir"}
+	banks      = []string{"alpha", "sber", "tinkoff", "vtb"}
+	services   = []string{"meest", "cdek", "boxberry", "wb"}
+	brands     = []string{"Vivienne Sabo", "Nivea", "Adidas", "Samsung", "Lego", "Zara"}
+	products   = []string{"Mascaras", "Sneakers", "Phone case", "T-shirt", "Backpack", "Headphones", "Mug"}
+	sizes      = []string{"0", "S", "M", "L", "XL", "42"}
+	statuses   = []int{202, 200, 201, 203}
+)
+
+// Generator produces random orders. The same seed always yields the same
+// sequence of orders. A Generator is not safe for concurrent use.
+type Generator struct {
+	rnd *rand.Rand
+	// now anchors generated dates, so a seed is reproducible across runs.
+	now time.Time
+}
+
+func New(seed int64) *Generator {
+	return &Generator{
+		rnd: rand.New(rand.NewSource(seed)),
+		now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// Order returns a random order that satisfies the validate tags and the
+// business rules: item totals reflect the sale, goods_total is their sum and
+// amount adds delivery_cost and custom_fee.
+func (g *Generator) Order() models.Order {
+	uid := g.hex(16) + "test"
+	track := "WBIL" + strings.ToUpper(g.hex(10))
+	created := g.now.Add(-time.Duration(g.rnd.Int63n(int64(365 * 24 * time.Hour)))).Truncate(time.Second)
+
+	first, last := pick(g, firstNames), pick(g, lastNames)
+	place := cities[g.rnd.Intn(len(cities))]
+
+	order := models.Order{
+		OrderUid:        uid,
+		TrackNumber:     track,
+		Entry:           "WBIL",
+		Locale:          pick(g, locales),
+		CustomerID:      strings.ToLower(first) + fmt.Sprint(g.rnd.Intn(10000)),
+		DeliveryService: pick(g, services),
+		Shardkey:        fmt.Sprint(g.rnd.Intn(10)),
+		SmID:            1 + g.rnd.Intn(100),
+		DateCreated:     created.Format(time.RFC3339),
+		OofShard:        fmt.Sprint(1 + g.rnd.Intn(2)),
+		Delivery: models.Delivery{
+			Name:    first + " " + last,
+			Phone:   fmt.Sprintf("+7%010d", g.rnd.Int63n(1e10)),
+			Zip:     place.zip,
+			City:    place.city,
+			Address: fmt.Sprintf("%s %d", pick(g, streets), 1+g.rnd.Intn(150)),
+			Region:  place.region,
+			Email:   strings.ToLower(first+"."+last) + "@" + pick(g, emailHosts),
+		},
+		Payment: models.Payment{
+			Transaction:  uid,
+			Currency:     pick(g, currencies),
+			Provider:     pick(g, providers),
+			PaymentDT:    created.Add(time.Duration(g.rnd.Intn(3600)) * time.Second).Unix(),
+			Bank:         pick(g, banks),
+			DeliveryCost: 100 * (1 + g.rnd.Intn(20)),
+		},
+	}
+
+	itemCount := 1 + g.rnd.Intn(5)
+	for i := 0; i < itemCount; i++ {
+		price := 50 + g.rnd.Intn(5000)
+		sale := 5 * g.rnd.Intn(11)
+		order.Items = append(order.Items, models.Items{
+			ChrtID:      1000000 + g.rnd.Intn(9000000),
+			TrackNumber: track,
+			Price:       price,
+			Rid:         g.hex(10) + "test",
+			Name:        pick(g, products),
+			Sale:        sale,
+			Size:        pick(g, sizes),
+			TotalPrice:  TotalPrice(price, sale),
+			NmID:        1000000 + g.rnd.Intn(9000000),
+			Brand:       pick(g, brands),
+			Status:      pick(g, statuses),
+		})
+		order.Payment.GoodsTotal += order.Items[i].TotalPrice
+	}
+	order.Payment.Amount = order.Payment.GoodsTotal + order.Payment.DeliveryCost + order.Payment.CustomFee
+
+	return order
+}
+
+// Orders returns n valid orders.
+func (g *Generator) Orders(n int) []models.Order {
+	orders := make([]models.Order, n)
+	for i := range orders {
+		orders[i] = g.Order()
+	}
+	return orders
+}
+
+// InvalidOrder returns a random order with the given defects applied. Without
+// defects one is chosen at random.
+func (g *Generator) InvalidOrder(defects ...Defect) models.Order {
+	order := g.Order()
+	if len(defects) == 0 {
+		all := Defects()
+		defects = []Defect{all[g.rnd.Intn(len(all))]}
+	}
+	for _, d := range defects {
+		g.apply(&order, d)
+	}
+	return order
+}
+
+func (g *Generator) apply(order *models.Order, defect Defect) {
+	switch defect {
+	case DefectMissingField:
+		fields := []func(){
+			func() { order.OrderUid = "" },
+			func() { order.TrackNumber = "" },
+			func() { order.CustomerID = "" },
+			func() { order.Delivery.Name = "" },
+			func() { order.Delivery.Phone = "" },
+			func() { order.Payment.Transaction = "" },
+			func() { order.Payment.Currency = "" },
+			func() { order.Items[0].Name = "" },
+			func() { order.Items[0].Price = 0 },
+		}
+		fields[g.rnd.Intn(len(fields))]()
+	case DefectBadEmail:
+		order.Delivery.Email = strings.Replace(order.Delivery.Email, "@", "_at_", 1)
+	case DefectTotalsMismatch:
+		order.Payment.GoodsTotal += 1 + g.rnd.Intn(100)
+	case DefectTrackMismatch:
+		order.Items[len(order.Items)-1].TrackNumber = "WBIL" + strings.ToUpper(g.hex(10))
+	case DefectBadCurrency:
+		order.Payment.Currency = "XYZ"
+	case DefectBadLocale:
+		order.Locale = "xx"
+	}
+}
+
+// TotalPrice is the price of an item after the sale percentage, rounded down.
+func TotalPrice(price, sale int) int {
+	return price * (100 - sale) / 100
+}
+
+func (g *Generator) hex(n int) string {
+	const digits = "0123456789abcdef"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = digits[g.rnd.Intn(len(digits))]
+	}
+	return string(b)
+}
+
+func pick[T any](g *Generator, values []T) T {
+	return values[g.rnd.Intn(len(values))]
+}
@@ -0,0 +1,28 @@
+// Package generatortest provides order generators for tests.
+package generatortest
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+	"wb-kafka-service/pkg/generator"
+)
+
+// New returns a generator seeded from ORDER_GENERATOR_SEED when set and from
+// the clock otherwise. The seed is logged so a failing run can be reproduced.
+func New(tb testing.TB) *generator.Generator {
+	tb.Helper()
+
+	seed := time.Now().UnixNano()
+	if env := os.Getenv("ORDER_GENERATOR_SEED"); env != "" {
+		parsed, err := strconv.ParseInt(env, 10, 64)
+		if err != nil {
+			tb.Fatalf("invalid ORDER_GENERATOR_SEED %q: %v", env, err)
+		}
+		seed = parsed
+	}
+
+	tb.Logf("order generator seed: %d (set ORDER_GENERATOR_SEED to reproduce)", seed)
+	return generator.New(seed)
+}
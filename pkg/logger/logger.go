@@ -2,8 +2,10 @@ package logger
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,19 +14,36 @@ type Logger interface {
 	Warn(message string, err error)
 	Error(message string, err error)
 	Fatal(message string, err error)
+	// SetLevel drops entries below level: "info", "warn" or "error". Fatal
+	// entries are always written.
+	SetLevel(level string) error
+	Level() string
 	Close()
 }
 
+// Levels are the levels SetLevel accepts, most verbose first.
+var Levels = []string{"info", "warn", "error"}
+
 type LogEntry struct {
 	Time    string `json:"time"`
 	Level   string `json:"level"`
 	Message string `json:"message"`
 	Error   string `json:"error,omitempty"`
+	Details any    `json:"details,omitempty"`
+}
+
+// DetailedError is an error carrying structured details, such as the violated
+// validation rules. The details are logged under "details" next to the error.
+type DetailedError interface {
+	error
+	Details() any
 }
 
 type loggerImpl struct {
 	logFile   *os.File
 	toConsole bool
+	// minLevel is the index in Levels of the least severe level written.
+	minLevel atomic.Int32
 }
 
 func NewLogger(filePath string, toConsole bool) (Logger, error) {
@@ -41,7 +60,33 @@ func NewLogger(filePath string, toConsole bool) (Logger, error) {
 	return &loggerImpl{logFile: logFile, toConsole: toConsole}, nil
 }
 
+func levelIndex(level string) int {
+	for i, l := range Levels {
+		if l == level {
+			return i
+		}
+	}
+	return len(Levels) // fatal
+}
+
+func (l *loggerImpl) SetLevel(level string) error {
+	i := levelIndex(level)
+	if i == len(Levels) {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	l.minLevel.Store(int32(i))
+	return nil
+}
+
+func (l *loggerImpl) Level() string {
+	return Levels[l.minLevel.Load()]
+}
+
 func (l *loggerImpl) logJSON(level, message string, err error) {
+	if levelIndex(level) < int(l.minLevel.Load()) {
+		return
+	}
+
 	entry := LogEntry{
 		Time:    time.Now().Format(time.RFC3339),
 		Level:   level,
@@ -50,6 +95,11 @@ func (l *loggerImpl) logJSON(level, message string, err error) {
 
 	if err != nil {
 		entry.Error = err.Error()
+
+		var detailed DetailedError
+		if errors.As(err, &detailed) {
+			entry.Details = detailed.Details()
+		}
 	}
 
 	logData, _ := json.Marshal(entry)
@@ -81,6 +81,34 @@ func (mr *MockLoggerMockRecorder) Info(message interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Info", reflect.TypeOf((*MockLogger)(nil).Info), message)
 }
 
+// Level mocks base method.
+func (m *MockLogger) Level() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Level")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// Level indicates an expected call of Level.
+func (mr *MockLoggerMockRecorder) Level() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Level", reflect.TypeOf((*MockLogger)(nil).Level))
+}
+
+// SetLevel mocks base method.
+func (m *MockLogger) SetLevel(level string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetLevel", level)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLevel indicates an expected call of SetLevel.
+func (mr *MockLoggerMockRecorder) SetLevel(level interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLevel", reflect.TypeOf((*MockLogger)(nil).SetLevel), level)
+}
+
 // Warn mocks base method.
 func (m *MockLogger) Warn(message string, err error) {
 	m.ctrl.T.Helper()
@@ -0,0 +1,176 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/pkg/breaker"
+
+	"github.com/jackc/pgconn"
+)
+
+// IsFailure tells the errors that mean Postgres is unavailable from outcomes
+// such as a missing order or a rejected change.
+func IsFailure(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, ErrOrderNotFound),
+		errors.Is(err, ErrItemNotFound),
+		errors.Is(err, ErrOrderCancelled),
+		errors.Is(err, ErrDuplicateMessage),
+		errors.Is(err, ErrOrderExists),
+		errors.Is(err, context.Canceled),
+		errors.As(err, new(*CallbackError)),
+		validation.Violations(err) != nil,
+		isDataError(err):
+		return false
+	default:
+		return true
+	}
+}
+
+// isDataError reports whether err is caused by the data itself and would
+// recur on every retry: a Postgres data exception (SQLSTATE class 22), an
+// integrity constraint violation (class 23) or a value that cannot be
+// marshalled.
+func isDataError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")
+	}
+	return errors.As(err, new(*json.MarshalerError)) ||
+		errors.As(err, new(*json.UnsupportedTypeError)) ||
+		errors.As(err, new(*json.UnsupportedValueError))
+}
+
+// BreakerPostgresDB fails fast with breaker.ErrOpen while Postgres is down.
+type BreakerPostgresDB struct {
+	db      PostgresDB
+	breaker *breaker.Breaker
+}
+
+func NewBreakerPostgresDB(db PostgresDB, b *breaker.Breaker) *BreakerPostgresDB {
+	return &BreakerPostgresDB{db: db, breaker: b}
+}
+
+func (b *BreakerPostgresDB) InsertOrderToDB(ctx context.Context, order *models.Order) error {
+	return b.breaker.Do(func() error { return b.db.InsertOrderToDB(ctx, order) })
+}
+
+func (b *BreakerPostgresDB) CreateOrder(ctx context.Context, event models.OrderEvent, order *models.Order) (bool, error) {
+	return breaker.Call(b.breaker, func() (bool, error) { return b.db.CreateOrder(ctx, event, order) })
+}
+
+func (b *BreakerPostgresDB) GetOrderFromDB(ctx context.Context, orderID int) (*models.Order, error) {
+	return breaker.Call(b.breaker, func() (*models.Order, error) { return b.db.GetOrderFromDB(ctx, orderID) })
+}
+
+func (b *BreakerPostgresDB) UpdateItemStatus(ctx context.Context, event models.OrderEvent, change models.ItemStatusChange) (int, error) {
+	return breaker.Call(b.breaker, func() (int, error) { return b.db.UpdateItemStatus(ctx, event, change) })
+}
+
+func (b *BreakerPostgresDB) UpdateDelivery(ctx context.Context, event models.OrderEvent, change models.DeliveryChange) (int, error) {
+	return breaker.Call(b.breaker, func() (int, error) { return b.db.UpdateDelivery(ctx, event, change) })
+}
+
+func (b *BreakerPostgresDB) UpdateOrder(ctx context.Context, event models.OrderEvent, order *models.Order) (int, error) {
+	return breaker.Call(b.breaker, func() (int, error) { return b.db.UpdateOrder(ctx, event, order) })
+}
+
+func (b *BreakerPostgresDB) CancelOrder(ctx context.Context, event models.OrderEvent, cancellation models.Cancellation) (int, error) {
+	return breaker.Call(b.breaker, func() (int, error) { return b.db.CancelOrder(ctx, event, cancellation) })
+}
+
+func (b *BreakerPostgresDB) ListOrderIDs(ctx context.Context, beforeID, limit int) ([]int, error) {
+	return breaker.Call(b.breaker, func() ([]int, error) { return b.db.ListOrderIDs(ctx, beforeID, limit) })
+}
+
+func (b *BreakerPostgresDB) ListCustomerOrders(ctx context.Context, query CustomerOrdersQuery) ([]models.OrderSummary, error) {
+	return breaker.Call(b.breaker, func() ([]models.OrderSummary, error) { return b.db.ListCustomerOrders(ctx, query) })
+}
+
+func (b *BreakerPostgresDB) RevenueByDay(ctx context.Context, rng ReportRange) ([]models.RevenueRow, error) {
+	return breaker.Call(b.breaker, func() ([]models.RevenueRow, error) { return b.db.RevenueByDay(ctx, rng) })
+}
+
+func (b *BreakerPostgresDB) TopProducts(ctx context.Context, rng ReportRange, by, sortBy string, limit int) ([]models.ProductRow, error) {
+	return breaker.Call(b.breaker, func() ([]models.ProductRow, error) { return b.db.TopProducts(ctx, rng, by, sortBy, limit) })
+}
+
+func (b *BreakerPostgresDB) DeliveryCosts(ctx context.Context, rng ReportRange) ([]models.DeliveryCostRow, error) {
+	return breaker.Call(b.breaker, func() ([]models.DeliveryCostRow, error) { return b.db.DeliveryCosts(ctx, rng) })
+}
+
+func (b *BreakerPostgresDB) SaleDistribution(ctx context.Context, rng ReportRange, width int) ([]models.SaleBucket, error) {
+	return breaker.Call(b.breaker, func() ([]models.SaleBucket, error) { return b.db.SaleDistribution(ctx, rng, width) })
+}
+
+// ExportOrders records its outcome with the breaker once the first row
+// arrives, so a half-open probe doesn't hold off every other call for the
+// whole export. Errors after that are not counted.
+func (b *BreakerPostgresDB) ExportOrders(ctx context.Context, filter ExportFilter, batch int, fn func(*models.ExportRow) error) error {
+	return b.untilCallback(func(started func()) error {
+		return b.db.ExportOrders(ctx, filter, batch, func(row *models.ExportRow) error {
+			started()
+			return fn(row)
+		})
+	})
+}
+
+// RelayOutbox records its outcome with the breaker once the messages are
+// claimed, so publishing them doesn't hold off other calls either.
+func (b *BreakerPostgresDB) RelayOutbox(ctx context.Context, limit int, publish func([]models.OutboxMessage) (int, error)) (int, error) {
+	var sent int
+	err := b.untilCallback(func(started func()) error {
+		var err error
+		sent, err = b.db.RelayOutbox(ctx, limit, func(messages []models.OutboxMessage) (int, error) {
+			started()
+			return publish(messages)
+		})
+		return err
+	})
+	return sent, err
+}
+
+// untilCallback runs call if the breaker admits it. The call is recorded as a
+// success once it calls started, or with its outcome if it never does.
+func (b *BreakerPostgresDB) untilCallback(call func(started func()) error) (err error) {
+	record, err := b.breaker.Begin()
+	if err != nil {
+		return err
+	}
+	recorded := false
+	defer func() {
+		if recorded {
+			return
+		}
+		if p := recover(); p != nil {
+			record(fmt.Errorf("call panicked: %v", p))
+			panic(p)
+		}
+		record(err)
+	}()
+
+	return call(func() {
+		if !recorded {
+			recorded = true
+			record(nil)
+		}
+	})
+}
+func (b *BreakerPostgresDB) DeleteSentOutbox(ctx context.Context, before time.Time, limit int) (int64, error) {
+	return breaker.Call(b.breaker, func() (int64, error) { return b.db.DeleteSentOutbox(ctx, before, limit) })
+}
+
+func (b *BreakerPostgresDB) DeleteProcessedMessages(ctx context.Context, before time.Time, limit int) (int64, error) {
+	return breaker.Call(b.breaker, func() (int64, error) { return b.db.DeleteProcessedMessages(ctx, before, limit) })
+}
+
+func (b *BreakerPostgresDB) ImportOrders(ctx context.Context, orders []*models.Order) ([]error, error) {
+	return breaker.Call(b.breaker, func() ([]error, error) { return b.db.ImportOrders(ctx, orders) })
+}
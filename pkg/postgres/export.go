@@ -0,0 +1,124 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"wb-kafka-service/internal/models"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// DefaultExportBatch is how many rows ExportOrders fetches from its cursor at once.
+const DefaultExportBatch = 1000
+
+// ExportFilter selects the orders of ExportOrders. Empty fields match every order.
+type ExportFilter struct {
+	// Range limits date_created, see ReportRange. Unlike reports, cancelled
+	// orders are exported unless Status says otherwise.
+	Range           ReportRange
+	CustomerID      string
+	DeliveryService string
+	Status          string
+}
+
+// CallbackError wraps an error returned by the callback of ExportOrders or
+// RelayOutbox, such as a client that went away or a broker that is down. It
+// says nothing about the DB.
+type CallbackError struct {
+	Err error
+}
+
+func (e *CallbackError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CallbackError) Unwrap() error {
+	return e.Err
+}
+
+const exportQuery = `SELECT o.id, o.order_uid, o.track_number, o.entry, o.locale, o.customer_id, o.delivery_service,
+		o.shardkey, o.sm_id, o.date_created, o.oof_shard, o.status,
+		d.name, d.phone, d.zip, d.city, d.address, d.region, d.email,
+		p.transaction, p.request_id, p.currency, p.provider, p.amount, p.payment_dt, p.bank,
+		p.delivery_cost, p.goods_total, p.custom_fee,
+		COALESCE(i.chrt_id, 0), COALESCE(i.price, 0), COALESCE(i.rid, ''), COALESCE(i.name, ''),
+		COALESCE(i.sale, 0), COALESCE(i.size, ''), COALESCE(i.total_price, 0), COALESCE(i.nm_id, 0),
+		COALESCE(i.brand, ''), COALESCE(i.status, 0)
+	FROM orders o
+	JOIN delivery d ON d.id = o.delivery_id
+	JOIN payment p ON p.id = o.payment_id
+	LEFT JOIN items i ON i.track_number = o.track_number
+	WHERE ($1 = '' OR o.date_created >= $1) AND ($2 = '' OR o.date_created < $2)
+		AND ($3 = '' OR o.customer_id = $3)
+		AND ($4 = '' OR o.delivery_service = $4)
+		AND ($5 = '' OR o.status = $5)
+	ORDER BY o.id, i.id`
+
+// ExportOrders calls fn with every row of the orders matching filter, by order
+// id. Rows are read through a server-side cursor batch rows at a time, so
+// memory does not grow with the export. The row passed to fn is reused
+// between calls. An error from fn stops the export and is returned wrapped in
+// a CallbackError.
+func (db *PostgresDBImpl) ExportOrders(ctx context.Context, filter ExportFilter, batch int, fn func(*models.ExportRow) error) error {
+	// A cursor only lives as long as its transaction; a read-only repeatable
+	// read one also gives the export a consistent snapshot.
+	tx, err := db.Pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		db.Log.Error("Error starting export transaction", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "DECLARE export_cursor NO SCROLL CURSOR FOR "+exportQuery,
+		filter.Range.From, filter.Range.To, filter.CustomerID, filter.DeliveryService, filter.Status)
+	if err != nil {
+		db.Log.Error("Error declaring export cursor", err)
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM export_cursor", max(1, batch))
+	var row models.ExportRow
+	for {
+		rows, err := tx.Query(ctx, fetch)
+		if err != nil {
+			db.Log.Error("Error fetching export rows", err)
+			return err
+		}
+
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			err = rows.Scan(
+				&row.OrderID, &row.OrderUid, &row.TrackNumber, &row.Entry, &row.Locale, &row.CustomerID, &row.DeliveryService,
+				&row.Shardkey, &row.SmID, &row.DateCreated, &row.OofShard, &row.Status,
+				&row.DeliveryName, &row.DeliveryPhone, &row.DeliveryZip, &row.DeliveryCity, &row.DeliveryAddress, &row.DeliveryRegion, &row.DeliveryEmail,
+				&row.PaymentTransaction, &row.PaymentRequestID, &row.PaymentCurrency, &row.PaymentProvider, &row.PaymentAmount, &row.PaymentDT, &row.PaymentBank,
+				&row.PaymentDeliveryCost, &row.PaymentGoodsTotal, &row.PaymentCustomFee,
+				&row.ItemChrtID, &row.ItemPrice, &row.ItemRid, &row.ItemName,
+				&row.ItemSale, &row.ItemSize, &row.ItemTotalPrice, &row.ItemNmID,
+				&row.ItemBrand, &row.ItemStatus,
+			)
+			if err != nil {
+				rows.Close()
+				db.Log.Error("Error scanning export row", err)
+				return err
+			}
+			if err = fn(&row); err != nil {
+				rows.Close()
+				return &CallbackError{Err: err}
+			}
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			db.Log.Error("Error iterating over export rows", err)
+			return err
+		}
+		if fetched == 0 {
+			break
+		}
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"wb-kafka-service/internal/models"
+	"wb-kafka-service/internal/validation"
+	"wb-kafka-service/pkg/logger"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrOrderExists is returned by ImportOrders for an order whose order_uid is
+// already in the DB. Nothing is changed for it.
+var ErrOrderExists = errors.New("order already exists")
+
+// ImportOrders inserts a batch of orders in one transaction, as
+// order.created events. It returns one error per order: nil if the order was
+// inserted, ErrOrderExists, a validation error or the error the DB gave for
+// it. Each order is inserted under its own savepoint, so one rejected order
+// doesn't fail the batch. The second result is set if the whole batch failed,
+// in which case nothing was inserted.
+func (db *PostgresDBImpl) ImportOrders(ctx context.Context, orders []*models.Order) ([]error, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		db.Log.Error("Error starting import transaction", err)
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	results, err := ImportOrdersTx(ctx, db.Log, tx, orders)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		db.Log.Error("Error committing import transaction", err)
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	db.Log.Info(fmt.Sprintf("Imported a batch of %d orders", len(orders)))
+	return results, nil
+}
+
+// ImportOrdersTx is ImportOrders within tx, which the caller commits.
+func ImportOrdersTx(ctx context.Context, log logger.Logger, tx pgx.Tx, orders []*models.Order) ([]error, error) {
+	results := make([]error, len(orders))
+	uids := make([]string, 0, len(orders))
+	for i, order := range orders {
+		results[i] = validation.Default.Validate(order)
+		uids = append(uids, order.OrderUid)
+	}
+
+	// Checking up front keeps the delivery and payment of existing orders
+	// from being inserted at all.
+	rows, err := tx.Query(ctx, "SELECT order_uid FROM orders WHERE order_uid = ANY($1)", uids)
+	if err != nil {
+		log.Error("Error looking up existing orders", err)
+		return nil, err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			rows.Close()
+			log.Error("Error scanning existing order", err)
+			return nil, err
+		}
+		existing[uid] = true
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("Error iterating over existing orders", err)
+		return nil, err
+	}
+
+	for i, order := range orders {
+		if results[i] != nil {
+			continue
+		}
+		if existing[order.OrderUid] {
+			results[i] = ErrOrderExists
+			continue
+		}
+		results[i] = importOrder(ctx, log, tx, order)
+		if results[i] == nil {
+			existing[order.OrderUid] = true
+		}
+	}
+	return results, nil
+}
+
+// importOrder inserts order under a savepoint of tx. On failure it rolls back
+// to the savepoint, which also ends the error state of the transaction, so
+// the rest of the batch can still be inserted.
+func importOrder(ctx context.Context, log logger.Logger, tx pgx.Tx, order *models.Order) error {
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		log.Error("Error creating savepoint", err)
+		return err
+	}
+
+	created, err := insertOrder(log, savepoint, models.OrderEvent{EventType: models.EventOrderCreated}, order)
+	if err == nil && !created {
+		err = ErrOrderExists
+	}
+	if err != nil {
+		savepoint.Rollback(ctx)
+		return err
+	}
+	return savepoint.Commit(ctx)
+}
@@ -7,6 +7,7 @@ package postgres
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 	models "wb-kafka-service/internal/models"
 
 	gomock "github.com/golang/mock/gomock"
@@ -35,6 +36,95 @@ func (m *MockPostgresDB) EXPECT() *MockPostgresDBMockRecorder {
 	return m.recorder
 }
 
+// CancelOrder mocks base method.
+func (m *MockPostgresDB) CancelOrder(ctx context.Context, event models.OrderEvent, cancellation models.Cancellation) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelOrder", ctx, event, cancellation)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelOrder indicates an expected call of CancelOrder.
+func (mr *MockPostgresDBMockRecorder) CancelOrder(ctx, event, cancellation interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelOrder", reflect.TypeOf((*MockPostgresDB)(nil).CancelOrder), ctx, event, cancellation)
+}
+
+// CreateOrder mocks base method.
+func (m *MockPostgresDB) CreateOrder(ctx context.Context, event models.OrderEvent, order *models.Order) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateOrder", ctx, event, order)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateOrder indicates an expected call of CreateOrder.
+func (mr *MockPostgresDBMockRecorder) CreateOrder(ctx, event, order interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOrder", reflect.TypeOf((*MockPostgresDB)(nil).CreateOrder), ctx, event, order)
+}
+
+// DeleteProcessedMessages mocks base method.
+func (m *MockPostgresDB) DeleteProcessedMessages(ctx context.Context, before time.Time, limit int) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteProcessedMessages", ctx, before, limit)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteProcessedMessages indicates an expected call of DeleteProcessedMessages.
+func (mr *MockPostgresDBMockRecorder) DeleteProcessedMessages(ctx, before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteProcessedMessages", reflect.TypeOf((*MockPostgresDB)(nil).DeleteProcessedMessages), ctx, before, limit)
+}
+
+// DeleteSentOutbox mocks base method.
+func (m *MockPostgresDB) DeleteSentOutbox(ctx context.Context, before time.Time, limit int) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteSentOutbox", ctx, before, limit)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteSentOutbox indicates an expected call of DeleteSentOutbox.
+func (mr *MockPostgresDBMockRecorder) DeleteSentOutbox(ctx, before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSentOutbox", reflect.TypeOf((*MockPostgresDB)(nil).DeleteSentOutbox), ctx, before, limit)
+}
+
+// DeliveryCosts mocks base method.
+func (m *MockPostgresDB) DeliveryCosts(ctx context.Context, rng ReportRange) ([]models.DeliveryCostRow, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeliveryCosts", ctx, rng)
+	ret0, _ := ret[0].([]models.DeliveryCostRow)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeliveryCosts indicates an expected call of DeliveryCosts.
+func (mr *MockPostgresDBMockRecorder) DeliveryCosts(ctx, rng interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeliveryCosts", reflect.TypeOf((*MockPostgresDB)(nil).DeliveryCosts), ctx, rng)
+}
+
+// ExportOrders mocks base method.
+func (m *MockPostgresDB) ExportOrders(ctx context.Context, filter ExportFilter, batch int, fn func(*models.ExportRow) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportOrders", ctx, filter, batch, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportOrders indicates an expected call of ExportOrders.
+func (mr *MockPostgresDBMockRecorder) ExportOrders(ctx, filter, batch, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportOrders", reflect.TypeOf((*MockPostgresDB)(nil).ExportOrders), ctx, filter, batch, fn)
+}
+
 // GetOrderFromDB mocks base method.
 func (m *MockPostgresDB) GetOrderFromDB(ctx context.Context, orderID int) (*models.Order, error) {
 	m.ctrl.T.Helper()
@@ -50,6 +140,21 @@ func (mr *MockPostgresDBMockRecorder) GetOrderFromDB(ctx, orderID interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderFromDB", reflect.TypeOf((*MockPostgresDB)(nil).GetOrderFromDB), ctx, orderID)
 }
 
+// ImportOrders mocks base method.
+func (m *MockPostgresDB) ImportOrders(ctx context.Context, orders []*models.Order) ([]error, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImportOrders", ctx, orders)
+	ret0, _ := ret[0].([]error)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImportOrders indicates an expected call of ImportOrders.
+func (mr *MockPostgresDBMockRecorder) ImportOrders(ctx, orders interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportOrders", reflect.TypeOf((*MockPostgresDB)(nil).ImportOrders), ctx, orders)
+}
+
 // InsertOrderToDB mocks base method.
 func (m *MockPostgresDB) InsertOrderToDB(ctx context.Context, order *models.Order) error {
 	m.ctrl.T.Helper()
@@ -63,3 +168,138 @@ func (mr *MockPostgresDBMockRecorder) InsertOrderToDB(ctx, order interface{}) *g
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertOrderToDB", reflect.TypeOf((*MockPostgresDB)(nil).InsertOrderToDB), ctx, order)
 }
+
+// ListCustomerOrders mocks base method.
+func (m *MockPostgresDB) ListCustomerOrders(ctx context.Context, query CustomerOrdersQuery) ([]models.OrderSummary, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListCustomerOrders", ctx, query)
+	ret0, _ := ret[0].([]models.OrderSummary)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListCustomerOrders indicates an expected call of ListCustomerOrders.
+func (mr *MockPostgresDBMockRecorder) ListCustomerOrders(ctx, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListCustomerOrders", reflect.TypeOf((*MockPostgresDB)(nil).ListCustomerOrders), ctx, query)
+}
+
+// ListOrderIDs mocks base method.
+func (m *MockPostgresDB) ListOrderIDs(ctx context.Context, beforeID int, limit int) ([]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListOrderIDs", ctx, beforeID, limit)
+	ret0, _ := ret[0].([]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListOrderIDs indicates an expected call of ListOrderIDs.
+func (mr *MockPostgresDBMockRecorder) ListOrderIDs(ctx, beforeID, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListOrderIDs", reflect.TypeOf((*MockPostgresDB)(nil).ListOrderIDs), ctx, beforeID, limit)
+}
+
+// RelayOutbox mocks base method.
+func (m *MockPostgresDB) RelayOutbox(ctx context.Context, limit int, publish func([]models.OutboxMessage) (int, error)) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RelayOutbox", ctx, limit, publish)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RelayOutbox indicates an expected call of RelayOutbox.
+func (mr *MockPostgresDBMockRecorder) RelayOutbox(ctx, limit, publish interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RelayOutbox", reflect.TypeOf((*MockPostgresDB)(nil).RelayOutbox), ctx, limit, publish)
+}
+
+// RevenueByDay mocks base method.
+func (m *MockPostgresDB) RevenueByDay(ctx context.Context, rng ReportRange) ([]models.RevenueRow, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevenueByDay", ctx, rng)
+	ret0, _ := ret[0].([]models.RevenueRow)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RevenueByDay indicates an expected call of RevenueByDay.
+func (mr *MockPostgresDBMockRecorder) RevenueByDay(ctx, rng interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevenueByDay", reflect.TypeOf((*MockPostgresDB)(nil).RevenueByDay), ctx, rng)
+}
+
+// SaleDistribution mocks base method.
+func (m *MockPostgresDB) SaleDistribution(ctx context.Context, rng ReportRange, width int) ([]models.SaleBucket, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaleDistribution", ctx, rng, width)
+	ret0, _ := ret[0].([]models.SaleBucket)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaleDistribution indicates an expected call of SaleDistribution.
+func (mr *MockPostgresDBMockRecorder) SaleDistribution(ctx, rng, width interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaleDistribution", reflect.TypeOf((*MockPostgresDB)(nil).SaleDistribution), ctx, rng, width)
+}
+
+// TopProducts mocks base method.
+func (m *MockPostgresDB) TopProducts(ctx context.Context, rng ReportRange, by string, sortBy string, limit int) ([]models.ProductRow, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopProducts", ctx, rng, by, sortBy, limit)
+	ret0, _ := ret[0].([]models.ProductRow)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TopProducts indicates an expected call of TopProducts.
+func (mr *MockPostgresDBMockRecorder) TopProducts(ctx, rng, by, sortBy, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopProducts", reflect.TypeOf((*MockPostgresDB)(nil).TopProducts), ctx, rng, by, sortBy, limit)
+}
+
+// UpdateDelivery mocks base method.
+func (m *MockPostgresDB) UpdateDelivery(ctx context.Context, event models.OrderEvent, change models.DeliveryChange) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateDelivery", ctx, event, change)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateDelivery indicates an expected call of UpdateDelivery.
+func (mr *MockPostgresDBMockRecorder) UpdateDelivery(ctx, event, change interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateDelivery", reflect.TypeOf((*MockPostgresDB)(nil).UpdateDelivery), ctx, event, change)
+}
+
+// UpdateItemStatus mocks base method.
+func (m *MockPostgresDB) UpdateItemStatus(ctx context.Context, event models.OrderEvent, change models.ItemStatusChange) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateItemStatus", ctx, event, change)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateItemStatus indicates an expected call of UpdateItemStatus.
+func (mr *MockPostgresDBMockRecorder) UpdateItemStatus(ctx, event, change interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateItemStatus", reflect.TypeOf((*MockPostgresDB)(nil).UpdateItemStatus), ctx, event, change)
+}
+
+// UpdateOrder mocks base method.
+func (m *MockPostgresDB) UpdateOrder(ctx context.Context, event models.OrderEvent, order *models.Order) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateOrder", ctx, event, order)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateOrder indicates an expected call of UpdateOrder.
+func (mr *MockPostgresDBMockRecorder) UpdateOrder(ctx, event, order interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrder", reflect.TypeOf((*MockPostgresDB)(nil).UpdateOrder), ctx, event, order)
+}
@@ -2,18 +2,84 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 	"wb-kafka-service/internal/database"
 	"wb-kafka-service/internal/models"
 	"wb-kafka-service/internal/config"
+	"wb-kafka-service/internal/validation"
 	"wb-kafka-service/pkg/logger"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/go-playground/validator/v10"
+)
+
+var (
+	// ErrOrderNotFound is returned when no order has the requested id or order_uid.
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrItemNotFound is returned when an item status change names an item the order doesn't have.
+	ErrItemNotFound = errors.New("item not found")
+	// ErrOrderCancelled is returned when a change targets an order that was already cancelled.
+	ErrOrderCancelled = errors.New("order is cancelled")
+	// ErrDuplicateMessage is returned when the processed-messages ledger already
+	// has the event's idempotency key. Nothing is changed.
+	ErrDuplicateMessage = errors.New("message already processed")
 )
 
 type PostgresDB interface {
 	InsertOrderToDB(ctx context.Context, order *models.Order) error
+	// CreateOrder is InsertOrderToDB for an order.created event: the event's
+	// idempotency key is added to the processed-messages ledger in the same
+	// transaction. It reports whether the order was created; if an order with
+	// the same order_uid exists, it is left as is and only order.ID is set.
+	CreateOrder(ctx context.Context, event models.OrderEvent, order *models.Order) (bool, error)
 	GetOrderFromDB(ctx context.Context, orderID int) (*models.Order, error)
+	// The change methods below apply one lifecycle event and record it in
+	// order_events in the same transaction. They return the id of the changed order.
+	UpdateItemStatus(ctx context.Context, event models.OrderEvent, change models.ItemStatusChange) (int, error)
+	UpdateDelivery(ctx context.Context, event models.OrderEvent, change models.DeliveryChange) (int, error)
+	UpdateOrder(ctx context.Context, event models.OrderEvent, order *models.Order) (int, error)
+	CancelOrder(ctx context.Context, event models.OrderEvent, cancellation models.Cancellation) (int, error)
+	// RelayOutbox claims up to limit unsent outbox messages, oldest first, and
+	// passes them to publish, which returns how many of them, from the first,
+	// it published and the error that stopped it. In the same transaction the
+	// published rows are marked sent and the error is recorded on the next
+	// row. An error from publish is returned wrapped in a CallbackError.
+	RelayOutbox(ctx context.Context, limit int, publish func([]models.OutboxMessage) (int, error)) (int, error)
+	// DeleteSentOutbox deletes up to limit outbox messages sent before before
+	// and returns how many it deleted.
+	DeleteSentOutbox(ctx context.Context, before time.Time, limit int) (int64, error)
+	// DeleteProcessedMessages deletes up to limit processed-messages ledger
+	// entries recorded before before and returns how many it deleted.
+	DeleteProcessedMessages(ctx context.Context, before time.Time, limit int) (int64, error)
+	// ListOrderIDs returns up to limit order ids below beforeID, newest first.
+	// A beforeID of 0 starts at the newest order.
+	ListOrderIDs(ctx context.Context, beforeID, limit int) ([]int, error)
+	// ListCustomerOrders returns a page of a customer's orders sorted by
+	// date_created, then id.
+	ListCustomerOrders(ctx context.Context, query CustomerOrdersQuery) ([]models.OrderSummary, error)
+	// The report methods below aggregate the orders in rng, see reports.go.
+	RevenueByDay(ctx context.Context, rng ReportRange) ([]models.RevenueRow, error)
+	TopProducts(ctx context.Context, rng ReportRange, by, sortBy string, limit int) ([]models.ProductRow, error)
+	DeliveryCosts(ctx context.Context, rng ReportRange) ([]models.DeliveryCostRow, error)
+	SaleDistribution(ctx context.Context, rng ReportRange, width int) ([]models.SaleBucket, error)
+	// ExportOrders streams flattened order rows to fn, see export.go.
+	ExportOrders(ctx context.Context, filter ExportFilter, batch int, fn func(*models.ExportRow) error) error
+	// ImportOrders inserts a batch of orders with one result per order, see import.go.
+	ImportOrders(ctx context.Context, orders []*models.Order) ([]error, error)
+}
+
+// CustomerOrdersQuery selects a page of ListCustomerOrders.
+type CustomerOrdersQuery struct {
+	CustomerID string
+	// Ascending lists the oldest orders first; by default the newest come first.
+	Ascending bool
+	// AfterDate and AfterID are the date_created and id of the last order of
+	// the previous page. An AfterID of 0 starts at the first page.
+	AfterDate string
+	AfterID   int
+	Limit     int
 }
 
 type PostgresDBImpl struct {
@@ -39,17 +105,21 @@ func ConnectDB(log logger.Logger, config config.AppConfig) (*pgxpool.Pool, error
 }
 
 func (db *PostgresDBImpl) InsertOrderToDB(ctx context.Context, order *models.Order) error {
-	validate := validator.New()
-	err := validate.Struct(order)
+	_, err := db.CreateOrder(ctx, models.OrderEvent{EventType: models.EventOrderCreated}, order)
+	return err
+}
+
+func (db *PostgresDBImpl) CreateOrder(ctx context.Context, event models.OrderEvent, order *models.Order) (bool, error) {
+	err := validation.Default.Validate(order)
 	if err != nil {
-		db.Log.Error("Validation failed", err)
-		return fmt.Errorf("validation failed: %w", err)
+		db.Log.Error(fmt.Sprintf("Invalid order %s", order.OrderUid), err)
+		return false, err
 	}
 
 	tx, err := db.Pool.Begin(ctx)
 	if err != nil {
 		db.Log.Error("Error starting transaction", err)
-		return fmt.Errorf("error starting transaction: %v", err)
+		return false, fmt.Errorf("error starting transaction: %w", err)
 	}
 	defer func() {
 		if p := recover(); p != nil {
@@ -58,49 +128,78 @@ func (db *PostgresDBImpl) InsertOrderToDB(ctx context.Context, order *models.Ord
 		}
 	}()
 
-	_, err = database.InsertDelivery(db.Log, tx, &order.Delivery)
+	created, err := insertOrder(db.Log, tx, event, order)
 	if err != nil {
 		tx.Rollback(ctx)
-		db.Log.Error("Error inserting delivery", err)
-		return fmt.Errorf("error inserting delivery: %v", err)
+		return false, err
 	}
 
-	_, err = database.InsertPayment(db.Log, tx, &order.Payment)
+	err = tx.Commit(ctx)
 	if err != nil {
-		tx.Rollback(ctx)
-		db.Log.Error("Error inserting payment", err)
-		return fmt.Errorf("error inserting payment: %v", err)
+		db.Log.Error("Error committing transaction", err)
+		return false, fmt.Errorf("error committing transaction: %w", err)
 	}
 
-	for _, item := range order.Items {
-		err = database.InsertItem(db.Log, tx, &item)
+	db.Log.Info("Order successfully inserted")
+	return created, nil
+}
+
+// insertOrder inserts order with its delivery, payment and items in tx and
+// records event for it. It reports whether the order was created; if an order
+// with the same order_uid exists, nothing is recorded.
+func insertOrder(log logger.Logger, tx pgx.Tx, event models.OrderEvent, order *models.Order) (bool, error) {
+	seen, err := markProcessed(log, tx, order.OrderUid, event)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = database.InsertDelivery(log, tx, &order.Delivery)
+	if err != nil {
+		log.Error("Error inserting delivery", err)
+		return false, fmt.Errorf("error inserting delivery: %w", err)
+	}
+
+	_, err = database.InsertPayment(log, tx, &order.Payment)
+	if err != nil {
+		log.Error("Error inserting payment", err)
+		return false, fmt.Errorf("error inserting payment: %w", err)
+	}
+
+	for i := range order.Items {
+		err = database.InsertItem(log, tx, &order.Items[i])
 		if err != nil {
-			tx.Rollback(ctx)
-			db.Log.Error("Error inserting item", err)
-			return fmt.Errorf("error inserting item: %v", err)
+			log.Error("Error inserting item", err)
+			return false, fmt.Errorf("error inserting item: %w", err)
 		}
 	}
 
-	err = database.InsertOrder(db.Log, tx, order)
+	created, err := database.InsertOrder(log, tx, order)
 	if err != nil {
-		tx.Rollback(ctx)
-		db.Log.Error("Error inserting order", err)
-		return fmt.Errorf("error inserting order: %v", err)
+		log.Error("Error inserting order", err)
+		return false, fmt.Errorf("error inserting order: %w", err)
 	}
 
-	err = tx.Commit(ctx)
-	if err != nil {
-		db.Log.Error("Error committing transaction", err)
-		return fmt.Errorf("error committing transaction: %v", err)
+	if created && !seen {
+		event.OrderID = order.ID
+		if event.Payload == nil {
+			event.Payload, err = json.Marshal(order)
+			if err != nil {
+				return false, fmt.Errorf("error marshalling order: %w", err)
+			}
+		}
+		err = recordEvent(log, tx, order.OrderUid, &event)
+		if err != nil {
+			log.Error("Error recording order event", err)
+			return false, fmt.Errorf("error recording order event: %w", err)
+		}
 	}
 
-	db.Log.Info("Order successfully inserted")
-	return nil
+	return created, nil
 }
 
 func (db *PostgresDBImpl) GetOrderFromDB(ctx context.Context, orderID int) (*models.Order, error) {
 	var order models.Order
-	err := db.Pool.QueryRow(ctx, "SELECT id, order_uid, track_number, entry, delivery_id, payment_id, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard FROM orders WHERE id = $1", orderID).Scan(
+	err := db.Pool.QueryRow(ctx, "SELECT id, order_uid, track_number, entry, delivery_id, payment_id, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, status FROM orders WHERE id = $1", orderID).Scan(
 		&order.ID,
 		&order.OrderUid,
 		&order.TrackNumber,
@@ -115,7 +214,11 @@ func (db *PostgresDBImpl) GetOrderFromDB(ctx context.Context, orderID int) (*mod
 		&order.SmID,
 		&order.DateCreated,
 		&order.OofShard,
+		&order.Status,
 	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOrderNotFound
+	}
 	if err != nil {
 		db.Log.Error("Error getting order from DB", err)
 		return nil, err
@@ -192,3 +295,351 @@ func (db *PostgresDBImpl) GetOrderFromDB(ctx context.Context, orderID int) (*mod
 	db.Log.Info("Order successfully retrieved from DB")
 	return &order, nil
 }
+
+func (db *PostgresDBImpl) UpdateItemStatus(ctx context.Context, event models.OrderEvent, change models.ItemStatusChange) (int, error) {
+	return db.applyChange(ctx, change.OrderUid, event, change, func(tx pgx.Tx, order *models.Order) error {
+		updated, err := database.UpdateItemStatus(db.Log, tx, order.TrackNumber, change.ChrtID, change.Status)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return ErrItemNotFound
+		}
+		return nil
+	})
+}
+
+func (db *PostgresDBImpl) UpdateDelivery(ctx context.Context, event models.OrderEvent, change models.DeliveryChange) (int, error) {
+	return db.applyChange(ctx, change.OrderUid, event, change, func(tx pgx.Tx, order *models.Order) error {
+		deliveryID, err := database.InsertDelivery(db.Log, tx, &change.Delivery)
+		if err != nil {
+			return err
+		}
+		return database.SetOrderDelivery(db.Log, tx, order.ID, deliveryID)
+	})
+}
+
+// UpdateOrder applies a full order snapshot: the delivery is replaced and every
+// item status is set to the snapshot's. Other fields are immutable once created.
+func (db *PostgresDBImpl) UpdateOrder(ctx context.Context, event models.OrderEvent, snapshot *models.Order) (int, error) {
+	return db.applyChange(ctx, snapshot.OrderUid, event, snapshot, func(tx pgx.Tx, order *models.Order) error {
+		deliveryID, err := database.InsertDelivery(db.Log, tx, &snapshot.Delivery)
+		if err != nil {
+			return err
+		}
+		err = database.SetOrderDelivery(db.Log, tx, order.ID, deliveryID)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range snapshot.Items {
+			updated, err := database.UpdateItemStatus(db.Log, tx, order.TrackNumber, item.ChrtID, item.Status)
+			if err != nil {
+				return err
+			}
+			if updated == 0 {
+				return fmt.Errorf("%w: chrt_id %d", ErrItemNotFound, item.ChrtID)
+			}
+		}
+		return nil
+	})
+}
+
+func (db *PostgresDBImpl) CancelOrder(ctx context.Context, event models.OrderEvent, cancellation models.Cancellation) (int, error) {
+	return db.applyChange(ctx, cancellation.OrderUid, event, cancellation, func(tx pgx.Tx, order *models.Order) error {
+		return database.SetOrderStatus(db.Log, tx, order.ID, models.OrderStatusCancelled)
+	})
+}
+
+// applyChange runs apply on the locked order with the given order_uid and
+// records event in the same transaction. change is validated first.
+func (db *PostgresDBImpl) applyChange(ctx context.Context, orderUid string, event models.OrderEvent, change any, apply func(tx pgx.Tx, order *models.Order) error) (int, error) {
+	var err error
+	if order, ok := change.(*models.Order); ok {
+		err = validation.Default.Validate(order)
+	} else {
+		err = validation.Default.ValidateStruct(change)
+	}
+	if err != nil {
+		db.Log.Error(fmt.Sprintf("Invalid %s for order %s", event.EventType, orderUid), err)
+		return 0, err
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		db.Log.Error("Error starting transaction", err)
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	seen, err := markProcessed(db.Log, tx, orderUid, event)
+	if err != nil {
+		tx.Rollback(ctx)
+		return 0, err
+	}
+
+	order, err := database.LockOrder(db.Log, tx, orderUid)
+	if errors.Is(err, pgx.ErrNoRows) {
+		tx.Rollback(ctx)
+		return 0, ErrOrderNotFound
+	}
+	if err != nil {
+		tx.Rollback(ctx)
+		return 0, fmt.Errorf("error locking order: %w", err)
+	}
+
+	if order.Status == models.OrderStatusCancelled {
+		tx.Rollback(ctx)
+		return order.ID, ErrOrderCancelled
+	}
+
+	err = apply(tx, order)
+	if err != nil {
+		tx.Rollback(ctx)
+		db.Log.Error(fmt.Sprintf("Error applying %s to order %s", event.EventType, orderUid), err)
+		return order.ID, err
+	}
+
+	if !seen {
+		event.OrderID = order.ID
+		if event.Payload == nil {
+			event.Payload, err = json.Marshal(change)
+			if err != nil {
+				tx.Rollback(ctx)
+				return order.ID, fmt.Errorf("error marshalling event payload: %w", err)
+			}
+		}
+		err = recordEvent(db.Log, tx, orderUid, &event)
+		if err != nil {
+			tx.Rollback(ctx)
+			return order.ID, fmt.Errorf("error recording order event: %w", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		db.Log.Error("Error committing transaction", err)
+		return order.ID, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	db.Log.Info(fmt.Sprintf("Applied %s to order %s", event.EventType, orderUid))
+	return order.ID, nil
+}
+
+func (db *PostgresDBImpl) ListOrderIDs(ctx context.Context, beforeID, limit int) ([]int, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT id FROM orders WHERE $1 = 0 OR id < $1 ORDER BY id DESC LIMIT $2", beforeID, limit)
+	if err != nil {
+		db.Log.Error("Error listing order ids from DB", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			db.Log.Error("Error scanning order id from DB", err)
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		db.Log.Error("Error iterating over order ids", err)
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (db *PostgresDBImpl) ListCustomerOrders(ctx context.Context, query CustomerOrdersQuery) ([]models.OrderSummary, error) {
+	// Keyset pagination over orders_customer_id_date_created_idx, so deep pages
+	// cost the same as the first.
+	compare, direction := "<", "DESC"
+	if query.Ascending {
+		compare, direction = ">", "ASC"
+	}
+	sql := fmt.Sprintf(`SELECT o.id, o.order_uid, o.track_number, o.date_created, o.status, o.delivery_service, d.city,
+		p.currency, p.amount, p.goods_total, p.delivery_cost,
+		(SELECT count(*) FROM items i WHERE i.track_number = o.track_number)
+		FROM orders o
+		JOIN delivery d ON d.id = o.delivery_id
+		JOIN payment p ON p.id = o.payment_id
+		WHERE o.customer_id = $1 AND ($2 = 0 OR (o.date_created, o.id) %s ($3, $2))
+		ORDER BY o.date_created %s, o.id %s
+		LIMIT $4`, compare, direction, direction)
+
+	rows, err := db.Pool.Query(ctx, sql, query.CustomerID, query.AfterID, query.AfterDate, query.Limit)
+	if err != nil {
+		db.Log.Error(fmt.Sprintf("Error listing orders of customer %s from DB", query.CustomerID), err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []models.OrderSummary
+	for rows.Next() {
+		var order models.OrderSummary
+		err = rows.Scan(
+			&order.ID,
+			&order.OrderUid,
+			&order.TrackNumber,
+			&order.DateCreated,
+			&order.Status,
+			&order.DeliveryService,
+			&order.DeliveryCity,
+			&order.Currency,
+			&order.Amount,
+			&order.GoodsTotal,
+			&order.DeliveryCost,
+			&order.ItemCount,
+		)
+		if err != nil {
+			db.Log.Error("Error scanning customer order from DB", err)
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	if err = rows.Err(); err != nil {
+		db.Log.Error("Error iterating over customer orders", err)
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// markProcessed adds the event's idempotency key to the processed-messages
+// ledger and returns ErrDuplicateMessage if it is already there, or, for an
+// event to reapply, reports that it was seen. Events without a key, such as
+// orders loaded from files, are not tracked.
+func markProcessed(log logger.Logger, tx pgx.Tx, orderUid string, event models.OrderEvent) (bool, error) {
+	if event.IdempotencyKey == "" {
+		return false, nil
+	}
+
+	inserted, err := database.InsertProcessedMessage(log, tx, event.IdempotencyKey, event.EventType, orderUid)
+	if err != nil {
+		return false, fmt.Errorf("error recording processed message: %w", err)
+	}
+	if !inserted && !event.Reapply {
+		return false, ErrDuplicateMessage
+	}
+	return !inserted, nil
+}
+
+// recordEvent adds event to the order history and queues it in the outbox, so
+// it is published if and only if the transaction commits.
+func recordEvent(log logger.Logger, tx pgx.Tx, orderUid string, event *models.OrderEvent) error {
+	err := database.InsertOrderEvent(log, tx, event)
+	if err != nil {
+		return err
+	}
+
+	return database.InsertOutboxMessage(log, tx, &models.OutboxMessage{
+		OrderID:   event.OrderID,
+		OrderUid:  orderUid,
+		EventType: event.EventType,
+		Payload:   event.Payload,
+	})
+}
+
+// claimOutboxQuery selects the unsent outbox rows no other relay holds. A row
+// is only taken once every earlier row of its order was sent, so relays
+// running side by side never publish the events of one order out of order.
+const claimOutboxQuery = `SELECT o.id, o.order_id, o.order_uid, o.event_type, o.payload, o.created_at, o.attempts
+	FROM outbox o
+	WHERE o.sent_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM outbox e WHERE e.order_uid = o.order_uid AND e.sent_at IS NULL AND e.id < o.id)
+	ORDER BY o.id
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED`
+
+func (db *PostgresDBImpl) RelayOutbox(ctx context.Context, limit int, publish func([]models.OutboxMessage) (int, error)) (int, error) {
+	// The rows stay locked until the transaction ends, so other relays skip
+	// them while they are published.
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		db.Log.Error("Error starting outbox transaction", err)
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	messages, err := claimOutbox(ctx, tx, limit)
+	if err != nil {
+		db.Log.Error("Error claiming outbox messages", err)
+		return 0, err
+	}
+	if len(messages) == 0 {
+		return 0, tx.Commit(ctx)
+	}
+
+	sent, publishErr := publish(messages)
+	if sent > 0 {
+		ids := make([]int64, 0, sent)
+		for _, msg := range messages[:sent] {
+			ids = append(ids, msg.ID)
+		}
+		_, err = tx.Exec(ctx, "UPDATE outbox SET sent_at = now(), attempts = attempts + 1, last_error = NULL WHERE id = ANY($1)", ids)
+		if err != nil {
+			db.Log.Error("Error marking outbox messages sent", err)
+			return 0, err
+		}
+	}
+	if publishErr != nil && sent < len(messages) {
+		_, err = tx.Exec(ctx, "UPDATE outbox SET attempts = attempts + 1, last_error = $1 WHERE id = $2", publishErr.Error(), messages[sent].ID)
+		if err != nil {
+			db.Log.Error(fmt.Sprintf("Error recording outbox failure for message %d", messages[sent].ID), err)
+			return 0, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		db.Log.Error("Error committing outbox transaction", err)
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	if publishErr != nil {
+		return sent, &CallbackError{Err: publishErr}
+	}
+	return sent, nil
+}
+
+func claimOutbox(ctx context.Context, tx pgx.Tx, limit int) ([]models.OutboxMessage, error) {
+	rows, err := tx.Query(ctx, claimOutboxQuery, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.OutboxMessage
+	for rows.Next() {
+		var msg models.OutboxMessage
+		var payload []byte
+		err = rows.Scan(&msg.ID, &msg.OrderID, &msg.OrderUid, &msg.EventType, &payload, &msg.CreatedAt, &msg.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		msg.Payload = payload
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+func (db *PostgresDBImpl) DeleteSentOutbox(ctx context.Context, before time.Time, limit int) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM outbox WHERE id IN (
+		SELECT id FROM outbox WHERE sent_at < $1 LIMIT $2)`, before, limit)
+	if err != nil {
+		db.Log.Error("Error deleting sent outbox messages", err)
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (db *PostgresDBImpl) DeleteProcessedMessages(ctx context.Context, before time.Time, limit int) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM processed_messages WHERE idempotency_key IN (
+		SELECT idempotency_key FROM processed_messages WHERE processed_at < $1 LIMIT $2)`, before, limit)
+	if err != nil {
+		db.Log.Error("Error deleting expired processed messages", err)
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,170 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"wb-kafka-service/internal/models"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Groupings and sort orders of TopProducts.
+const (
+	ProductsByBrand = "brand"
+	ProductsByNmID  = "nm_id"
+
+	SortByQuantity = "quantity"
+	SortByRevenue  = "revenue"
+)
+
+// ReportRange limits reports to orders with From <= date_created < To. Both
+// are compared as strings with the RFC 3339 date_created, so a date such as
+// "2024-05-01" works; "" leaves that side open. Cancelled orders are never
+// counted.
+type ReportRange struct {
+	From string
+	To   string
+}
+
+// DateRange returns the range of orders created on the dates from to to,
+// inclusive, given as YYYY-MM-DD. An empty date leaves that side open.
+func DateRange(from, to string) (ReportRange, error) {
+	var rng ReportRange
+	if from != "" {
+		day, err := time.Parse(time.DateOnly, from)
+		if err != nil {
+			return rng, fmt.Errorf("invalid from date %q, use YYYY-MM-DD", from)
+		}
+		rng.From = day.Format(time.DateOnly)
+	}
+	if to != "" {
+		day, err := time.Parse(time.DateOnly, to)
+		if err != nil {
+			return rng, fmt.Errorf("invalid to date %q, use YYYY-MM-DD", to)
+		}
+		rng.To = day.AddDate(0, 0, 1).Format(time.DateOnly)
+	}
+	if rng.From != "" && rng.To != "" && rng.From >= rng.To {
+		return rng, fmt.Errorf("from date %s is after to date %s", from, to)
+	}
+	return rng, nil
+}
+
+// reportFilter is the WHERE clause matching ReportRange as $1 and $2 on orders o.
+const reportFilter = `o.status <> 'cancelled' AND ($1 = '' OR o.date_created >= $1) AND ($2 = '' OR o.date_created < $2)`
+
+func (db *PostgresDBImpl) RevenueByDay(ctx context.Context, rng ReportRange) ([]models.RevenueRow, error) {
+	sql := `SELECT substr(o.date_created, 1, 10) AS day, p.currency, p.provider, count(*), COALESCE(sum(p.amount), 0)
+		FROM orders o
+		JOIN payment p ON p.id = o.payment_id
+		WHERE ` + reportFilter + `
+		GROUP BY day, p.currency, p.provider
+		ORDER BY day, p.currency, p.provider`
+
+	return queryReport(ctx, db, "revenue by day", sql, func(rows pgx.Rows, row *models.RevenueRow) error {
+		return rows.Scan(&row.Day, &row.Currency, &row.Provider, &row.Orders, &row.Revenue)
+	}, rng.From, rng.To)
+}
+
+// TopProducts returns the limit best selling brands or nm_ids, see
+// ProductsByBrand and ProductsByNmID, by quantity or revenue. Sales are
+// ranked per payment currency, as amounts in different currencies can't be
+// added up, so up to limit rows are returned for every currency.
+func (db *PostgresDBImpl) TopProducts(ctx context.Context, rng ReportRange, by, sortBy string, limit int) ([]models.ProductRow, error) {
+	var key string
+	switch by {
+	case ProductsByBrand:
+		key = "i.brand"
+	case ProductsByNmID:
+		key = "i.nm_id::text"
+	default:
+		return nil, fmt.Errorf("unknown product grouping %q", by)
+	}
+	var order string
+	switch sortBy {
+	case SortByQuantity:
+		order = "quantity DESC, revenue DESC"
+	case SortByRevenue:
+		order = "revenue DESC, quantity DESC"
+	default:
+		return nil, fmt.Errorf("unknown product sort %q", sortBy)
+	}
+
+	sql := fmt.Sprintf(`SELECT key, currency, quantity, revenue FROM (
+			SELECT key, currency, quantity, revenue, row_number() OVER (PARTITION BY currency ORDER BY %s, key) AS rank
+			FROM (
+				SELECT %s AS key, p.currency, count(*) AS quantity, COALESCE(sum(i.total_price), 0) AS revenue
+				FROM items i
+				JOIN orders o ON o.track_number = i.track_number
+				JOIN payment p ON p.id = o.payment_id
+				WHERE `+reportFilter+`
+				GROUP BY key, p.currency
+			) totals
+		) ranked
+		WHERE rank <= $3
+		ORDER BY currency, rank`, order, key)
+
+	return queryReport(ctx, db, "top products", sql, func(rows pgx.Rows, row *models.ProductRow) error {
+		return rows.Scan(&row.Key, &row.Currency, &row.Quantity, &row.Revenue)
+	}, rng.From, rng.To, limit)
+}
+
+func (db *PostgresDBImpl) DeliveryCosts(ctx context.Context, rng ReportRange) ([]models.DeliveryCostRow, error) {
+	sql := `SELECT o.delivery_service, d.region, count(*), COALESCE(avg(p.delivery_cost), 0)::float8
+		FROM orders o
+		JOIN delivery d ON d.id = o.delivery_id
+		JOIN payment p ON p.id = o.payment_id
+		WHERE ` + reportFilter + `
+		GROUP BY o.delivery_service, d.region
+		ORDER BY o.delivery_service, d.region`
+
+	return queryReport(ctx, db, "delivery costs", sql, func(rows pgx.Rows, row *models.DeliveryCostRow) error {
+		return rows.Scan(&row.DeliveryService, &row.Region, &row.Orders, &row.AvgDeliveryCost)
+	}, rng.From, rng.To)
+}
+
+// SaleDistribution counts sold items by sale percentage in buckets of width
+// percentage points. Only non-empty buckets are returned.
+func (db *PostgresDBImpl) SaleDistribution(ctx context.Context, rng ReportRange, width int) ([]models.SaleBucket, error) {
+	sql := `SELECT (i.sale / $3) * $3 AS bucket, count(*), COALESCE(sum(i.total_price), 0)
+		FROM items i
+		JOIN orders o ON o.track_number = i.track_number
+		WHERE ` + reportFilter + `
+		GROUP BY bucket
+		ORDER BY bucket`
+
+	return queryReport(ctx, db, "sale distribution", sql, func(rows pgx.Rows, row *models.SaleBucket) error {
+		err := rows.Scan(&row.SaleFrom, &row.Items, &row.Revenue)
+		row.SaleTo = row.SaleFrom + width - 1
+		return err
+	}, rng.From, rng.To, width)
+}
+
+// queryReport runs a report query and scans every row with scan.
+func queryReport[T any](ctx context.Context, db *PostgresDBImpl, name, sql string, scan func(pgx.Rows, *T) error, args ...any) ([]T, error) {
+	rows, err := db.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		db.Log.Error(fmt.Sprintf("Error querying %s report", name), err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var report []T
+	for rows.Next() {
+		var row T
+		if err = scan(rows, &row); err != nil {
+			db.Log.Error(fmt.Sprintf("Error scanning %s report row", name), err)
+			return nil, err
+		}
+		report = append(report, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		db.Log.Error(fmt.Sprintf("Error iterating over %s report", name), err)
+		return nil, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often Keyed drops the buckets of idle keys.
+const sweepInterval = time.Minute
+
+// Keyed keeps a bucket per key, e.g. per client address. Buckets of keys that
+// have been idle long enough to refill are dropped, since a new bucket is
+// indistinguishable from them, so memory follows the number of active keys.
+type Keyed struct {
+	rate  float64
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+}
+
+// NewKeyed returns a limiter giving each key a bucket of rate and burst, see
+// NewBucket.
+func NewKeyed(rate float64, burst int) *Keyed {
+	return &Keyed{rate: rate, burst: burst, buckets: make(map[string]*Bucket), lastSweep: time.Now()}
+}
+
+// Take takes a token from the bucket of key, see Bucket.Take.
+func (k *Keyed) Take(key string) (bool, time.Duration) {
+	now := time.Now()
+	return k.bucket(key, now).take(now)
+}
+
+// Len returns the number of keys with a bucket.
+func (k *Keyed) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.buckets)
+}
+
+func (k *Keyed) bucket(key string, now time.Time) *Bucket {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if now.Sub(k.lastSweep) >= sweepInterval {
+		for key, b := range k.buckets {
+			if b.idle(now) {
+				delete(k.buckets, key)
+			}
+		}
+		k.lastSweep = now
+	}
+
+	b, ok := k.buckets[key]
+	if !ok {
+		b = NewBucket(k.rate, k.burst)
+		k.buckets[key] = b
+	}
+	return b
+}
@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket: it holds up to burst tokens and refills at rate
+// tokens per second. It is safe for concurrent use.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket. A burst below 1 is raised to 1.
+func NewBucket(rate float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Bucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Allow takes a token if one is available.
+func (b *Bucket) Allow() bool {
+	ok, _ := b.Take()
+	return ok
+}
+
+// Take takes a token if one is available. Otherwise it takes nothing and
+// returns how long until a token will be, or a negative duration if the bucket
+// never refills.
+func (b *Bucket) Take() (bool, time.Duration) {
+	return b.take(time.Now())
+}
+
+func (b *Bucket) take(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if b.rate <= 0 {
+		return false, -1
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Wait takes a token, waiting for one if needed. It returns ctx.Err() if ctx
+// is done first; the token is then not returned.
+func (b *Bucket) Wait(ctx context.Context) error {
+	delay := b.reserve(time.Now())
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve takes a token, possibly going into debt, and returns how long the
+// caller must wait until the token is really available.
+func (b *Bucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	if b.rate <= 0 {
+		// Never refills; keep the caller waiting until its context ends.
+		return time.Duration(1<<63 - 1)
+	}
+
+	b.tokens--
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// idle reports whether the bucket has been full since before now, in which
+// case it behaves like a new one.
+func (b *Bucket) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if !now.After(b.last) {
+		// now may have been read before the bucket was last touched.
+		return
+	}
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
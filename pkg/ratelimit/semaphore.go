@@ -0,0 +1,45 @@
+package ratelimit
+
+import "context"
+
+// Semaphore bounds how many callers run at once. It is safe for concurrent use.
+type Semaphore struct {
+	slots chan struct{}
+}
+
+// NewSemaphore returns a semaphore with n slots. An n below 1 is raised to 1.
+func NewSemaphore(n int) *Semaphore {
+	return &Semaphore{slots: make(chan struct{}, max(1, n))}
+}
+
+// Acquire takes a slot, waiting for one if needed. It returns ctx.Err() if ctx
+// is done first.
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken by Acquire.
+func (s *Semaphore) Release() {
+	<-s.slots
+}
+
+// InUse returns the number of slots taken.
+func (s *Semaphore) InUse() int {
+	return len(s.slots)
+}
+
+// Size returns the number of slots.
+func (s *Semaphore) Size() int {
+	return cap(s.slots)
+}
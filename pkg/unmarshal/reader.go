@@ -0,0 +1,210 @@
+package unmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"wb-kafka-service/internal/models"
+)
+
+// OrderExtensions are the extensions of the files OrderFiles picks from a
+// directory. .ndjson and .jsonl files are always read as NDJSON.
+var OrderExtensions = []string{".json", ".ndjson", ".jsonl"}
+
+// Position is where a value starts in its input.
+type Position struct {
+	File string
+	Line int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// OrderFunc receives each value ReadOrders decodes, or a nil order and the
+// error decoding it. An error it returns stops ReadOrders.
+type OrderFunc func(pos Position, order *models.Order, err error) error
+
+// ReadOrders decodes the orders in r, which holds a JSON object, a JSON array
+// of objects, concatenated objects or NDJSON, and calls fn with every one.
+// name is the file of the positions.
+//
+// NDJSON is read a line at a time and a line that doesn't decode is passed to
+// fn as an error before going on with the next line. Other input is read
+// whole; a syntax error ends it, as the rest can't be split into values. It
+// is NDJSON if name has an NDJSON extension or its first line is a complete
+// JSON object.
+func ReadOrders(name string, r io.Reader, fn OrderFunc) error {
+	in := bufio.NewReader(r)
+
+	// Find the first non-blank line to tell the format.
+	var head, first []byte
+	line := 1
+	for {
+		data, err := in.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		head = append(head, data...)
+		if first = bytes.TrimSpace(data); len(first) > 0 || err == io.EOF {
+			break
+		}
+		line++
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".ndjson" || ext == ".jsonl" || (bytes.HasPrefix(first, []byte("{")) && json.Valid(first)) {
+		return readLines(name, line, first, in, fn)
+	}
+
+	rest, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	return readDocument(name, append(head, rest...), fn)
+}
+
+// readLines reads NDJSON, starting with first at line.
+func readLines(name string, line int, first []byte, in *bufio.Reader, fn OrderFunc) error {
+	data := first
+	for {
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			order, decodeErr := decode(func(order *models.Order) error { return json.Unmarshal(data, order) })
+			if err := fn(Position{File: name, Line: line}, order, decodeErr); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		data, err = in.ReadBytes('\n')
+		if err == io.EOF && len(data) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line++
+	}
+}
+
+// readDocument reads a JSON array of orders or a sequence of JSON values.
+func readDocument(name string, data []byte, fn OrderFunc) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	lines := lineCounter{data: data, line: 1}
+	// next skips the characters in skip and returns the position of the next
+	// value, or false at the end of data.
+	next := func(skip string) (Position, bool) {
+		offset := int(dec.InputOffset())
+		for offset < len(data) && strings.IndexByte(skip, data[offset]) >= 0 {
+			offset++
+		}
+		return Position{File: name, Line: lines.at(offset)}, offset < len(data)
+	}
+
+	start := len(data) - len(bytes.TrimLeft(data, " \t\r\n"))
+	if start == len(data) {
+		return nil
+	}
+	pos := Position{File: name, Line: lines.at(start)}
+	inArray := data[start] == '['
+	if inArray {
+		if _, err := dec.Token(); err != nil {
+			return fn(pos, nil, jsonError(err))
+		}
+	}
+
+	for {
+		skip := " \t\r\n"
+		if inArray {
+			skip += ","
+			if !dec.More() {
+				break
+			}
+		}
+		var more bool
+		if pos, more = next(skip); !more {
+			break
+		}
+
+		order, decodeErr := decode(func(order *models.Order) error { return dec.Decode(order) })
+		var syntaxErr *json.SyntaxError
+		if errors.As(decodeErr, &syntaxErr) || errors.Is(decodeErr, io.ErrUnexpectedEOF) {
+			// The decoder can't go on past a syntax error.
+			return fn(pos, nil, decodeErr)
+		}
+		if err := fn(pos, order, decodeErr); err != nil {
+			return err
+		}
+	}
+
+	if inArray {
+		if _, err := dec.Token(); err != nil {
+			return fn(pos, nil, jsonError(err))
+		}
+		if pos, more := next(" \t\r\n"); more {
+			return fn(pos, nil, errors.New("invalid JSON: data after the array"))
+		}
+	}
+	return nil
+}
+
+// lineCounter turns increasing offsets into line numbers.
+type lineCounter struct {
+	data   []byte
+	offset int
+	line   int
+}
+
+func (c *lineCounter) at(offset int) int {
+	c.line += bytes.Count(c.data[c.offset:offset], []byte("\n"))
+	c.offset = offset
+	return c.line
+}
+
+// decode decodes an order with unmarshal, returning nil and the error if it fails.
+func decode(unmarshal func(*models.Order) error) (*models.Order, error) {
+	var order models.Order
+	if err := unmarshal(&order); err != nil {
+		return nil, jsonError(err)
+	}
+	return &order, nil
+}
+
+func jsonError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("invalid JSON: %w", err)
+}
+
+// OrderFiles returns path if it is a file, or else the files with an
+// OrderExtensions extension in directory path and its subdirectories, sorted.
+func OrderFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && slices.Contains(OrderExtensions, strings.ToLower(filepath.Ext(file))) {
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, err
+}
@@ -1,31 +1,37 @@
 package unmarshal
 
 import (
-	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"wb-kafka-service/internal/models"
 	"wb-kafka-service/pkg/logger"
 )
 
+// ReadOrdersFromFiles reads the orders in jsonFiles, see ReadOrders. Files
+// and values that can't be read are logged with their position and skipped.
 func ReadOrdersFromFiles(log logger.Logger, jsonFiles []string) []models.Order {
 	var orders []models.Order
 
 	for _, jsonFile := range jsonFiles {
-		jsonData, err := os.ReadFile(jsonFile)
+		file, err := os.Open(jsonFile)
 		if err != nil {
 			log.Error("Error reading JSON file", err)
 			continue 
 		}
 
-		var order models.Order
-		err = json.Unmarshal(jsonData, &order)
+		err = ReadOrders(jsonFile, file, func(pos Position, order *models.Order, err error) error {
+			if err != nil {
+				log.Error(fmt.Sprintf("Error unmarshalling JSON at %s", pos), err)
+				return nil
+			}
+			orders = append(orders, *order)
+			return nil
+		})
+		file.Close()
 		if err != nil {
-			log.Error("Error unmarshalling JSON", err)
-			continue 
+			log.Error(fmt.Sprintf("Error reading JSON file %s", jsonFile), err)
 		}
-
-		orders = append(orders, order)
 	}
 
 	return orders